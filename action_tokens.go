@@ -0,0 +1,202 @@
+package auth
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+var (
+	ErrActionTokenInvalid = errors.New("invalid or already used token")
+	ErrActionTokenExpired = errors.New("token expired")
+)
+
+// actionTokenStore persists single-use tokens sent by email, such as
+// password reset links. Only a SHA-256 digest of each token is stored. Every
+// table using it has the id, user_id, token_hash, expires_at, used_at and
+// created_at columns.
+type actionTokenStore struct {
+	db     database.Database
+	table  string
+	ttl    time.Duration
+	purges purgeSchedule
+}
+
+// issue creates a token for the user and invalidates the tokens issued
+// before, so only the latest link works.
+func (s *actionTokenStore) issue(ctx stdcontext.Context, userID uuid.UUID) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	if err := s.invalidateUser(ctx, s.db, userID); err != nil {
+		return "", err
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Insert(s.table).
+		Columns("id", "user_id", "token_hash", "expires_at", "created_at").
+		Values(uuid.New(), userID, hashOpaqueToken(token), now.Add(s.ttl), now).
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+
+	if s.purges.due() {
+		if err := s.purge(ctx); err != nil {
+			log.Printf("[gorest-auth] %s purge: %v", s.table, err)
+		}
+	}
+
+	return token, nil
+}
+
+// purge deletes the used and expired tokens. issue runs it every
+// tokenPurgeInterval tokens.
+func (s *actionTokenStore) purge(ctx stdcontext.Context) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete(s.table).
+		Where(query.Or(query.Lt("expires_at", time.Now()), query.IsNotNull("used_at"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to delete stale tokens: %w", err)
+	}
+
+	return nil
+}
+
+// issuedWithin reports whether a token was issued to the user in the last
+// window, used or not.
+func (s *actionTokenStore) issuedWithin(ctx stdcontext.Context, userID uuid.UUID, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return false, nil
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id").
+		From(s.table).
+		Where(query.Eq("user_id", userID)).
+		Where(query.Gt("created_at", time.Now().Add(-window))).
+		Limit(1).
+		Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var id uuid.UUID
+	err = s.db.QueryRow(ctx, queryStr, args...).Scan(&id)
+	if crud.IsNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+
+	return true, nil
+}
+
+// peek returns the user a valid token was issued to, without using it.
+func (s *actionTokenStore) peek(ctx stdcontext.Context, token string) (uuid.UUID, error) {
+	_, userID, err := s.find(ctx, s.db, token)
+	return userID, err
+}
+
+// consume marks the token as used and returns the user it was issued to.
+// Run it in the transaction applying the action so a failure leaves the
+// token usable.
+func (s *actionTokenStore) consume(ctx stdcontext.Context, exec queryExecutor, token string) (uuid.UUID, error) {
+	id, userID, err := s.find(ctx, exec, token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update(s.table).
+		Set("used_at", time.Now()).
+		Where(query.Eq("id", id)).
+		Where(query.IsNull("used_at")).
+		Build()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := exec.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to consume token: %w", err)
+	}
+
+	// Another request used the token between our read and write.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return uuid.Nil, ErrActionTokenInvalid
+	}
+
+	return userID, nil
+}
+
+// find returns the id of a valid token and the user it was issued to.
+func (s *actionTokenStore) find(ctx stdcontext.Context, exec queryExecutor, token string) (uuid.UUID, uuid.UUID, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id", "user_id", "expires_at", "used_at").
+		From(s.table).
+		Where(query.Eq("token_hash", hashOpaqueToken(token))).
+		Build()
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var id, userID uuid.UUID
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err = exec.QueryRow(ctx, queryStr, args...).Scan(&id, &userID, &expiresAt, &usedAt)
+	if crud.IsNotFoundError(err) {
+		return uuid.Nil, uuid.Nil, ErrActionTokenInvalid
+	}
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if usedAt != nil {
+		return uuid.Nil, uuid.Nil, ErrActionTokenInvalid
+	}
+	if time.Now().After(expiresAt) {
+		return uuid.Nil, uuid.Nil, ErrActionTokenExpired
+	}
+
+	return id, userID, nil
+}
+
+func (s *actionTokenStore) invalidateUser(ctx stdcontext.Context, exec queryExecutor, userID uuid.UUID) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update(s.table).
+		Set("used_at", time.Now()).
+		Where(query.Eq("user_id", userID)).
+		Where(query.IsNull("used_at")).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to invalidate tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+package auth
+
+import (
+	stdcontext "context"
+
+	"github.com/nicolasbonnici/gorest-auth/models"
+)
+
+// ClaimsProvider adds application specific claims, such as a tenant id or
+// feature flags, to the access tokens issued for a user. Registered claims
+// (sub, exp, iss, ...) cannot be overridden.
+type ClaimsProvider interface {
+	Claims(ctx stdcontext.Context, user *models.User) (map[string]any, error)
+}
+
+type ClaimsProviderFunc func(ctx stdcontext.Context, user *models.User) (map[string]any, error)
+
+func (f ClaimsProviderFunc) Claims(ctx stdcontext.Context, user *models.User) (map[string]any, error) {
+	return f(ctx, user)
+}
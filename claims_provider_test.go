@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nicolasbonnici/gorest-auth/models"
+)
+
+type tenantKey struct{}
+
+var tenantClaims = ClaimsProviderFunc(func(ctx context.Context, user *models.User) (map[string]any, error) {
+	return map[string]any{
+		"tenant_id": ctx.Value(tenantKey{}),
+		"email":     user.Email,
+		"features":  []any{"beta"},
+	}, nil
+})
+
+func TestClaimsProvider(t *testing.T) {
+	services := map[string]interface {
+		TokenService
+		SetClaimsProvider(ClaimsProvider)
+	}{
+		"jwt":           newTestJWTService(t),
+		"paseto public": newTestPasetoPublicService(t),
+	}
+
+	for name, service := range services {
+		t.Run(name, func(t *testing.T) {
+			service.SetClaimsProvider(tenantClaims)
+
+			ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+			user := testUser()
+			token, err := service.GenerateToken(ctx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := service.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Custom["tenant_id"] != "acme" || claims.Custom["email"] != user.Email {
+				t.Fatalf("unexpected custom claims: %v", claims.Custom)
+			}
+			if features, ok := claims.Custom["features"].([]any); !ok || len(features) != 1 || features[0] != "beta" {
+				t.Fatalf("unexpected features claim: %v", claims.Custom["features"])
+			}
+			if claims.Subject != user.ID.String() {
+				t.Fatalf("unexpected subject %s", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestClaimsProviderCannotOverrideReservedClaims(t *testing.T) {
+	service := newTestJWTService(t)
+
+	for _, name := range []string{"sub", "exp", "iss", "aud", "jti", "roles", "restriction"} {
+		service.SetClaimsProvider(ClaimsProviderFunc(func(context.Context, *models.User) (map[string]any, error) {
+			return map[string]any{name: "forged"}, nil
+		}))
+		if _, err := service.GenerateToken(context.Background(), testUser()); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+
+	failure := errors.New("tenant lookup failed")
+	service.SetClaimsProvider(ClaimsProviderFunc(func(context.Context, *models.User) (map[string]any, error) {
+		return nil, failure
+	}))
+	if _, err := service.GenerateToken(context.Background(), testUser()); !errors.Is(err, failure) {
+		t.Fatalf("expected the provider error, got %v", err)
+	}
+}
+
+func TestPluginClaimsProvider(t *testing.T) {
+	app, plugin, db := newTestApp(t, map[string]interface{}{"claims_provider": tenantClaims})
+	createTestUser(t, db, "jane@example.com", "user")
+
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	claims, err := plugin.TokenService().ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Custom["email"] != "jane@example.com" {
+		t.Fatalf("unexpected custom claims: %v", claims.Custom)
+	}
+
+	plugin.SetClaimsProvider(ClaimsProviderFunc(func(context.Context, *models.User) (map[string]any, error) {
+		return map[string]any{"plan": "pro"}, nil
+	}))
+	token, _ = login(t, app, "jane@example.com", testPassword)
+	if claims, err := plugin.TokenService().ValidateToken(token); err != nil || claims.Custom["plan"] != "pro" {
+		t.Fatalf("expected the new provider to be used: %v %v", claims, err)
+	}
+}
@@ -0,0 +1,122 @@
+// Command hibp-bloom builds the bloom filter read by
+// password.OpenBreachChecker from the Pwned Passwords SHA-1 file, one
+// HASH:COUNT line per password.
+//
+//	hibp-bloom -in pwnedpasswords.txt -out pwned.bloom -fp 0.001
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/nicolasbonnici/gorest-auth/password"
+)
+
+func main() {
+	in := flag.String("in", "", "Pwned Passwords SHA-1 file (required)")
+	out := flag.String("out", "", "bloom filter file to write (required)")
+	falsePositiveRate := flag.Float64("fp", 0.001, "false positive rate")
+	minCount := flag.Uint64("min-count", 0, "skip passwords seen fewer times")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*in, *out, *falsePositiveRate, *minCount); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(in, out string, falsePositiveRate float64, minCount uint64) error {
+	count, err := forEachHash(in, minCount, func([sha1.Size]byte) {})
+	if err != nil {
+		return err
+	}
+
+	filter, err := password.NewBloomFilter(count, falsePositiveRate)
+	if err != nil {
+		return err
+	}
+
+	if _, err := forEachHash(in, minCount, filter.Add); err != nil {
+		return err
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	size, err := filter.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+
+	log.Printf("wrote %d hashes to %s (%d bytes)", count, out, size)
+	return nil
+}
+
+// forEachHash calls fn with every hash of the file seen at least minCount
+// times and returns how many there were.
+func forEachHash(path string, minCount uint64, fn func([sha1.Size]byte)) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	var count, lineNumber uint64
+	var digest [sha1.Size]byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == io.EOF && len(line) == 0 {
+			return count, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		lineNumber++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		hash, seen, _ := bytes.Cut(line, []byte(":"))
+		if _, decodeErr := hex.Decode(digest[:], hash); decodeErr != nil || len(hash) != 2*sha1.Size {
+			return 0, fmt.Errorf("%s:%d: invalid SHA-1 hash", path, lineNumber)
+		}
+
+		if minCount > 0 {
+			var times uint64
+			if _, scanErr := fmt.Sscan(string(seen), &times); scanErr != nil || times < minCount {
+				continue
+			}
+		}
+
+		fn(digest)
+		count++
+
+		if err == io.EOF {
+			return count, nil
+		}
+	}
+}
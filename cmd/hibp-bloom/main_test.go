@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nicolasbonnici/gorest-auth/password"
+)
+
+func writeHashes(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func hashLine(plain string, count int) string {
+	sum := sha1.Sum([]byte(plain))
+	return fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), count)
+}
+
+func TestRun(t *testing.T) {
+	in := writeHashes(t, hashLine("password", 100), hashLine("letmein", 2), "", hashLine("sunshine", 50))
+	out := filepath.Join(t.TempDir(), "pwned.bloom")
+
+	if err := run(in, out, 0.0001, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := password.OpenBreachChecker(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		breached bool
+	}{
+		{"password", true},
+		{"sunshine", true},
+		// Seen fewer times than the minimum count.
+		{"letmein", false},
+		{"correct-horse-battery", false},
+	}
+	for _, tt := range tests {
+		if breached, err := checker.Breached(tt.password); err != nil || breached != tt.breached {
+			t.Fatalf("%s: expected breached to be %t, got %v %v", tt.password, tt.breached, breached, err)
+		}
+	}
+}
+
+func TestRunRejectsInvalidHashes(t *testing.T) {
+	in := writeHashes(t, hashLine("password", 1), "not-a-hash:1")
+
+	err := run(in, filepath.Join(t.TempDir(), "pwned.bloom"), 0.001, 0)
+	if err == nil || !strings.Contains(err.Error(), ":2: invalid SHA-1 hash") {
+		t.Fatalf("expected the invalid line to be reported, got %v", err)
+	}
+}
@@ -5,10 +5,12 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	authcontext "github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
 )
 
 type AuthenticatedUser struct {
 	UserID string
+	Claims *tokens.Claims
 }
 
 // This is a compatibility function for the codegen
@@ -23,7 +25,10 @@ func GetAuthenticatedUser(c *fiber.Ctx) *AuthenticatedUser {
 		return nil
 	}
 
+	claims, _ := authcontext.GetClaims(c)
+
 	return &AuthenticatedUser{
 		UserID: userID,
+		Claims: claims,
 	}
 }
@@ -1,6 +1,16 @@
 package auth
 
 import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/middleware"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/revocation"
+	"github.com/nicolasbonnici/gorest-auth/webauthn"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/rbac"
 )
@@ -9,12 +19,365 @@ type Config struct {
 	Database  database.Database
 	JWTSecret string
 	JWTTTL    int
+
+	// TokenFormat selects the access token format: "jwt" (default),
+	// "paseto.v4.public" or "paseto.v4.local". v4.public signs with the
+	// Ed25519 key configured through the JWT key settings.
+	TokenFormat string
+
+	// PasetoLocalKey is the hex encoded 32 byte key of paseto.v4.local tokens.
+	PasetoLocalKey string
+
+	// JWTAlgorithm overrides the algorithm inferred from the configured key,
+	// e.g. RS512 for an RSA key. Defaults to HS256 when only a secret is set.
+	JWTAlgorithm string
+
+	// PEM encoded private key (inline or from a file) used to sign tokens
+	// with RS*, ES* or EdDSA. Takes precedence over JWTSecret.
+	JWTPrivateKey     string
+	JWTPrivateKeyFile string
+
+	// PEM encoded public key for verify-only deployments that never issue
+	// tokens themselves.
+	JWTPublicKey     string
+	JWTPublicKeyFile string
+
+	// JWTKeyID is stamped into the "kid" header of issued tokens. Derived
+	// from the key when empty.
+	JWTKeyID string
+
+	// JWTPreviousKeys are retired keys whose tokens are still accepted.
+	JWTPreviousKeys []KeyConfig
+
+	// Issuer is the public base URL of the auth service. It is advertised by
+	// the discovery document and, when set, stamped into and required as the
+	// "iss" claim of tokens.
+	Issuer string
+
+	// Audiences are stamped into the "aud" claim of issued tokens; validated
+	// tokens must target at least one of them.
+	Audiences []string
+
+	// ClockSkew is the tolerance, in seconds, applied to the exp, nbf and iat
+	// claims to absorb clock drift between servers.
+	ClockSkew int
+
+	// DiscoveryCacheMaxAge is the Cache-Control max-age, in seconds, of the
+	// JWKS and discovery documents. A discovery document derived from the
+	// request, without Issuer, is never cached.
+	DiscoveryCacheMaxAge int
+
+	// RefreshTokenTTL is the lifetime, in seconds, of refresh tokens. Each
+	// rotation issues a token valid for the full TTL.
+	RefreshTokenTTL int
+
+	// RevocationStore records logged out tokens. Set by the plugin from the
+	// "revocation_store" option ("database" or "memory").
+	RevocationStore revocation.Store
+
+	// ClaimsProvider adds custom claims to issued tokens.
+	ClaimsProvider ClaimsProvider
+
+	// EmbedRoles signs the user role into access tokens and makes the auth
+	// middleware trust it instead of querying the database. A role change
+	// only applies once the user gets a new access token.
+	EmbedRoles bool
+
+	// RoleCacheTTL is how long, in seconds, roles fetched by the auth
+	// middleware are cached. Zero disables the cache.
+	RoleCacheTTL int
+
+	// RoleCache is built by the plugin from RoleCacheTTL.
+	RoleCache *middleware.RoleCache
+
+	// IntrospectionClients may call POST /auth/introspect. The endpoint is
+	// disabled when empty.
+	IntrospectionClients []IntrospectionClient
+
+	// Mailer sends the emails of the password reset and email change flows.
+	// Their endpoints are disabled without it.
+	Mailer mailer.Mailer
+
+	// MailFrom is the sender address of every email.
+	MailFrom string
+
+	// MailTemplates renders emails by message type and locale. Defaults to
+	// the built-in English templates.
+	MailTemplates *mailer.Templates
+
+	// PasswordResetURL is the frontend page receiving the reset token as the
+	// "token" query parameter.
+	PasswordResetURL string
+
+	// PasswordResetTokenTTL is the lifetime, in seconds, of reset links.
+	PasswordResetTokenTTL int
+
+	// PasswordResetCooldown is how long, in seconds, an account waits before
+	// it can be sent another reset email. Zero disables the wait.
+	PasswordResetCooldown int
+
+	// PasswordResetIPLimit is how many reset emails a client IP address can
+	// request per minute. Zero disables the limit.
+	PasswordResetIPLimit int
+
+	// EmailVerification enables email verification at registration:
+	// EmailVerificationOptional, EmailVerificationRestrict or
+	// EmailVerificationBlock. Disabled when empty.
+	EmailVerification string
+
+	// EmailVerificationURL is the frontend page receiving the verification
+	// token as the "token" query parameter.
+	EmailVerificationURL string
+
+	// EmailVerificationTokenTTL is the lifetime, in seconds, of verification
+	// links.
+	EmailVerificationTokenTTL int
+
+	// EmailVerificationCooldown is how long, in seconds, an account waits
+	// before it can be sent another verification email. Zero disables the
+	// wait.
+	EmailVerificationCooldown int
+
+	// EmailVerificationIPLimit is how many verification emails a client IP
+	// address can request per minute. Zero disables the limit.
+	EmailVerificationIPLimit int
+
+	// EmailChangeURL is the frontend page receiving the token confirming a new
+	// email address as the "token" query parameter.
+	EmailChangeURL string
+
+	// EmailChangeRevertURL is the frontend page receiving the token reverting
+	// an email change as the "token" query parameter.
+	EmailChangeRevertURL string
+
+	// EmailChangeTokenTTL is the lifetime, in seconds, of confirmation links.
+	EmailChangeTokenTTL int
+
+	// EmailChangeRevertTTL is how long, in seconds, the previous address can
+	// revert an email change.
+	EmailChangeRevertTTL int
+
+	// PasswordHasher hashes new passwords. Hashes made by another algorithm
+	// or with weaker parameters are upgraded on login. Defaults to argon2id.
+	// When a pepper is configured, it is a password.PepperedHasher.
+	PasswordHasher password.Hasher
+
+	// PasswordPolicy is checked at registration, password reset, password
+	// change and user updates.
+	PasswordPolicy *password.Policy
+
+	// PasswordExpiryDays is how many days a password can be used before
+	// login only grants a token restricted to changing it. Zero disables
+	// expiry.
+	PasswordExpiryDays int
+
+	// MFAIssuer names the service in authenticator apps.
+	MFAIssuer string
+
+	// MFAChallengeTTL is how long, in seconds, a user has to enter their
+	// second factor after their password.
+	MFAChallengeTTL int
+
+	// MFAEncryptionKey is the hex encoded 32 byte key sealing TOTP secrets
+	// at rest. Secrets are stored in clear when empty.
+	MFAEncryptionKey string
+
+	// MFARequiredRoles are the roles, among the ones of GetRBACConfig, whose
+	// users must log in with a second factor. Until they enroll one, login
+	// only grants a token restricted to enrolling it.
+	MFARequiredRoles []string
+
+	// AdminStepUpMaxAge is how long, in seconds, after logging in with a
+	// second factor an admin may change roles or delete users. Zero disables
+	// the check.
+	AdminStepUpMaxAge int
+
+	// WebAuthnRPID is the domain passkeys are registered for, e.g.
+	// "example.com". WebAuthn endpoints are disabled when empty.
+	WebAuthnRPID string
+
+	// WebAuthnRPName is the service name shown by authenticators.
+	WebAuthnRPName string
+
+	// WebAuthnOrigins are the exact origins of the pages running the
+	// ceremonies, e.g. "https://app.example.com".
+	WebAuthnOrigins []string
+
+	// WebAuthnTimeout is how long, in seconds, a WebAuthn ceremony may take.
+	WebAuthnTimeout int
+
+	// MagicLink enables passwordless login with a link or a one-time code
+	// sent by email. Requires a mailer.
+	MagicLink bool
+
+	// MagicLinkURL is the frontend page receiving the login token as the
+	// "token" query parameter. Emails only contain the code when empty.
+	MagicLinkURL string
+
+	// MagicLinkTTL is the lifetime, in seconds, of login links and codes.
+	MagicLinkTTL int
+
+	// MagicLinkAutoRegister creates an account for unknown email addresses
+	// logging in with a link or code.
+	MagicLinkAutoRegister bool
+
+	// MagicLinkCooldown is how long, in seconds, an email address waits
+	// before it can be sent another login email. Zero disables the wait.
+	MagicLinkCooldown int
+
+	// MagicLinkIPLimit is how many login emails a client IP address can
+	// request per minute. Zero disables the limit.
+	MagicLinkIPLimit int
+
+	// PasswordHistory is how many recent passwords, the current one included,
+	// a user cannot reuse. Zero disables the check.
+	PasswordHistory int
+
+	// legacyJWTSecret accepts a JWTSecret shorter than MinHMACSecretLength
+	// with a deprecation warning. The plugin sets it for the jwt_secret
+	// option, which predates the length check, and for the secrets of
+	// jwt_previous_keys.
+	legacyJWTSecret bool
+}
+
+// KeyConfig describes a single signing or verification key.
+type KeyConfig struct {
+	ID             string
+	Algorithm      string
+	Secret         string
+	PrivateKey     string
+	PrivateKeyFile string
+	PublicKey      string
+	PublicKeyFile  string
+
+	legacySecret bool
 }
 
 func DefaultConfig() Config {
 	return Config{
-		JWTTTL: 900,
+		JWTTTL:                    900,
+		ClockSkew:                 30,
+		DiscoveryCacheMaxAge:      300,
+		RefreshTokenTTL:           2592000,
+		PasswordResetTokenTTL:     3600,
+		PasswordResetCooldown:     60,
+		PasswordResetIPLimit:      10,
+		EmailVerificationTokenTTL: 86400,
+		EmailVerificationCooldown: 60,
+		EmailVerificationIPLimit:  10,
+		EmailChangeTokenTTL:       86400,
+		EmailChangeRevertTTL:      604800,
+		MFAChallengeTTL:           300,
+		WebAuthnTimeout:           300,
+		MagicLinkTTL:              900,
+		MagicLinkCooldown:         60,
+		MagicLinkIPLimit:          10,
+		MailTemplates:             mailer.NewTemplates(),
+		PasswordHasher:            password.NewArgon2idHasher(password.DefaultArgon2idParams),
+		PasswordPolicy:            password.DefaultPolicy(),
+	}
+}
+
+// SigningKey builds the token key described by the configuration.
+func (c Config) SigningKey() (*SigningKey, error) {
+	return KeyConfig{
+		ID:             c.JWTKeyID,
+		Algorithm:      c.JWTAlgorithm,
+		Secret:         c.JWTSecret,
+		PrivateKey:     c.JWTPrivateKey,
+		PrivateKeyFile: c.JWTPrivateKeyFile,
+		PublicKey:      c.JWTPublicKey,
+		PublicKeyFile:  c.JWTPublicKeyFile,
+		legacySecret:   c.legacyJWTSecret,
+	}.SigningKey()
+}
+
+// Keyring builds the keyring made of the current key and the previous keys.
+func (c Config) Keyring() (*Keyring, error) {
+	current, err := c.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make([]*SigningKey, 0, len(c.JWTPreviousKeys))
+	for i, keyConfig := range c.JWTPreviousKeys {
+		key, err := keyConfig.SigningKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous key %d: %w", i, err)
+		}
+		previous = append(previous, key)
+	}
+
+	return NewKeyring(current, previous...)
+}
+
+func (k KeyConfig) SigningKey() (*SigningKey, error) {
+	var key *SigningKey
+	var err error
+
+	switch {
+	case k.PrivateKey != "":
+		key, err = ParsePrivateKeyPEM([]byte(k.PrivateKey), k.Algorithm)
+	case k.PrivateKeyFile != "":
+		key, err = LoadPrivateKeyFile(k.PrivateKeyFile, k.Algorithm)
+	case k.PublicKey != "":
+		key, err = ParsePublicKeyPEM([]byte(k.PublicKey), k.Algorithm)
+	case k.PublicKeyFile != "":
+		key, err = LoadPublicKeyFile(k.PublicKeyFile, k.Algorithm)
+	case k.Secret != "" && k.legacySecret:
+		key, err = newLegacyHMACKey([]byte(k.Secret), k.Algorithm)
+	case k.Secret != "":
+		key, err = NewHMACKey([]byte(k.Secret), k.Algorithm)
+	default:
+		return nil, fmt.Errorf("no key material configured: set a secret, a private key or a public key")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = k.ID
+	return key, nil
+}
+
+// RelyingParty builds the WebAuthn relying party described by the
+// configuration.
+func (c Config) RelyingParty() (*webauthn.RelyingParty, error) {
+	return webauthn.New(webauthn.Config{
+		RPID:    c.WebAuthnRPID,
+		RPName:  c.WebAuthnRPName,
+		Origins: c.WebAuthnOrigins,
+		Timeout: time.Duration(c.WebAuthnTimeout) * time.Second,
+	})
+}
+
+// MiddlewareOptions returns the auth middleware options matching the
+// configuration.
+func (c Config) MiddlewareOptions() []middleware.Option {
+	var opts []middleware.Option
+	if c.EmbedRoles {
+		opts = append(opts, middleware.WithTokenRoles())
+	}
+	if c.RoleCache != nil {
+		opts = append(opts, middleware.WithRoleCache(c.RoleCache))
+	}
+	return opts
+}
+
+// mfaRequired reports whether the role of the user requires a second factor.
+func (c Config) mfaRequired(user *models.User) bool {
+	return slices.Contains(c.MFARequiredRoles, user.Role)
+}
+
+// rbacRoles lists the roles known to GetRBACConfig.
+func rbacRoles() []string {
+	config := GetRBACConfig()
+	roles := []string{config.SuperuserRole}
+	for role, inherited := range config.RoleHierarchy {
+		roles = append(roles, role)
+		roles = append(roles, inherited...)
 	}
+	slices.Sort(roles)
+	return slices.Compact(roles)
 }
 
 func GetRBACConfig() rbac.Config {
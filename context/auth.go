@@ -1,8 +1,15 @@
 package context
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
 
-const userIDKey = "user_id"
+const (
+	userIDKey = "user_id"
+	tokenKey  = "auth_token"
+	claimsKey = "auth_claims"
+)
 
 func SetUserID(c *fiber.Ctx, userID string) {
 	c.Locals(userIDKey, userID)
@@ -17,3 +24,23 @@ func MustGetUserID(c *fiber.Ctx) string {
 	userID, _ := GetUserID(c)
 	return userID
 }
+
+// SetToken stores the raw bearer token the request was authenticated with.
+func SetToken(c *fiber.Ctx, token string) {
+	c.Locals(tokenKey, token)
+}
+
+func GetToken(c *fiber.Ctx) (string, bool) {
+	token, ok := c.Locals(tokenKey).(string)
+	return token, ok
+}
+
+// SetClaims stores the validated claims of the request token.
+func SetClaims(c *fiber.Ctx, claims *tokens.Claims) {
+	c.Locals(claimsKey, claims)
+}
+
+func GetClaims(c *fiber.Ctx) (*tokens.Claims, bool) {
+	claims, ok := c.Locals(claimsKey).(*tokens.Claims)
+	return claims, ok
+}
@@ -19,6 +19,8 @@ func (c *UserConverter) CreateDTOToModel(dto dtos.UserCreateDTO) models.User {
 		Firstname: dto.Firstname,
 		Lastname:  dto.Lastname,
 		CreatedAt: time.Now(),
+
+		MustChangePassword: new(bool),
 	}
 }
 
@@ -36,6 +38,10 @@ func (c *UserConverter) UpdateDTOToModel(dto dtos.UserUpdateDTO) models.User {
 	if dto.Lastname != nil {
 		user.Lastname = *dto.Lastname
 	}
+	if dto.Role != nil {
+		user.Role = *dto.Role
+	}
+	user.MustChangePassword = dto.MustChangePassword
 	return user
 }
 
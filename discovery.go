@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+const (
+	jwksPath      = "/.well-known/jwks.json"
+	discoveryPath = "/.well-known/openid-configuration"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type OpenIDConfiguration struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// JWKS returns the public keys of the keyring. HMAC keys are never published.
+func (k *Keyring) JWKS() (JWKS, error) {
+	set := JWKS{Keys: []JWK{}}
+
+	for _, key := range k.Keys() {
+		if key.isHMAC() {
+			continue
+		}
+
+		params, err := publicKeyParams(key.verifyKey)
+		if err != nil {
+			return JWKS{}, fmt.Errorf("failed to encode key %s: %w", key.ID, err)
+		}
+
+		set.Keys = append(set.Keys, JWK{
+			Kty: params["kty"],
+			Kid: key.ID,
+			Alg: key.Algorithm,
+			Use: "sig",
+			Crv: params["crv"],
+			N:   params["n"],
+			E:   params["e"],
+			X:   params["x"],
+			Y:   params["y"],
+		})
+	}
+
+	return set, nil
+}
+
+func RegisterDiscoveryRoutes(router fiber.Router, jwt *JWTService, config Config) {
+	router.Get(jwksPath, handleJWKS(jwt, config.DiscoveryCacheMaxAge))
+	router.Get(discoveryPath, handleOpenIDConfiguration(jwt, config.Issuer, config.DiscoveryCacheMaxAge))
+}
+
+func handleJWKS(jwt *JWTService, maxAge int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		set, err := jwt.Keyring().JWKS()
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to encode signing keys")
+		}
+
+		return sendCacheableJSON(c, set, "application/jwk-set+json", publicCache(maxAge))
+	}
+}
+
+func handleOpenIDConfiguration(jwt *JWTService, issuer string, maxAge int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Without a configured issuer the document is derived from the Host
+		// header, so shared caches must not keep it for other clients.
+		base, cacheControl := issuer, publicCache(maxAge)
+		if base == "" {
+			base = c.BaseURL() + strings.TrimSuffix(c.Path(), discoveryPath)
+			cacheControl = "no-store"
+		}
+		base = strings.TrimSuffix(base, "/")
+
+		algorithms := []string{}
+		seen := map[string]bool{}
+		for _, key := range jwt.Keyring().Keys() {
+			if key.isHMAC() || seen[key.Algorithm] {
+				continue
+			}
+			seen[key.Algorithm] = true
+			algorithms = append(algorithms, key.Algorithm)
+		}
+
+		return sendCacheableJSON(c, OpenIDConfiguration{
+			Issuer:                           base,
+			JWKSURI:                          base + jwksPath,
+			ResponseTypesSupported:           []string{"token"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: algorithms,
+		}, fiber.MIMEApplicationJSON, cacheControl)
+	}
+}
+
+func publicCache(maxAge int) string {
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
+
+// sendCacheableJSON serves a document with an ETag so verifiers can poll for
+// key changes cheaply.
+func sendCacheableJSON(c *fiber.Ctx, data interface{}, contentType, cacheControl string) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return response.SendError(c, fiber.StatusInternalServerError, "failed to encode response")
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	response.SetCommonHeaders(c)
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+	c.Set(fiber.HeaderETag, etag)
+
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Status(fiber.StatusOK).Send(body)
+}
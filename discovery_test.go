@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTestDiscoveryApp(t *testing.T, issuer string) *fiber.App {
+	t.Helper()
+
+	keys, err := NewKeyring(newTestEd25519Key(t, "current"), newTestEd25519Key(t, "retired"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Issuer = issuer
+
+	app := fiber.New()
+	RegisterDiscoveryRoutes(app, NewJWTServiceWithKeyring(keys, 900), config)
+	return app
+}
+
+func getDiscoveryDocument(t *testing.T, app *fiber.App, path, host, etag string, document interface{}) (int, string, string) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Host = host
+	if etag != "" {
+		req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	}
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == fiber.StatusOK && document != nil {
+		if err := json.NewDecoder(resp.Body).Decode(document); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderCacheControl), resp.Header.Get(fiber.HeaderETag)
+}
+
+func TestJWKS(t *testing.T) {
+	app := newTestDiscoveryApp(t, "https://auth.example.com")
+
+	var set JWKS
+	status, cacheControl, etag := getDiscoveryDocument(t, app, jwksPath, "auth.example.com", "", &set)
+	if status != fiber.StatusOK || cacheControl != "public, max-age=300" || etag == "" {
+		t.Fatalf("unexpected response: %d %q %q", status, cacheControl, etag)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "current" || set.Keys[1].Kid != "retired" {
+		t.Fatalf("unexpected keys: %+v", set.Keys)
+	}
+	for _, key := range set.Keys {
+		if key.Kty != "OKP" || key.Crv != "Ed25519" || key.Alg != AlgEdDSA || key.Use != "sig" || key.X == "" {
+			t.Fatalf("unexpected key: %+v", key)
+		}
+	}
+
+	if status, _, _ := getDiscoveryDocument(t, app, jwksPath, "auth.example.com", etag, nil); status != fiber.StatusNotModified {
+		t.Fatalf("expected 304, got %d", status)
+	}
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	t.Run("configured issuer", func(t *testing.T) {
+		app := newTestDiscoveryApp(t, "https://auth.example.com/")
+
+		var document OpenIDConfiguration
+		status, cacheControl, _ := getDiscoveryDocument(t, app, discoveryPath, "evil.example.com", "", &document)
+		if status != fiber.StatusOK || cacheControl != "public, max-age=300" {
+			t.Fatalf("unexpected response: %d %q", status, cacheControl)
+		}
+		if document.Issuer != "https://auth.example.com" || document.JWKSURI != "https://auth.example.com"+jwksPath {
+			t.Fatalf("unexpected document: %+v", document)
+		}
+		if len(document.IDTokenSigningAlgValuesSupported) != 1 || document.IDTokenSigningAlgValuesSupported[0] != AlgEdDSA {
+			t.Fatalf("unexpected algorithms: %v", document.IDTokenSigningAlgValuesSupported)
+		}
+	})
+
+	t.Run("derived issuer", func(t *testing.T) {
+		app := newTestDiscoveryApp(t, "")
+
+		var document OpenIDConfiguration
+		status, cacheControl, _ := getDiscoveryDocument(t, app, discoveryPath, "evil.example.com", "", &document)
+		if status != fiber.StatusOK || document.Issuer != "http://evil.example.com" {
+			t.Fatalf("unexpected response: %d %+v", status, document)
+		}
+		if cacheControl != "no-store" {
+			t.Fatalf("expected a document derived from the Host header not to be cached, got %q", cacheControl)
+		}
+	})
+}
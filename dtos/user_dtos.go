@@ -18,6 +18,9 @@ type UserUpdateDTO struct {
 	Password  *string `json:"password,omitempty"`
 	Firstname *string `json:"firstname,omitempty"`
 	Lastname  *string `json:"lastname,omitempty"`
+
+	Role               *string `json:"role,omitempty"`
+	MustChangePassword *bool   `json:"must_change_password,omitempty"`
 }
 
 type UserResponseDTO struct {
@@ -0,0 +1,470 @@
+package auth
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+// ChangeEmailRequest carries the current password, which users without a
+// password leave empty.
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" validate:"required,email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// emailChangeStore tracks email changes. A change is applied once confirmed
+// from the new address, and the previous address can revert it until its
+// revert link expires. Only SHA-256 digests of the tokens are stored.
+type emailChangeStore struct {
+	db        database.Database
+	ttl       time.Duration
+	revertTTL time.Duration
+}
+
+func newEmailChangeStore(db database.Database, ttl, revertTTL int) *emailChangeStore {
+	return &emailChangeStore{
+		db:        db,
+		ttl:       time.Duration(ttl) * time.Second,
+		revertTTL: time.Duration(revertTTL) * time.Second,
+	}
+}
+
+// create records a change of the user's email address and returns its
+// confirmation and revert tokens. A change still waiting for confirmation is
+// replaced.
+func (s *emailChangeStore) create(ctx stdcontext.Context, exec queryExecutor, user *models.User, newEmail string) (string, string, error) {
+	confirmToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	revertToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("email_changes").
+		Where(query.Eq("user_id", user.ID)).
+		Where(query.IsNull("confirmed_at")).
+		Where(query.IsNull("reverted_at")).
+		Build()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return "", "", fmt.Errorf("failed to cancel pending email change: %w", err)
+	}
+
+	now := time.Now()
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Insert("email_changes").
+		Columns("id", "user_id", "old_email", "new_email", "confirm_token_hash", "revert_token_hash", "expires_at", "revert_expires_at", "created_at").
+		Values(uuid.New(), user.ID, user.Email, newEmail, hashOpaqueToken(confirmToken), hashOpaqueToken(revertToken), now.Add(s.ttl), now.Add(s.revertTTL), now).
+		Build()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return "", "", fmt.Errorf("failed to store email change: %w", err)
+	}
+
+	return confirmToken, revertToken, nil
+}
+
+// findConfirmable returns the change a confirmation token belongs to.
+func (s *emailChangeStore) findConfirmable(ctx stdcontext.Context, token string) (*models.EmailChange, error) {
+	change, err := s.find(ctx, "confirm_token_hash", token)
+	if err != nil {
+		return nil, err
+	}
+
+	if change.ConfirmedAt != nil || change.RevertedAt != nil {
+		return nil, ErrActionTokenInvalid
+	}
+	if time.Now().After(change.ExpiresAt) {
+		return nil, ErrActionTokenExpired
+	}
+
+	return change, nil
+}
+
+// findRevertible returns the change a revert token belongs to.
+func (s *emailChangeStore) findRevertible(ctx stdcontext.Context, token string) (*models.EmailChange, error) {
+	change, err := s.find(ctx, "revert_token_hash", token)
+	if err != nil {
+		return nil, err
+	}
+
+	if change.RevertedAt != nil {
+		return nil, ErrActionTokenInvalid
+	}
+	if time.Now().After(change.RevertExpiresAt) {
+		return nil, ErrActionTokenExpired
+	}
+
+	return change, nil
+}
+
+func (s *emailChangeStore) markConfirmed(ctx stdcontext.Context, exec queryExecutor, change *models.EmailChange) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("email_changes").
+		Set("confirmed_at", time.Now()).
+		Where(query.Eq("id", change.ID)).
+		Where(query.IsNull("confirmed_at")).
+		Where(query.IsNull("reverted_at")).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	return s.claim(ctx, exec, queryStr, args)
+}
+
+// markReverted closes the change along with every other change of the user,
+// so that none of them can be confirmed or reverted afterwards.
+func (s *emailChangeStore) markReverted(ctx stdcontext.Context, exec queryExecutor, change *models.EmailChange) error {
+	now := time.Now()
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("email_changes").
+		Set("reverted_at", now).
+		Where(query.Eq("id", change.ID)).
+		Where(query.IsNull("reverted_at")).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if err := s.claim(ctx, exec, queryStr, args); err != nil {
+		return err
+	}
+
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Update("email_changes").
+		Set("reverted_at", now).
+		Where(query.Eq("user_id", change.UserID)).
+		Where(query.IsNull("reverted_at")).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to close email changes: %w", err)
+	}
+
+	return nil
+}
+
+func (s *emailChangeStore) claim(ctx stdcontext.Context, exec queryExecutor, queryStr string, args []interface{}) error {
+	result, err := exec.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update email change: %w", err)
+	}
+
+	// Another request used the token since it was read.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrActionTokenInvalid
+	}
+
+	return nil
+}
+
+func (s *emailChangeStore) find(ctx stdcontext.Context, column, token string) (*models.EmailChange, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id", "user_id", "old_email", "new_email", "expires_at", "revert_expires_at", "confirmed_at", "reverted_at").
+		From("email_changes").
+		Where(query.Eq(column, hashOpaqueToken(token))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var change models.EmailChange
+	err = s.db.QueryRow(ctx, queryStr, args...).
+		Scan(&change.ID, &change.UserID, &change.OldEmail, &change.NewEmail, &change.ExpiresAt, &change.RevertExpiresAt, &change.ConfirmedAt, &change.RevertedAt)
+	if crud.IsNotFoundError(err) {
+		return nil, ErrActionTokenInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return &change, nil
+}
+
+func handleChangeEmail(db database.Database, changes *emailChangeStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ChangeEmailRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.NewEmail == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "new_email is required")
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change email")
+		}
+
+		if fiberErr := confirmIdentity(c, config.PasswordHasher, user, req.CurrentPassword); fiberErr != nil {
+			return response.SendError(c, fiberErr.Code, fiberErr.Message)
+		}
+
+		if strings.EqualFold(req.NewEmail, user.Email) {
+			return response.SendError(c, fiber.StatusBadRequest, "new email must be different from the current email")
+		}
+
+		err = checkEmailExists(ctx, db, req.NewEmail, userID)
+		if errors.Is(err, ErrEmailInUse) {
+			return response.SendError(c, fiber.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change email")
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change email")
+		}
+		defer tx.Rollback(ctx)
+
+		confirmToken, revertToken, err := changes.create(ctx, tx, user, req.NewEmail)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change email")
+		}
+
+		if err := setPendingEmail(ctx, db, tx, userID, req.NewEmail); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change email")
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change email")
+		}
+
+		go func(user models.User, newEmail, locale string) {
+			ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), mailTimeout)
+			defer cancel()
+
+			if err := sendEmailChange(ctx, changes, config, &user, newEmail, confirmToken, revertToken, locale); err != nil {
+				log.Printf("[gorest-auth] email change: %v", err)
+			}
+		}(*user, req.NewEmail, requestLocale(c))
+
+		return response.SendFormatted(c, fiber.StatusAccepted, fiber.Map{
+			"pending_email": req.NewEmail,
+		})
+	}
+}
+
+func handleConfirmEmailChange(db database.Database, resets *actionTokenStore, changes *emailChangeStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req EmailChangeTokenRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Token == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "token is required")
+		}
+
+		ctx := c.Context()
+
+		change, err := changes.findConfirmable(ctx, req.Token)
+		if errors.Is(err, ErrActionTokenInvalid) || errors.Is(err, ErrActionTokenExpired) {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid or expired confirmation token")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to confirm email change")
+		}
+
+		if err := applyEmailChange(ctx, db, change.UserID, change.NewEmail, func(tx database.Tx) error {
+			if err := changes.markConfirmed(ctx, tx, change); err != nil {
+				return err
+			}
+			// Reset links were sent to the previous address.
+			return resets.invalidateUser(ctx, tx, change.UserID)
+		}); err != nil {
+			return sendEmailChangeError(c, err, "failed to confirm email change")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleRevertEmailChange restores the previous address and signs the user
+// out everywhere, as an unwanted change means someone else had access to the
+// account.
+func handleRevertEmailChange(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, resets *actionTokenStore, changes *emailChangeStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req EmailChangeTokenRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Token == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "token is required")
+		}
+
+		ctx := c.Context()
+
+		change, err := changes.findRevertible(ctx, req.Token)
+		if errors.Is(err, ErrActionTokenInvalid) || errors.Is(err, ErrActionTokenExpired) {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid or expired revert token")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to revert email change")
+		}
+
+		if err := applyEmailChange(ctx, db, change.UserID, change.OldEmail, func(tx database.Tx) error {
+			if err := changes.markReverted(ctx, tx, change); err != nil {
+				return err
+			}
+			// Reset links sent to the unwanted address must not outlive it.
+			return resets.invalidateUser(ctx, tx, change.UserID)
+		}); err != nil {
+			return sendEmailChangeError(c, err, "failed to revert email change")
+		}
+
+		if err := revokeSessions(ctx, tokenService, refreshTokens, change.UserID.String()); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke sessions")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// applyEmailChange sets the email address of the user, marked as verified
+// since the user just proved they own it, in the transaction closing the
+// change.
+func applyEmailChange(ctx stdcontext.Context, db database.Database, userID uuid.UUID, email string, finish func(tx database.Tx) error) error {
+	if err := checkEmailExists(ctx, db, email, userID); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := finish(tx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	queryStr, args, err := query.New(db.Dialect()).
+		Update("users").
+		Set("email", email).
+		Set("pending_email", nil).
+		Set("email_verified_at", now).
+		Set("updated_at", now).
+		Where(query.Eq("id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	// Another user may have taken the address since it was checked.
+	if _, err := tx.Exec(ctx, queryStr, args...); err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailInUse
+		}
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func sendEmailChangeError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, ErrEmailInUse):
+		return response.SendError(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, ErrActionTokenInvalid):
+		return response.SendError(c, fiber.StatusBadRequest, "invalid or expired token")
+	default:
+		return response.SendError(c, fiber.StatusInternalServerError, message)
+	}
+}
+
+func setPendingEmail(ctx stdcontext.Context, db database.Database, exec queryExecutor, userID uuid.UUID, email string) error {
+	queryStr, args, err := query.New(db.Dialect()).
+		Update("users").
+		Set("pending_email", email).
+		Where(query.Eq("id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to store pending email: %w", err)
+	}
+
+	return nil
+}
+
+// sendEmailChange asks the new address to confirm the change and tells the
+// current one how to revert it.
+func sendEmailChange(ctx stdcontext.Context, changes *emailChangeStore, config Config, user *models.User, newEmail, confirmToken, revertToken, locale string) error {
+	confirmLink, err := actionURL(config.EmailChangeURL, confirmToken)
+	if err != nil {
+		return err
+	}
+
+	revertLink, err := actionURL(config.EmailChangeRevertURL, revertToken)
+	if err != nil {
+		return err
+	}
+
+	if err := sendMailTo(ctx, config, mailer.TemplateEmailChangeConfirm, locale, user, newEmail, map[string]any{
+		"Link":           confirmLink,
+		"NewEmail":       newEmail,
+		"ExpiresInHours": int(changes.ttl.Hours()),
+	}); err != nil {
+		return err
+	}
+
+	return sendMail(ctx, config, mailer.TemplateEmailChangeNotice, locale, user, map[string]any{
+		"Link":          revertLink,
+		"NewEmail":      newEmail,
+		"ExpiresInDays": int(changes.revertTTL.Hours() / 24),
+	})
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/database"
+)
+
+func newTestEmailChangeApp(t *testing.T) (*fiber.App, database.Database, *mailer.MemoryMailer) {
+	t.Helper()
+
+	mail := mailer.NewMemoryMailer()
+	app, _, db := newTestApp(t, map[string]interface{}{
+		"mailer":                  mail,
+		"email_change_url":        "https://app.example.com/email/confirm",
+		"email_change_revert_url": "https://app.example.com/email/revert",
+	})
+	return app, db, mail
+}
+
+func userEmails(t *testing.T, db database.Database, userID uuid.UUID) (email string, pending *string) {
+	t.Helper()
+
+	if err := db.QueryRow(context.Background(), "SELECT email, pending_email FROM users WHERE id = ?", userID.String()).Scan(&email, &pending); err != nil {
+		t.Fatal(err)
+	}
+	return email, pending
+}
+
+// changeEmail requests an email change and returns its confirmation and
+// revert tokens, taken from the messages sent after the first n ones.
+func changeEmail(t *testing.T, app *fiber.App, mail *mailer.MemoryMailer, n int, token, newEmail string) (confirmToken, revertToken string) {
+	t.Helper()
+
+	status, result := request(t, app, "POST", "/auth/email/change", token, map[string]string{"new_email": newEmail, "current_password": testPassword})
+	if status != fiber.StatusAccepted || result["pending_email"] != newEmail {
+		t.Fatalf("expected 202, got %d %v", status, result)
+	}
+
+	confirm := waitForMail(t, mail, n)
+	notice := waitForMail(t, mail, n+1)
+	if confirm.To[0] != newEmail || notice.To[0] == newEmail {
+		t.Fatalf("unexpected recipients: %v %v", confirm.To, notice.To)
+	}
+	return mailToken(t, confirm), mailToken(t, notice)
+}
+
+func TestChangeEmail(t *testing.T) {
+	app, db, mail := newTestEmailChangeApp(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "john@example.com", "user")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+
+	tests := []struct {
+		name     string
+		token    string
+		body     map[string]string
+		expected int
+	}{
+		{"no token", "", map[string]string{"new_email": "janet@example.com", "current_password": testPassword}, fiber.StatusUnauthorized},
+		{"missing password", token, map[string]string{"new_email": "janet@example.com"}, fiber.StatusBadRequest},
+		{"wrong password", token, map[string]string{"new_email": "janet@example.com", "current_password": "wrong-password"}, fiber.StatusForbidden},
+		{"same email", token, map[string]string{"new_email": "JANE@example.com", "current_password": testPassword}, fiber.StatusBadRequest},
+		{"taken email", token, map[string]string{"new_email": "john@example.com", "current_password": testPassword}, fiber.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		if status, result := request(t, app, "POST", "/auth/email/change", tt.token, tt.body); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	confirmToken, _ := changeEmail(t, app, mail, 0, token, "janet@example.com")
+
+	// The address only changes once confirmed.
+	if email, pending := userEmails(t, db, userID); email != "jane@example.com" || pending == nil || *pending != "janet@example.com" {
+		t.Fatalf("unexpected emails before confirmation: %s %v", email, pending)
+	}
+	login(t, app, "jane@example.com", testPassword)
+
+	resetToken, err := newPasswordResetStore(db, 3600).issue(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, result := request(t, app, "POST", "/auth/email/change/confirm", "", map[string]string{"token": confirmToken}); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d %v", status, result)
+	}
+	if email, pending := userEmails(t, db, userID); email != "janet@example.com" || pending != nil {
+		t.Fatalf("unexpected emails after confirmation: %s %v", email, pending)
+	}
+	login(t, app, "janet@example.com", testPassword)
+
+	if status, _ := request(t, app, "POST", "/auth/email/change/confirm", "", map[string]string{"token": confirmToken}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a used token to be rejected, got %d", status)
+	}
+
+	// Reset links sent to the previous address are invalidated.
+	if status, _ := request(t, app, "POST", "/auth/password/reset", "", map[string]string{"token": resetToken, "password": "a-new-password"}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected the reset link to be invalidated, got %d", status)
+	}
+}
+
+func TestChangeEmailWithoutPassword(t *testing.T) {
+	mail := mailer.NewMemoryMailer()
+	app, plugin, db := newTestApp(t, map[string]interface{}{
+		"mailer":           mail,
+		"email_change_url": "https://app.example.com/email/confirm",
+	})
+	user := createTestUserWithoutPassword(t, db, "jane@example.com")
+	ctx := context.Background()
+
+	stale := tokens.NewAuthentication(tokens.AMREmail)
+	stale.Time = time.Now().Add(-mfaEnrollmentMaxAge - time.Minute)
+
+	tests := []struct {
+		name     string
+		auth     tokens.Authentication
+		expected int
+	}{
+		{"stale login", stale, fiber.StatusUnauthorized},
+		{"recent login", tokens.NewAuthentication(tokens.AMREmail), fiber.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		token, err := plugin.TokenService().GenerateToken(ctx, user, WithAuthentication(tt.auth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, result := request(t, app, "POST", "/auth/email/change", token, map[string]string{"new_email": "janet@example.com"})
+		if status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	if message := waitForMail(t, mail, 0); message.To[0] != "janet@example.com" {
+		t.Fatalf("expected the confirmation to be sent to the new address, got %v", message.To)
+	}
+}
+
+func TestApplyEmailChangeConflict(t *testing.T) {
+	db := newTestDatabase(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	ctx := context.Background()
+
+	// Another user takes the address between the check and the update.
+	err := applyEmailChange(ctx, db, userID, "janet@example.com", func(tx database.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO users (id, firstname, lastname, email) VALUES (?, 'Janet', 'Doe', 'janet@example.com')", uuid.New().String())
+		return err
+	})
+	if !errors.Is(err, ErrEmailInUse) {
+		t.Fatalf("expected ErrEmailInUse, got %v", err)
+	}
+}
+
+func TestRevertEmailChange(t *testing.T) {
+	app, db, mail := newTestEmailChangeApp(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	token, refreshToken := login(t, app, "jane@example.com", testPassword)
+
+	confirmToken, revertToken := changeEmail(t, app, mail, 0, token, "janet@example.com")
+	if status, _ := request(t, app, "POST", "/auth/email/change/confirm", "", map[string]string{"token": confirmToken}); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+
+	if status, result := request(t, app, "POST", "/auth/email/change/revert", "", map[string]string{"token": revertToken}); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d %v", status, result)
+	}
+	if email, pending := userEmails(t, db, userID); email != "jane@example.com" || pending != nil {
+		t.Fatalf("unexpected emails after revert: %s %v", email, pending)
+	}
+
+	// Every session is revoked.
+	if status, _ := request(t, app, "POST", "/auth/logout", token, nil); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the access token to be revoked, got %d", status)
+	}
+	if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the refresh token to be revoked, got %d", status)
+	}
+
+	if status, _ := request(t, app, "POST", "/auth/email/change/revert", "", map[string]string{"token": revertToken}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a used revert token to be rejected, got %d", status)
+	}
+}
+
+func TestEmailChangeTokens(t *testing.T) {
+	app, db, mail := newTestEmailChangeApp(t)
+	createTestUser(t, db, "jane@example.com", "user")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+
+	// A new request replaces the pending change.
+	first, _ := changeEmail(t, app, mail, 0, token, "janet@example.com")
+	second, revertToken := changeEmail(t, app, mail, 2, token, "jane.doe@example.com")
+	if status, _ := request(t, app, "POST", "/auth/email/change/confirm", "", map[string]string{"token": first}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a replaced change to be rejected, got %d", status)
+	}
+
+	// The address was taken in the meantime.
+	createTestUser(t, db, "jane.doe@example.com", "user")
+	if status, _ := request(t, app, "POST", "/auth/email/change/confirm", "", map[string]string{"token": second}); status != fiber.StatusConflict {
+		t.Fatalf("expected 409 for a taken address, got %d", status)
+	}
+
+	// Reverting a pending change closes it.
+	if status, _ := request(t, app, "POST", "/auth/email/change/revert", "", map[string]string{"token": revertToken}); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	if status, _ := request(t, app, "POST", "/auth/email/change/confirm", "", map[string]string{"token": second}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a reverted change to be rejected, got %d", status)
+	}
+}
+
+func TestUpdateUserEmail(t *testing.T) {
+	t.Run("confirmed changes", func(t *testing.T) {
+		app, db, _ := newTestEmailChangeApp(t)
+		userID := createTestUser(t, db, "jane@example.com", "user")
+		token, _ := login(t, app, "jane@example.com", testPassword)
+		path := "/users/" + userID.String()
+
+		if status, _ := request(t, app, "PUT", path, token, map[string]string{"email": "janet@example.com"}); status != fiber.StatusBadRequest {
+			t.Fatalf("expected a direct email change to be rejected, got %d", status)
+		}
+		if status, _ := request(t, app, "PUT", path, token, map[string]string{"email": "jane@example.com", "firstname": "Janet"}); status != fiber.StatusOK {
+			t.Fatalf("expected the current email to be accepted, got %d", status)
+		}
+	})
+
+	t.Run("direct changes", func(t *testing.T) {
+		app, _, db := newTestApp(t, nil)
+		userID := createTestUser(t, db, "jane@example.com", "user")
+		createTestUser(t, db, "john@example.com", "user")
+		token, _ := login(t, app, "jane@example.com", testPassword)
+		path := "/users/" + userID.String()
+
+		if status, _ := request(t, app, "PUT", path, token, map[string]string{"email": "john@example.com"}); status != fiber.StatusConflict {
+			t.Fatalf("expected a taken email to be rejected, got %d", status)
+		}
+		if status, _ := request(t, app, "PUT", path, token, map[string]string{"email": "janet@example.com"}); status != fiber.StatusOK {
+			t.Fatalf("expected a free email to be accepted, got %d", status)
+		}
+		if email, _ := userEmails(t, db, userID); email != "janet@example.com" {
+			t.Fatalf("unexpected email %s", email)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+const (
+	// EmailVerificationOptional sends the verification email without
+	// limiting unverified accounts.
+	EmailVerificationOptional = "optional"
+	// EmailVerificationRestrict issues restricted tokens until the email
+	// address is verified.
+	EmailVerificationRestrict = "restrict"
+	// EmailVerificationBlock refuses to log unverified accounts in.
+	EmailVerificationBlock = "block"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func newEmailVerificationStore(db database.Database, ttl int) *actionTokenStore {
+	return &actionTokenStore{
+		db:    db,
+		table: "email_verification_tokens",
+		ttl:   time.Duration(ttl) * time.Second,
+	}
+}
+
+// loginBlocked reports whether the user must verify their email address
+// before getting any token.
+func loginBlocked(user *models.User, config Config) bool {
+	return config.EmailVerification == EmailVerificationBlock && !user.IsEmailVerified()
+}
+
+func handleVerifyEmail(db database.Database, verifications *actionTokenStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req VerifyEmailRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Token == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "token is required")
+		}
+
+		ctx := c.Context()
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to verify email")
+		}
+		defer tx.Rollback(ctx)
+
+		userID, err := verifications.consume(ctx, tx, req.Token)
+		if errors.Is(err, ErrActionTokenInvalid) || errors.Is(err, ErrActionTokenExpired) {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid or expired verification token")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to verify email")
+		}
+
+		if err := markEmailVerified(ctx, db, tx, userID); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to verify email")
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to verify email")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleResendVerification answers the same way for unknown, verified and
+// unverified addresses.
+func handleResendVerification(db database.Database, verifications *actionTokenStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ResendVerificationRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Email == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "email is required")
+		}
+
+		go func(email, locale string) {
+			ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), mailTimeout)
+			defer cancel()
+
+			user, err := getUser(ctx, db, query.Eq("email", email))
+			if err != nil || user.IsEmailVerified() {
+				return
+			}
+
+			// The previous email is still on its way.
+			recent, err := verifications.issuedWithin(ctx, user.ID, time.Duration(config.EmailVerificationCooldown)*time.Second)
+			if err != nil {
+				log.Printf("[gorest-auth] email verification: %v", err)
+				return
+			}
+			if recent {
+				return
+			}
+
+			if err := sendEmailVerification(ctx, verifications, config, user, locale); err != nil {
+				log.Printf("[gorest-auth] email verification: %v", err)
+			}
+		}(req.Email, requestLocale(c))
+
+		return response.SendFormatted(c, fiber.StatusAccepted, fiber.Map{
+			"message": "if this email needs to be verified, a verification link has been sent",
+		})
+	}
+}
+
+// sendEmailVerificationAsync sends the verification email of a newly
+// registered user without delaying the response.
+func sendEmailVerificationAsync(verifications *actionTokenStore, config Config, user models.User, locale string) {
+	go func() {
+		ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), mailTimeout)
+		defer cancel()
+
+		if err := sendEmailVerification(ctx, verifications, config, &user, locale); err != nil {
+			log.Printf("[gorest-auth] email verification: %v", err)
+		}
+	}()
+}
+
+func sendEmailVerification(ctx stdcontext.Context, verifications *actionTokenStore, config Config, user *models.User, locale string) error {
+	token, err := verifications.issue(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	link, err := actionURL(config.EmailVerificationURL, token)
+	if err != nil {
+		return err
+	}
+
+	return sendMail(ctx, config, mailer.TemplateEmailVerification, locale, user, map[string]any{
+		"Link":           link,
+		"ExpiresInHours": int(verifications.ttl.Hours()),
+	})
+}
+
+func markEmailVerified(ctx stdcontext.Context, db database.Database, exec queryExecutor, userID uuid.UUID) error {
+	queryStr, args, err := query.New(db.Dialect()).
+		Update("users").
+		Set("email_verified_at", time.Now()).
+		Where(query.Eq("id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to mark email as verified: %w", err)
+	}
+
+	return nil
+}
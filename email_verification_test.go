@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func newTestVerificationApp(t *testing.T, mode string) (*fiber.App, *AuthPlugin, *mailer.MemoryMailer) {
+	t.Helper()
+
+	mail := mailer.NewMemoryMailer()
+	app, plugin, _ := newTestApp(t, map[string]interface{}{
+		"mailer":                      mail,
+		"email_verification":          mode,
+		"email_verification_url":      "https://app.example.com/verify-email",
+		"email_verification_cooldown": 0,
+	})
+	return app, plugin, mail
+}
+
+func register(t *testing.T, app *fiber.App, email string) map[string]interface{} {
+	t.Helper()
+
+	status, result := request(t, app, "POST", "/auth/register", "", map[string]string{
+		"email":     email,
+		"password":  testPassword,
+		"firstname": "Jane",
+		"lastname":  "Doe",
+	})
+	if status != fiber.StatusCreated {
+		t.Fatalf("registration failed: %d %v", status, result)
+	}
+	return result
+}
+
+func TestEmailVerificationModes(t *testing.T) {
+	tests := []struct {
+		mode string
+		// restriction of the tokens issued before the email is verified.
+		restriction string
+		blocked     bool
+	}{
+		{EmailVerificationOptional, "", false},
+		{EmailVerificationRestrict, tokens.RestrictionEmailUnverified, false},
+		{EmailVerificationBlock, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			app, plugin, mail := newTestVerificationApp(t, tt.mode)
+
+			result := register(t, app, "jane@example.com")
+			message := waitForMail(t, mail, 0)
+			if message.To[0] != "jane@example.com" || message.Subject != "Confirm your email address" {
+				t.Fatalf("unexpected message: %+v", message)
+			}
+
+			token, _ := result["token"].(string)
+			if tt.blocked {
+				if token != "" {
+					t.Fatal("expected no token before the email is verified")
+				}
+				status, _ := request(t, app, "POST", "/auth/login", "", map[string]string{"email": "jane@example.com", "password": testPassword})
+				if status != fiber.StatusForbidden {
+					t.Fatalf("expected the login to be blocked, got %d", status)
+				}
+			} else {
+				claims, err := plugin.TokenService().ValidateToken(token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.Restriction != tt.restriction {
+					t.Fatalf("expected restriction %q, got %q", tt.restriction, claims.Restriction)
+				}
+			}
+
+			if status, result := request(t, app, "POST", "/auth/verify-email", "", map[string]string{"token": mailToken(t, message)}); status != fiber.StatusNoContent {
+				t.Fatalf("expected 204, got %d %v", status, result)
+			}
+
+			token, _ = login(t, app, "jane@example.com", testPassword)
+			claims, err := plugin.TokenService().ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Restriction != "" {
+				t.Fatalf("expected an unrestricted token once verified, got %q", claims.Restriction)
+			}
+		})
+	}
+}
+
+func TestEmailUnverifiedRestriction(t *testing.T) {
+	app, _, mail := newTestVerificationApp(t, EmailVerificationRestrict)
+	result := register(t, app, "jane@example.com")
+	waitForMail(t, mail, 0)
+	token, _ := result["token"].(string)
+	user, _ := result["user"].(map[string]interface{})
+
+	if status, _ := request(t, app, "PUT", "/users/"+user["id"].(string), token, map[string]string{"firstname": "Janet"}); status != fiber.StatusForbidden {
+		t.Fatalf("expected a restricted token to be rejected, got %d", status)
+	}
+	if status, _ := request(t, app, "POST", "/auth/logout", token, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected a restricted token to end its session, got %d", status)
+	}
+}
+
+func TestVerifyEmailTokens(t *testing.T) {
+	app, _, mail := newTestVerificationApp(t, EmailVerificationBlock)
+	register(t, app, "jane@example.com")
+	first := mailToken(t, waitForMail(t, mail, 0))
+
+	_, known := request(t, app, "POST", "/auth/verify-email/resend", "", map[string]string{"email": "jane@example.com"})
+	status, unknown := request(t, app, "POST", "/auth/verify-email/resend", "", map[string]string{"email": "john@example.com"})
+	if status != fiber.StatusAccepted || unknown["message"] != known["message"] {
+		t.Fatalf("expected the same response for an unknown email, got %d %v", status, unknown)
+	}
+	second := mailToken(t, waitForMail(t, mail, 1))
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"missing token", "", fiber.StatusBadRequest},
+		{"unknown token", "unknown", fiber.StatusBadRequest},
+		{"superseded token", first, fiber.StatusBadRequest},
+		{"latest token", second, fiber.StatusNoContent},
+		{"used token", second, fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if status, result := request(t, app, "POST", "/auth/verify-email", "", map[string]string{"token": tt.token}); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	login(t, app, "jane@example.com", testPassword)
+}
+
+func TestResendVerificationCooldown(t *testing.T) {
+	mail := mailer.NewMemoryMailer()
+	app, _, _ := newTestApp(t, map[string]interface{}{
+		"mailer":                 mail,
+		"email_verification":     EmailVerificationBlock,
+		"email_verification_url": "https://app.example.com/verify-email",
+	})
+	register(t, app, "jane@example.com")
+	first := mailToken(t, waitForMail(t, mail, 0))
+
+	// The response does not tell whether an email was sent.
+	if status, _ := request(t, app, "POST", "/auth/verify-email/resend", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d", status)
+	}
+	register(t, app, "john@example.com")
+	waitForMail(t, mail, 1)
+
+	if messages := mail.Messages(); len(messages) != 2 || messages[1].To[0] != "john@example.com" {
+		t.Fatalf("expected the resent email to be skipped, got %d messages", len(messages))
+	}
+	if status, result := request(t, app, "POST", "/auth/verify-email", "", map[string]string{"token": first}); status != fiber.StatusNoContent {
+		t.Fatalf("expected the first link to stay valid, got %d %v", status, result)
+	}
+}
+
+func TestResendVerificationIPLimit(t *testing.T) {
+	app, _, _ := newTestApp(t, map[string]interface{}{
+		"mailer":                      "memory",
+		"email_verification":          EmailVerificationOptional,
+		"email_verification_url":      "https://app.example.com/verify-email",
+		"email_verification_ip_limit": 2,
+	})
+
+	for i, expected := range []int{fiber.StatusAccepted, fiber.StatusAccepted, fiber.StatusTooManyRequests} {
+		email := fmt.Sprintf("user-%d@example.com", i)
+		if status, result := request(t, app, "POST", "/auth/verify-email/resend", "", map[string]string{"email": email}); status != expected {
+			t.Fatalf("request %d: expected %d, got %d %v", i+1, expected, status, result)
+		}
+	}
+}
+
+func TestInitializeEmailVerification(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"no mailer", map[string]interface{}{"email_verification": EmailVerificationBlock}},
+		{"unknown mode", map[string]interface{}{"email_verification": "strict", "mailer": "memory"}},
+	}
+
+	for _, tt := range tests {
+		tt.config["jwt_secret"] = testSecret
+		if err := NewPlugin().Initialize(tt.config); err == nil {
+			t.Fatalf("%s: expected Initialize to fail", tt.name)
+		}
+	}
+
+	// Without email verification, accounts are usable at once.
+	app, _, _ := newTestApp(t, map[string]interface{}{"mailer": "memory"})
+	if token, _ := register(t, app, "jane@example.com")["token"].(string); token == "" {
+		t.Fatal("expected a token at registration")
+	}
+	if status, _ := request(t, app, "POST", "/auth/verify-email", "", map[string]string{"token": "unknown"}); status != fiber.StatusNotFound {
+		t.Fatalf("expected the verification routes not to be registered, got %d", status)
+	}
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	authmigrations "github.com/nicolasbonnici/gorest-auth/migrations"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest/database"
+	_ "github.com/nicolasbonnici/gorest/database/sqlite"
+	"github.com/nicolasbonnici/gorest/migrations"
+)
+
+const testPassword = "correct-horse-battery"
+
+// testLinkURLs are the frontend pages required by the email flows.
+var testLinkURLs = map[string]string{
+	"password_reset_url":      "https://app.example.com/reset-password",
+	"email_verification_url":  "https://app.example.com/verify-email",
+	"email_change_url":        "https://app.example.com/email/confirm",
+	"email_change_revert_url": "https://app.example.com/email/revert",
+}
+
+// withLinkURLs completes the config with the testLinkURLs it does not set.
+func withLinkURLs(config map[string]interface{}) map[string]interface{} {
+	for name, value := range testLinkURLs {
+		if _, ok := config[name]; !ok {
+			config[name] = value
+		}
+	}
+	return config
+}
+
+// newTestDatabase opens an in-memory SQLite database with every migration
+// applied.
+func newTestDatabase(t *testing.T) database.Database {
+	t.Helper()
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := database.Open("sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(1)", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	migrator := migrations.NewMigrator(db, authmigrations.GetMigrations())
+	if err := migrator.UpTo(ctx, "20250121000001000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The SQLite users table stores its timestamps as TEXT, which the driver
+	// does not scan into time.Time.
+	for _, statement := range []string{
+		"DROP TABLE users",
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			firstname TEXT NOT NULL,
+			lastname TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL,
+			password TEXT,
+			updated_at DATETIME,
+			created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+		)`,
+	} {
+		if _, err := db.Exec(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newTestApp serves the routes of a plugin initialized with the config on
+// a new test database.
+func newTestApp(t *testing.T, config map[string]interface{}) (*fiber.App, *AuthPlugin, database.Database) {
+	t.Helper()
+
+	db := newTestDatabase(t)
+
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	config["database"] = db
+	if _, ok := config["mailer"]; ok {
+		withLinkURLs(config)
+	}
+	if _, ok := config["jwt_secret"]; !ok {
+		config["jwt_secret"] = testSecret
+	}
+
+	plugin := NewPlugin().(*AuthPlugin)
+	if err := plugin.Initialize(config); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	if err := plugin.SetupEndpoints(app); err != nil {
+		t.Fatal(err)
+	}
+
+	return app, plugin, db
+}
+
+// createTestUser inserts a user with testPassword and returns its ID.
+func createTestUser(t *testing.T, db database.Database, email, role string) uuid.UUID {
+	t.Helper()
+
+	plain := testPassword
+	user := models.User{Password: &plain}
+	if err := user.HashPassword(password.NewArgon2idHasher(password.DefaultArgon2idParams)); err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.New()
+	if _, err := db.Exec(context.Background(),
+		"INSERT INTO users (id, firstname, lastname, email, password, role, created_at) VALUES (?, 'Jane', 'Doe', ?, ?, ?, ?)",
+		id.String(), email, *user.Password, role, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// createTestUserWithoutPassword creates a user without a password, as logging in
+// with a magic link does.
+func createTestUserWithoutPassword(t *testing.T, db database.Database, email string) *models.User {
+	t.Helper()
+
+	ctx := context.Background()
+	userID := createTestUser(t, db, email, "user")
+	if _, err := db.Exec(ctx, "UPDATE users SET password = NULL WHERE id = ?", userID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := getUserByID(ctx, db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// request sends a JSON request and decodes the JSON object it returns.
+func request(t *testing.T, app *fiber.App, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	result := map[string]interface{}{}
+	_ = json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+// login returns the token pair of a successful login.
+func login(t *testing.T, app *fiber.App, email, password string) (token, refreshToken string) {
+	t.Helper()
+
+	status, result := request(t, app, "POST", "/auth/login", "", map[string]string{"email": email, "password": password})
+	if status != fiber.StatusOK {
+		t.Fatalf("login failed: %d %v", status, result)
+	}
+
+	token, _ = result["token"].(string)
+	refreshToken, _ = result["refresh_token"].(string)
+	return token, refreshToken
+}
+
+// waitForMail returns the message sent after the first n ones, as some flows
+// send their emails in the background.
+func waitForMail(t *testing.T, m *mailer.MemoryMailer, n int) mailer.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if messages := m.Messages(); len(messages) > n {
+			return messages[n]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected message %d to be sent", n+1)
+	return mailer.Message{}
+}
+
+// mailToken returns the token of the first link of a message.
+func mailToken(t *testing.T, message mailer.Message) string {
+	t.Helper()
+
+	for _, field := range strings.Fields(message.Text) {
+		link, err := url.Parse(field)
+		if err != nil || link.Scheme == "" {
+			continue
+		}
+		if token := link.Query().Get("token"); token != "" {
+			return token
+		}
+	}
+
+	t.Fatalf("no token link in message %q", message.Text)
+	return ""
+}
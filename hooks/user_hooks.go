@@ -1,20 +1,29 @@
 package hooks
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/password/history"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/hooks"
+	"github.com/nicolasbonnici/gorest/query"
 	"github.com/nicolasbonnici/gorest/rbac"
 )
 
 type UserHooks struct {
 	*hooks.DefaultAuthorization[models.User]
 	hooks.NoOpHooks[models.User]
-	db database.Database
+	db              database.Database
+	passwordPolicy  *password.Policy
+	passwordHistory *history.Store
+	passwordHasher  password.Hasher
 }
 
 func NewUserHooks(db database.Database, config rbac.Config) *UserHooks {
@@ -22,9 +31,27 @@ func NewUserHooks(db database.Database, config rbac.Config) *UserHooks {
 		DefaultAuthorization: hooks.NewDefaultAuthorization[models.User](config),
 		NoOpHooks:            *hooks.NewNoOpHooks[models.User](),
 		db:                   db,
+		passwordPolicy:       password.DefaultPolicy(),
+		passwordHasher:       password.NewArgon2idHasher(password.DefaultArgon2idParams),
 	}
 }
 
+// SetPasswordHistory rejects updates reusing a recent password of the user.
+// Replaced passwords are kept by the caller, in the transaction of the update.
+func (h *UserHooks) SetPasswordHistory(store *history.Store) {
+	h.passwordHistory = store
+}
+
+// SetPasswordHasher replaces the hasher of new passwords.
+func (h *UserHooks) SetPasswordHasher(hasher password.Hasher) {
+	h.passwordHasher = hasher
+}
+
+// SetPasswordPolicy replaces the policy new passwords are checked against.
+func (h *UserHooks) SetPasswordPolicy(policy *password.Policy) {
+	h.passwordPolicy = policy
+}
+
 func (h *UserHooks) CheckUpdate(ctx context.Context, id any, model *models.User) error {
 	userID, hasUserID := rbac.GetUserID(ctx)
 	roles, _ := rbac.GetRoles(ctx)
@@ -43,6 +70,16 @@ func (h *UserHooks) CheckUpdate(ctx context.Context, id any, model *models.User)
 	return rbac.ErrPermissionDenied
 }
 
+// CheckDelete only lets admins delete users.
+func (h *UserHooks) CheckDelete(ctx context.Context, id any) error {
+	roles, _ := rbac.GetRoles(ctx)
+	if h.GetVoter().IsSuperuser(roles) {
+		return nil
+	}
+
+	return rbac.ErrPermissionDenied
+}
+
 func (h *UserHooks) StateProcessor(ctx context.Context, op hooks.Operation, id any, model *models.User) error {
 	switch op {
 	case hooks.OperationCreate, hooks.OperationUpdate:
@@ -51,10 +88,10 @@ func (h *UserHooks) StateProcessor(ctx context.Context, op hooks.Operation, id a
 		}
 
 		if model.Password != nil && *model.Password != "" {
-			if len(*model.Password) < 8 {
-				return fmt.Errorf("password must be at least 8 characters")
+			if err := h.checkPassword(ctx, op, id, model); err != nil {
+				return err
 			}
-			if err := model.HashPassword(); err != nil {
+			if err := model.HashPassword(h.passwordHasher); err != nil {
 				return err
 			}
 		}
@@ -66,3 +103,76 @@ func (h *UserHooks) StateProcessor(ctx context.Context, op hooks.Operation, id a
 
 	return nil
 }
+
+// checkPassword checks a new password against the policy and, on updates,
+// against the history of the user. Updates only carry the fields they change,
+// so the policy gets the email and names the user has once updated.
+func (h *UserHooks) checkPassword(ctx context.Context, op hooks.Operation, id any, model *models.User) error {
+	plain := *model.Password
+	if op != hooks.OperationUpdate {
+		return h.passwordPolicy.Check(plain, model.Email, model.Firstname, model.Lastname)
+	}
+
+	userID, err := uuid.Parse(fmt.Sprintf("%v", id))
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	queryStr, args, err := query.New(h.db.Dialect()).
+		Select("email", "firstname", "lastname").
+		From(model.TableName()).
+		Where(query.Eq("id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var email, firstname, lastname string
+	if err := h.db.QueryRow(ctx, queryStr, args...).Scan(&email, &firstname, &lastname); err != nil {
+		return fmt.Errorf("failed to read user: %w", err)
+	}
+
+	if err := h.passwordPolicy.Check(plain, cmp.Or(model.Email, email), cmp.Or(model.Firstname, firstname), cmp.Or(model.Lastname, lastname)); err != nil {
+		return err
+	}
+
+	return h.passwordHistory.Check(ctx, h.db, userID, plain)
+}
+
+// ModifyUpdateQuery only writes the fields set on the model. The generic
+// update writes every column, which would clear the ones a request does not
+// carry, such as the role or the email verification date.
+func (h *UserHooks) ModifyUpdateQuery(ctx context.Context, op hooks.Operation, id any, model *models.User, builder *query.UpdateBuilder) (*query.UpdateBuilder, bool) {
+	qb := query.New(h.db.Dialect()).Update(model.TableName())
+
+	if model.Firstname != "" {
+		qb = qb.Set("firstname", model.Firstname)
+	}
+	if model.Lastname != "" {
+		qb = qb.Set("lastname", model.Lastname)
+	}
+	if model.Email != "" {
+		qb = qb.Set("email", model.Email)
+	}
+	if model.Role != "" {
+		qb = qb.Set("role", model.Role)
+	}
+
+	now := time.Now()
+
+	// A new password lifts a required password change, unless the update
+	// requires it again, e.g. an admin setting a temporary password. Admins
+	// may also require or lift it on its own.
+	if model.Password != nil && *model.Password != "" {
+		qb = qb.Set("password", *model.Password).
+			Set("password_changed_at", now).
+			Set("must_change_password", model.PasswordChangeRequired())
+	} else if model.MustChangePassword != nil {
+		qb = qb.Set("must_change_password", *model.MustChangePassword)
+	}
+
+	model.UpdatedAt = &now
+	qb = qb.Set("updated_at", now)
+
+	return qb.Where(query.Eq("id", id)), true
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+// IntrospectionClient is a client allowed to call the token introspection
+// endpoint, such as an API gateway.
+type IntrospectionClient struct {
+	ID     string
+	Secret string
+}
+
+type IntrospectionRequest struct {
+	Token         string `json:"token" form:"token"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint"`
+	ClientID      string `json:"client_id" form:"client_id"`
+	ClientSecret  string `json:"client_secret" form:"client_secret"`
+}
+
+// IntrospectionResponse is the RFC 7662 token introspection response.
+// Inactive tokens only carry "active": false.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	ACR       string   `json:"acr,omitempty"`
+	AuthTime  int64    `json:"auth_time,omitempty"`
+}
+
+func handleIntrospect(tokenService TokenService, clients []IntrospectionClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req IntrospectionRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid_request")
+		}
+
+		clientID, clientSecret, ok := parseBasicAuth(c.Get(fiber.HeaderAuthorization))
+		if !ok {
+			clientID, clientSecret = req.ClientID, req.ClientSecret
+		}
+
+		if !authenticateClient(clients, clientID, clientSecret) {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="introspection"`)
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid_client")
+		}
+
+		if req.Token == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid_request")
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		response.SetCommonHeaders(c)
+
+		claims, err := tokenService.ValidateToken(req.Token)
+		if err != nil {
+			return c.Status(fiber.StatusOK).JSON(IntrospectionResponse{Active: false})
+		}
+
+		revoked, err := tokenService.IsRevoked(c.Context(), claims)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to check token revocation")
+		}
+		// Restricted tokens, such as MFA challenges, only grant access to
+		// the auth endpoints completing the login.
+		if revoked || claims.Restriction != "" {
+			return c.Status(fiber.StatusOK).JSON(IntrospectionResponse{Active: false})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(introspectionResponse(claims))
+	}
+}
+
+func introspectionResponse(claims *tokens.Claims) IntrospectionResponse {
+	result := IntrospectionResponse{
+		Active:    true,
+		TokenType: "Bearer",
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		Roles:     claims.Roles,
+		AMR:       claims.AMR,
+		ACR:       claims.ACR,
+		Scope:     claims.GetString("scope"),
+		ClientID:  claims.GetString("client_id"),
+		Username:  claims.GetString("username"),
+	}
+
+	if scopes, ok := claims.Custom["scope"].([]any); ok {
+		result.Scope = strings.Join(stringList(scopes), " ")
+	}
+	if !claims.ExpiresAt.IsZero() {
+		result.Exp = claims.ExpiresAt.Unix()
+	}
+	if !claims.IssuedAt.IsZero() {
+		result.Iat = claims.IssuedAt.Unix()
+	}
+	if !claims.AuthTime.IsZero() {
+		result.AuthTime = claims.AuthTime.Unix()
+	}
+	if !claims.NotBefore.IsZero() {
+		result.Nbf = claims.NotBefore.Unix()
+	}
+
+	return result
+}
+
+// authenticateClient compares digests so the comparison takes the same time
+// whatever the length of the presented secret.
+func authenticateClient(clients []IntrospectionClient, clientID, clientSecret string) bool {
+	if clientID == "" || clientSecret == "" {
+		return false
+	}
+
+	presented := sha256.Sum256([]byte(clientSecret))
+	for _, client := range clients {
+		if client.ID != clientID {
+			continue
+		}
+		expected := sha256.Sum256([]byte(client.Secret))
+		return subtle.ConstantTimeCompare(presented[:], expected[:]) == 1
+	}
+
+	return false
+}
+
+// parseBasicAuth reads client_secret_basic credentials. RFC 6749 requires the
+// client id and secret to be form encoded before being base64 encoded.
+func parseBasicAuth(header string) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+
+	id, secret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+
+	id, err = url.QueryUnescape(id)
+	if err != nil {
+		return "", "", false
+	}
+	secret, err = url.QueryUnescape(secret)
+	if err != nil {
+		return "", "", false
+	}
+
+	return id, secret, true
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func introspect(t *testing.T, service TokenService, token, clientID, clientSecret string) (int, IntrospectionResponse) {
+	t.Helper()
+
+	app := fiber.New()
+	app.Post("/auth/introspect", handleIntrospect(service, []IntrospectionClient{{ID: "gateway", Secret: "gateway-secret"}}))
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.SetBasicAuth(clientID, clientSecret)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result IntrospectionResponse
+	_ = json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestIntrospect(t *testing.T) {
+	service := newTestJWTService(t)
+	ctx := context.Background()
+	user := testUser()
+
+	token, err := service.GenerateToken(ctx, user, WithAuthentication(tokens.NewAuthentication(tokens.AMRPassword)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, result := introspect(t, service, token, "gateway", "gateway-secret")
+	if status != fiber.StatusOK || !result.Active {
+		t.Fatalf("expected an active token, got %d %+v", status, result)
+	}
+	if result.Sub != user.ID.String() || result.TokenType != "Bearer" || result.ACR != tokens.ACRSingleFactor {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+
+	if status, _ := introspect(t, service, token, "gateway", "wrong"); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong client secret, got %d", status)
+	}
+
+	if _, result := introspect(t, service, "not-a-token", "gateway", "gateway-secret"); result.Active {
+		t.Fatal("malformed token reported active")
+	}
+
+	claims, err := service.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Revoke(ctx, claims); err != nil {
+		t.Fatal(err)
+	}
+	if _, result := introspect(t, service, token, "gateway", "gateway-secret"); result.Active {
+		t.Fatal("revoked token reported active")
+	}
+}
+
+func TestIntrospectClientAuthentication(t *testing.T) {
+	service := newTestJWTService(t)
+	token, err := service.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/auth/introspect", handleIntrospect(service, []IntrospectionClient{{ID: "gateway", Secret: "s3cr:t"}}))
+
+	post := func(form url.Values, authorization string) (int, IntrospectionResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		if authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, authorization)
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var result IntrospectionResponse
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	tests := []struct {
+		name          string
+		form          url.Values
+		authorization string
+		expected      int
+	}{
+		{"form encoded basic credentials", url.Values{"token": {token}}, basic("gateway:s3cr%3At"), fiber.StatusOK},
+		{"credentials in the body", url.Values{"token": {token}, "client_id": {"gateway"}, "client_secret": {"s3cr:t"}}, "", fiber.StatusOK},
+		{"no credentials", url.Values{"token": {token}}, "", fiber.StatusUnauthorized},
+		{"unknown client", url.Values{"token": {token}}, basic("other:s3cr%3At"), fiber.StatusUnauthorized},
+		{"malformed basic credentials", url.Values{"token": {token}}, "Basic not-base64", fiber.StatusUnauthorized},
+		{"no token", url.Values{}, basic("gateway:s3cr%3At"), fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		status, result := post(tt.form, tt.authorization)
+		if status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d", tt.name, tt.expected, status)
+		}
+		if status == fiber.StatusOK && !result.Active {
+			t.Fatalf("%s: expected an active token", tt.name)
+		}
+	}
+}
+
+func TestIntrospectionConfig(t *testing.T) {
+	for _, clients := range []interface{}{
+		[]interface{}{"gateway"},
+		[]interface{}{map[string]interface{}{"client_id": "gateway"}},
+	} {
+		err := NewPlugin().Initialize(map[string]interface{}{"jwt_secret": testSecret, "introspection_clients": clients})
+		if err == nil {
+			t.Fatalf("expected %v to be rejected", clients)
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		app, _, _ := newTestApp(t, nil)
+		if status, _ := request(t, app, "POST", "/auth/introspect", "", map[string]string{"token": "token"}); status != fiber.StatusNotFound {
+			t.Fatalf("expected the introspection route not to be registered, got %d", status)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		app, _, db := newTestApp(t, map[string]interface{}{
+			"introspection_clients": []interface{}{
+				map[string]interface{}{"client_id": "gateway", "client_secret": "gateway-secret"},
+			},
+		})
+		userID := createTestUser(t, db, "jane@example.com", "user")
+		token, _ := login(t, app, "jane@example.com", testPassword)
+
+		status, result := request(t, app, "POST", "/auth/introspect", "", map[string]string{
+			"token":         token,
+			"client_id":     "gateway",
+			"client_secret": "gateway-secret",
+		})
+		if status != fiber.StatusOK || result["active"] != true || result["sub"] != userID.String() {
+			t.Fatalf("unexpected response: %d %v", status, result)
+		}
+	})
+}
+
+func TestIntrospectRestrictedTokens(t *testing.T) {
+	service := newTestJWTService(t)
+
+	for _, restriction := range []string{
+		tokens.RestrictionMFA,
+		tokens.RestrictionMFAEnrollment,
+		tokens.RestrictionPasswordChange,
+		tokens.RestrictionEmailUnverified,
+	} {
+		t.Run(restriction, func(t *testing.T) {
+			token, err := service.GenerateToken(context.Background(), testUser(), WithRestriction(restriction))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, result := introspect(t, service, token, "gateway", "gateway-secret")
+			if status != fiber.StatusOK || result.Active {
+				t.Fatalf("expected an inactive token, got %d %+v", status, result)
+			}
+		})
+	}
+}
@@ -1,68 +1,294 @@
 package auth
 
 import (
+	stdcontext "context"
+	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
 )
 
 type JWTService struct {
-	secret string
-	ttl    int
+	tokenPolicy
+	keys *Keyring
 }
 
+// NewJWTService creates a JWTService signing tokens with HS256 and the given
+// shared secret. It panics if the secret is empty, since anyone could sign
+// tokens with it; use NewJWTServiceWithSecret to handle the error instead.
 func NewJWTService(secret string, ttl int) *JWTService {
+	service, err := NewJWTServiceWithSecret(secret, ttl)
+	if err != nil {
+		panic(fmt.Sprintf("auth: %v", err))
+	}
+	return service
+}
+
+// NewJWTServiceWithSecret creates a JWTService signing tokens with HS256 and
+// the given shared secret, and returns an error if the secret is empty.
+// Secrets shorter than MinHMACSecretLength are deprecated and only logged;
+// use NewHMACKey and NewJWTServiceWithKey to reject them.
+func NewJWTServiceWithSecret(secret string, ttl int) (*JWTService, error) {
+	key, err := newLegacyHMACKey([]byte(secret), AlgHS256)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT secret: %w", err)
+	}
+
+	return NewJWTServiceWithKey(key, ttl)
+}
+
+// NewJWTServiceWithKey creates a JWTService using the given key. A key built
+// from a public key only yields a service that can validate but not issue
+// tokens.
+func NewJWTServiceWithKey(key *SigningKey, ttl int) (*JWTService, error) {
+	keys, err := NewKeyring(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJWTServiceWithKeyring(keys, ttl), nil
+}
+
+func NewJWTServiceWithKeyring(keys *Keyring, ttl int) *JWTService {
 	return &JWTService{
-		secret: secret,
-		ttl:    ttl,
+		tokenPolicy: newTokenPolicy(ttl),
+		keys:        keys,
 	}
 }
 
-func (j *JWTService) GenerateToken(userID string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Duration(j.ttl) * time.Second).Unix(),
-		"iat":     time.Now().Unix(),
-	})
+// NewJWTServiceFromConfig creates a JWTService from the signing settings of
+// the given configuration. Shared secrets must be at least
+// MinHMACSecretLength bytes long.
+func NewJWTServiceFromConfig(config Config) (*JWTService, error) {
+	keys, err := config.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	service := NewJWTServiceWithKeyring(keys, config.JWTTTL)
+	service.configure(config)
 
-	return token.SignedString([]byte(j.secret))
+	return service, nil
 }
 
-func (j *JWTService) ValidateToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.secret), nil
-	})
+// Keyring exposes the keys of the service, e.g. to rotate the signing key at
+// runtime.
+func (j *JWTService) Keyring() *Keyring {
+	return j.keys
+}
+
+func (j *JWTService) Algorithm() string {
+	return j.keys.Current().Algorithm
+}
 
+func (j *JWTService) GenerateToken(ctx stdcontext.Context, user *models.User, opts ...TokenOption) (string, error) {
+	key := j.keys.Current()
+	if !key.CanSign() {
+		return "", fmt.Errorf("signing key is not configured")
+	}
+
+	claims, err := j.newClaims(ctx, user, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse token: %w", err)
+		return "", err
 	}
 
-	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+	mapClaims := jwt.MapClaims{}
+	for name, value := range claims.Custom {
+		mapClaims[name] = value
+	}
+	mapClaims["jti"] = claims.ID
+	mapClaims["sub"] = claims.Subject
+	mapClaims["user_id"] = claims.Subject
+	mapClaims["exp"] = claims.ExpiresAt.Unix()
+	mapClaims["iat"] = numericDate(claims.IssuedAt)
+	mapClaims["nbf"] = claims.NotBefore.Unix()
+	if claims.Issuer != "" {
+		mapClaims["iss"] = claims.Issuer
+	}
+	if len(claims.Audience) > 0 {
+		mapClaims["aud"] = claims.Audience
+	}
+	if claims.Roles != nil {
+		mapClaims["roles"] = claims.Roles
+	}
+	if claims.Restriction != "" {
+		mapClaims["restriction"] = claims.Restriction
+	}
+	if len(claims.AMR) > 0 {
+		mapClaims["amr"] = claims.AMR
+	}
+	if claims.ACR != "" {
+		mapClaims["acr"] = claims.ACR
+	}
+	if !claims.AuthTime.IsZero() {
+		mapClaims["auth_time"] = claims.AuthTime.Unix()
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", fmt.Errorf("invalid token claims")
+	token := jwt.NewWithClaims(key.method(), mapClaims)
+	token.Header["kid"] = key.ID
+	if claims.Restriction != "" {
+		token.Header["typ"] = tokens.TypeRestricted
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return "", fmt.Errorf("user_id not found in token")
+	return token.SignedString(key.signKey)
+}
+
+func (j *JWTService) ValidateToken(tokenString string) (*tokens.Claims, error) {
+	token, err := j.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := toClaims(token.Claims.(jwt.MapClaims))
+	if err != nil {
+		return nil, err
 	}
 
-	return userID, nil
+	if err := j.checkAudience(claims); err != nil {
+		return nil, err
+	}
+	if restricted := token.Header["typ"] == tokens.TypeRestricted; restricted != (claims.Restriction != "") {
+		return nil, fmt.Errorf("%w: unexpected token type", ErrTokenInvalid)
+	}
+
+	return claims, nil
 }
 
-func (j *JWTService) RefreshToken(tokenString string) (string, error) {
-	userID, err := j.ValidateToken(tokenString)
+// numericDate encodes the issue time in seconds, with its milliseconds as a
+// fraction so that user revocations tell apart tokens issued in the same
+// second.
+func numericDate(t time.Time) any {
+	if t.Nanosecond() == 0 {
+		return t.Unix()
+	}
+	return float64(t.UnixMilli()) / 1000
+}
+
+// parse checks the signature and the time based claims. The audience
+// depends on the restriction of the token and is checked by ValidateToken.
+func (j *JWTService) parse(tokenString string) (*jwt.Token, error) {
+	options := []jwt.ParserOption{
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(j.leeway),
+	}
+	if j.issuer != "" {
+		options = append(options, jwt.WithIssuer(j.issuer))
+	}
+
+	token, err := jwt.Parse(tokenString, j.verificationKey, options...)
+
 	if err != nil {
-		return "", fmt.Errorf("cannot refresh invalid token: %w", err)
+		return nil, classifyTokenError(err)
+	}
+
+	if !token.Valid {
+		return nil, ErrTokenInvalid
+	}
+
+	if _, ok := token.Claims.(jwt.MapClaims); !ok {
+		return nil, fmt.Errorf("%w: invalid token claims", ErrTokenInvalid)
+	}
+
+	return token, nil
+}
+
+// classifyTokenError maps parser errors onto the package errors so callers
+// can tell an expired token from a forged one with errors.Is.
+func classifyTokenError(err error) error {
+	var kind error
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		kind = ErrTokenMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		kind = ErrTokenSignatureInvalid
+	case errors.Is(err, jwt.ErrTokenExpired):
+		kind = ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		kind = ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		kind = ErrTokenInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		kind = ErrTokenInvalidAudience
+	default:
+		kind = ErrTokenInvalid
+	}
+
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+func toClaims(claims jwt.MapClaims) (*tokens.Claims, error) {
+	result := &tokens.Claims{
+		Custom: make(map[string]any),
+	}
+
+	result.ID, _ = claims["jti"].(string)
+	result.Issuer, _ = claims.GetIssuer()
+	result.Audience, _ = claims.GetAudience()
+
+	// Tokens issued before "sub" was set only carry the legacy user_id claim.
+	result.Subject, _ = claims.GetSubject()
+	if result.Subject == "" {
+		result.Subject, _ = claims["user_id"].(string)
+	}
+	if result.Subject == "" {
+		return nil, fmt.Errorf("%w: subject not found in token", ErrTokenInvalid)
+	}
+
+	result.Restriction, _ = claims["restriction"].(string)
+	result.ACR, _ = claims["acr"].(string)
+	result.AMR = stringList(claims["amr"])
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		result.AuthTime = time.Unix(int64(authTime), 0)
+	}
+
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		result.ExpiresAt = exp.Time
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		result.IssuedAt = time.UnixMilli(int64(math.Round(iat * 1000)))
+	}
+	if nbf, _ := claims.GetNotBefore(); nbf != nil {
+		result.NotBefore = nbf.Time
+	}
+
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		result.Roles = make([]string, 0, len(roles))
+		for _, role := range roles {
+			if value, ok := role.(string); ok {
+				result.Roles = append(result.Roles, value)
+			}
+		}
+	}
+
+	for name, value := range claims {
+		if !tokens.IsReserved(name) {
+			result.Custom[name] = value
+		}
+	}
+
+	return result, nil
+}
+
+// verificationKey selects the key matching the token "kid" header. Tokens
+// issued before key ids were introduced carry no kid and are checked against
+// the current key.
+func (j *JWTService) verificationKey(token *jwt.Token) (interface{}, error) {
+	key := j.keys.Current()
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok = j.keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 	}
 
-	return j.GenerateToken(userID)
+	return key.verifyKey, nil
 }
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestSigner(t *testing.T, algorithm string) crypto.Signer {
+	t.Helper()
+
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgES384:
+		signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %s", algorithm)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func publicKeyPEM(t *testing.T, signer crypto.Signer) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	for _, algorithm := range []string{AlgRS256, AlgES256, AlgES384, AlgEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			signer := newTestSigner(t, algorithm)
+
+			// The algorithm is derived from the key when not given.
+			key, err := NewSigningKey(signer, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Algorithm != algorithm {
+				t.Fatalf("expected %s, got %s", algorithm, key.Algorithm)
+			}
+
+			service, err := NewJWTServiceWithKey(key, 900)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := testUser()
+			token, err := service.GenerateToken(context.Background(), user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Method.Alg() != algorithm || parsed.Header["kid"] != key.ID {
+				t.Fatalf("unexpected header: %v", parsed.Header)
+			}
+
+			// Verifiers only need the public key.
+			publicKey, err := ParsePublicKeyPEM(publicKeyPEM(t, signer), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier, err := NewJWTServiceWithKey(publicKey, 900)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := verifier.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("token rejected: %v", err)
+			}
+			if claims.Subject != user.ID.String() {
+				t.Fatalf("unexpected subject %s", claims.Subject)
+			}
+			if _, err := verifier.GenerateToken(context.Background(), user); err == nil {
+				t.Fatal("expected a verification key not to sign tokens")
+			}
+
+			// Tokens of another key pair claiming the same kid do not verify.
+			otherKey, err := NewSigningKey(newTestSigner(t, algorithm), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherKey.ID = publicKey.ID
+			other, err := NewJWTServiceWithKey(otherKey, 900)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forged, err := other.GenerateToken(context.Background(), user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := verifier.ValidateToken(forged); !errors.Is(err, ErrTokenSignatureInvalid) {
+				t.Fatalf("expected ErrTokenSignatureInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey := newTestSigner(t, AlgRS256).(*rsa.PrivateKey)
+	ecKey := newTestSigner(t, AlgES256).(*ecdsa.PrivateKey)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(newTestSigner(t, AlgEdDSA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		block     *pem.Block
+		algorithm string
+	}{
+		{&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, AlgRS256},
+		{&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, AlgES256},
+		{&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}, AlgEdDSA},
+	}
+
+	for _, tt := range tests {
+		key, err := ParsePrivateKeyPEM(pem.EncodeToMemory(tt.block), "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.block.Type, err)
+		}
+		if key.Algorithm != tt.algorithm || !key.CanSign() {
+			t.Fatalf("%s: unexpected key %s", tt.block.Type, key.Algorithm)
+		}
+	}
+
+	if _, err := ParsePrivateKeyPEM([]byte("not a key"), ""); err == nil {
+		t.Fatal("expected invalid PEM to be rejected")
+	}
+	if _, err := NewSigningKey(ecKey, AlgRS256); err == nil {
+		t.Fatal("expected an ECDSA key to be rejected for RS256")
+	}
+	if _, err := NewSigningKey(ecKey, AlgES384); err == nil {
+		t.Fatal("expected a P-256 key to be rejected for ES384")
+	}
+}
+
+func TestAlgorithmConfusionRejected(t *testing.T) {
+	signer := newTestSigner(t, AlgRS256)
+	key, err := NewSigningKey(signer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewJWTServiceWithKey(key, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{
+		"sub": uuid.NewString(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+
+	// An HMAC token keyed with the published public key.
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmacToken.Header["kid"] = key.ID
+	forged, err := hmacToken.SignedString(publicKeyPEM(t, signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ValidateToken(forged); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Fatalf("expected ErrTokenSignatureInvalid for HS256, got %v", err)
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsigned.Header["kid"] = key.ID
+	forged, err = unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ValidateToken(forged); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Fatalf("expected ErrTokenSignatureInvalid for none, got %v", err)
+	}
+}
+
+func TestRegisteredClaims(t *testing.T) {
+	service := newTestJWTService(t)
+	service.SetIssuer("https://auth.example.com")
+	service.SetAudiences("api", "admin")
+	service.SetClockSkew(30 * time.Second)
+
+	user := testUser()
+	first, err := service.GenerateToken(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := service.ValidateToken(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "https://auth.example.com" || len(claims.Audience) != 2 || claims.Subject != user.ID.String() {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if claims.ID == "" || claims.NotBefore.After(claims.IssuedAt) || !claims.ExpiresAt.After(claims.IssuedAt) {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	second, err := service.GenerateToken(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := service.ValidateToken(second); err != nil || other.ID == claims.ID {
+		t.Fatalf("expected a unique jti, got %v", err)
+	}
+
+	key := service.Keyring().Current()
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = key.ID
+		signed, err := token.SignedString(key.signKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	now := time.Now()
+	valid := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"sub": user.ID.String(),
+			"iss": "https://auth.example.com",
+			"aud": []string{"api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"iat": now.Unix(),
+			"nbf": now.Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		expected error
+	}{
+		{"valid", valid(nil), nil},
+		{"one of the audiences", valid(jwt.MapClaims{"aud": "admin"}), nil},
+		{"expired within the skew", valid(jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()}), nil},
+		{"not yet valid within the skew", valid(jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix()}), nil},
+		{"legacy user_id", valid(jwt.MapClaims{"sub": nil, "user_id": user.ID.String()}), nil},
+		{"expired", valid(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}), ErrTokenExpired},
+		{"not yet valid", valid(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}), ErrTokenNotYetValid},
+		{"issued in the future", valid(jwt.MapClaims{"iat": now.Add(time.Minute).Unix()}), ErrTokenNotYetValid},
+		{"no expiry", valid(jwt.MapClaims{"exp": nil}), ErrTokenInvalid},
+		{"other issuer", valid(jwt.MapClaims{"iss": "https://evil.example.com"}), ErrTokenInvalidIssuer},
+		{"no issuer", valid(jwt.MapClaims{"iss": nil}), ErrTokenInvalid},
+		{"other audience", valid(jwt.MapClaims{"aud": []string{"billing"}}), ErrTokenInvalidAudience},
+		{"no audience", valid(jwt.MapClaims{"aud": nil}), ErrTokenInvalidAudience},
+		{"no subject", valid(jwt.MapClaims{"sub": nil}), ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		if _, err := service.ValidateToken(sign(tt.claims)); !errors.Is(err, tt.expected) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
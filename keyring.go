@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Keyring holds the key currently used to sign tokens along with retired
+// keys that are still accepted when validating tokens. It is safe for
+// concurrent use so keys can be rotated while the server is running.
+type Keyring struct {
+	mu      sync.RWMutex
+	current *SigningKey
+	keys    map[string]*SigningKey
+	order   []string
+
+	// algorithms, when set, are the only algorithms accepted for new keys.
+	algorithms []string
+}
+
+func NewKeyring(current *SigningKey, verificationKeys ...*SigningKey) (*Keyring, error) {
+	if current == nil {
+		return nil, fmt.Errorf("current key is required")
+	}
+
+	k := &Keyring{
+		keys: make(map[string]*SigningKey),
+	}
+
+	for _, key := range verificationKeys {
+		if err := k.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.add(current); err != nil {
+		return nil, err
+	}
+	k.current = current
+
+	return k, nil
+}
+
+// Current returns the key used to sign new tokens.
+func (k *Keyring) Current() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Lookup returns the key registered under the given kid.
+func (k *Keyring) Lookup(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// Keys returns every key accepted for validation, current key first.
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(k.order))
+	keys = append(keys, k.current)
+	for _, kid := range k.order {
+		if kid != k.current.ID {
+			keys = append(keys, k.keys[kid])
+		}
+	}
+	return keys
+}
+
+// Rotate makes next the signing key. The previous signing key is kept as a
+// verification key so tokens it issued stay valid until they expire or the
+// key is retired.
+func (k *Keyring) Rotate(next *SigningKey) error {
+	if next == nil {
+		return fmt.Errorf("next key is required")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.add(next); err != nil {
+		return err
+	}
+	k.current = next
+
+	return nil
+}
+
+// AddVerificationKey accepts tokens signed by key without using it to sign.
+func (k *Keyring) AddVerificationKey(key *SigningKey) error {
+	if key == nil {
+		return fmt.Errorf("key is required")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.add(key)
+}
+
+// Retire stops accepting tokens signed by the key with the given kid. The
+// current signing key cannot be retired; rotate it first.
+func (k *Keyring) Retire(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("unknown key id: %s", kid)
+	}
+	if k.current.ID == kid {
+		return fmt.Errorf("cannot retire the current signing key")
+	}
+
+	delete(k.keys, kid)
+	for i, id := range k.order {
+		if id == kid {
+			k.order = append(k.order[:i], k.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// restrict rejects keys using other algorithms, including the keys already
+// in the keyring, e.g. for token formats bound to a single algorithm.
+func (k *Keyring) restrict(algorithms ...string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, key := range k.keys {
+		if err := checkAlgorithm(key, algorithms); err != nil {
+			return err
+		}
+	}
+	k.algorithms = algorithms
+
+	return nil
+}
+
+func checkAlgorithm(key *SigningKey, algorithms []string) error {
+	if len(algorithms) > 0 && !slices.Contains(algorithms, key.Algorithm) {
+		return fmt.Errorf("key %s uses %s, expected %s", key.ID, key.Algorithm, strings.Join(algorithms, " or "))
+	}
+	return nil
+}
+
+func (k *Keyring) add(key *SigningKey) error {
+	if key.ID == "" {
+		thumbprint, err := key.Thumbprint()
+		if err != nil {
+			return fmt.Errorf("failed to derive key id: %w", err)
+		}
+		key.ID = thumbprint
+	}
+
+	if err := checkAlgorithm(key, k.algorithms); err != nil {
+		return err
+	}
+
+	if _, exists := k.keys[key.ID]; !exists {
+		k.order = append(k.order, key.ID)
+	}
+	k.keys[key.ID] = key
+
+	return nil
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgHS384 = "HS384"
+	AlgHS512 = "HS512"
+	AlgRS256 = "RS256"
+	AlgRS384 = "RS384"
+	AlgRS512 = "RS512"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey holds the key material used to sign and verify tokens for a
+// single algorithm. Keys built from a public key only can verify tokens but
+// cannot sign them.
+type SigningKey struct {
+	// ID is published as the JWT "kid" header. When left empty it is derived
+	// from the key material once the key is added to a Keyring.
+	ID        string
+	Algorithm string
+
+	signKey   any
+	verifyKey any
+}
+
+// MinHMACSecretLength is the minimum length, in bytes, of shared secrets.
+// Shorter secrets can be brute forced from a single token.
+const MinHMACSecretLength = 32
+
+func NewHMACKey(secret []byte, algorithm string) (*SigningKey, error) {
+	if len(secret) > 0 && len(secret) < MinHMACSecretLength {
+		return nil, fmt.Errorf("shared secret must be at least %d bytes", MinHMACSecretLength)
+	}
+
+	return newHMACKey(secret, algorithm)
+}
+
+// newLegacyHMACKey builds the key of the jwt_secret option and of
+// NewJWTService, which accepted secrets of any length before
+// MinHMACSecretLength was enforced. Short secrets are still accepted, with a
+// deprecation warning.
+func newLegacyHMACKey(secret []byte, algorithm string) (*SigningKey, error) {
+	key, err := newHMACKey(secret, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secret) < MinHMACSecretLength {
+		log.Printf("[gorest-auth] deprecated: the JWT secret is shorter than %d bytes and will be rejected by a future release", MinHMACSecretLength)
+	}
+
+	return key, nil
+}
+
+func newHMACKey(secret []byte, algorithm string) (*SigningKey, error) {
+	if algorithm == "" {
+		algorithm = AlgHS256
+	}
+
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("shared secret is required")
+	}
+
+	switch algorithm {
+	case AlgHS256, AlgHS384, AlgHS512:
+	default:
+		return nil, fmt.Errorf("algorithm %s cannot be used with a shared secret", algorithm)
+	}
+
+	return &SigningKey{
+		Algorithm: algorithm,
+		signKey:   secret,
+		verifyKey: secret,
+	}, nil
+}
+
+func NewSigningKey(privateKey crypto.Signer, algorithm string) (*SigningKey, error) {
+	if privateKey == nil {
+		return nil, fmt.Errorf("private key is required")
+	}
+
+	alg, err := resolveAlgorithm(privateKey.Public(), algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		Algorithm: alg,
+		signKey:   privateKey,
+		verifyKey: privateKey.Public(),
+	}, nil
+}
+
+func NewVerificationKey(publicKey crypto.PublicKey, algorithm string) (*SigningKey, error) {
+	if publicKey == nil {
+		return nil, fmt.Errorf("public key is required")
+	}
+
+	alg, err := resolveAlgorithm(publicKey, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		Algorithm: alg,
+		verifyKey: publicKey,
+	}, nil
+}
+
+func ParsePrivateKeyPEM(data []byte, algorithm string) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in private key")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key PEM type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return NewSigningKey(signer, algorithm)
+}
+
+func ParsePublicKeyPEM(data []byte, algorithm string) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in public key")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key PEM type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	return NewVerificationKey(key, algorithm)
+}
+
+func LoadPrivateKeyFile(path, algorithm string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	return ParsePrivateKeyPEM(data, algorithm)
+}
+
+func LoadPublicKeyFile(path, algorithm string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	return ParsePublicKeyPEM(data, algorithm)
+}
+
+func (k *SigningKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+// PublicKey returns the asymmetric public key, or nil for HMAC keys.
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	if k.isHMAC() {
+		return nil
+	}
+	return k.verifyKey
+}
+
+// Thumbprint returns a stable identifier for the key: the RFC 7638 JWK
+// thumbprint for asymmetric keys and a digest of the secret for HMAC keys.
+func (k *SigningKey) Thumbprint() (string, error) {
+	if secret, ok := k.verifyKey.([]byte); ok {
+		sum := sha256.Sum256(append([]byte(k.Algorithm+":"), secret...))
+		return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+	}
+
+	params, err := publicKeyParams(k.verifyKey)
+	if err != nil {
+		return "", err
+	}
+
+	// encoding/json sorts map keys and emits no whitespace, which is exactly
+	// the canonical form required by RFC 7638.
+	canonical, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (k *SigningKey) isHMAC() bool {
+	_, ok := k.verifyKey.([]byte)
+	return ok
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// publicKeyParams returns the required JWK members of a public key.
+func publicKeyParams(publicKey crypto.PublicKey) (map[string]string, error) {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECDSA public key: %w", err)
+		}
+		// Uncompressed point encoding: 0x04 || X || Y, each coordinate padded
+		// to the curve size.
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+		return map[string]string{
+			"kty": "EC",
+			"crv": pub.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+			"y":   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+func resolveAlgorithm(publicKey crypto.PublicKey, algorithm string) (string, error) {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm == "" {
+			return AlgRS256, nil
+		}
+		switch algorithm {
+		case AlgRS256, AlgRS384, AlgRS512:
+			return algorithm, nil
+		}
+	case *ecdsa.PublicKey:
+		curveAlg, err := ecdsaAlgorithm(pub.Curve)
+		if err != nil {
+			return "", err
+		}
+		if algorithm == "" || algorithm == curveAlg {
+			return curveAlg, nil
+		}
+	case ed25519.PublicKey:
+		if algorithm == "" || algorithm == AlgEdDSA {
+			return AlgEdDSA, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+
+	return "", fmt.Errorf("algorithm %s is not compatible with %T", algorithm, publicKey)
+}
+
+func ecdsaAlgorithm(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return AlgES256, nil
+	case elliptic.P384():
+		return AlgES384, nil
+	case elliptic.P521():
+		return AlgES512, nil
+	default:
+		return "", fmt.Errorf("unsupported elliptic curve: %s", curve.Params().Name)
+	}
+}
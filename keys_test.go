@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/models"
+)
+
+const testSecret = "test-secret-test-secret-test-secret"
+
+func testUser() *models.User {
+	return &models.User{ID: uuid.New(), Email: "jane@example.com", Role: "user"}
+}
+
+func newTestJWTService(t *testing.T) *JWTService {
+	t.Helper()
+
+	key, err := NewHMACKey([]byte(testSecret), AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewJWTServiceWithKey(key, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func newTestEd25519Key(t *testing.T, kid string) *SigningKey {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewSigningKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.ID = kid
+	return key
+}
+
+func TestNewHMACKeyRejectsWeakSecrets(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"empty", ""},
+		{"short", "secret"},
+		{"one byte short", strings.Repeat("a", MinHMACSecretLength-1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHMACKey([]byte(tt.secret), AlgHS256); err == nil {
+				t.Fatalf("expected %q to be rejected", tt.secret)
+			}
+		})
+	}
+
+	if _, err := NewHMACKey([]byte(testSecret), AlgHS512); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewHMACKey([]byte(testSecret), AlgRS256); err == nil {
+		t.Fatal("expected RS256 to be rejected for a shared secret")
+	}
+}
+
+func TestNewJWTService(t *testing.T) {
+	service := NewJWTService(testSecret, 900)
+	token, err := service.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ValidateToken(token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Short secrets are deprecated but still accepted.
+	legacy := NewJWTService("secret", 900)
+	token, err = legacy.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.ValidateToken(token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := NewJWTServiceWithSecret("", 900); err == nil || !strings.Contains(err.Error(), "invalid JWT secret") {
+		t.Fatalf("expected an error for an empty secret, got %v", err)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered == nil || !strings.Contains(fmt.Sprint(recovered), "invalid JWT secret") {
+			t.Fatalf("expected a panic for an empty secret, got %v", recovered)
+		}
+	}()
+	NewJWTService("", 900)
+}
+
+func TestKeyConfigRequiresKeyMaterial(t *testing.T) {
+	if _, err := (KeyConfig{}).SigningKey(); err == nil {
+		t.Fatal("expected an empty key config to be rejected")
+	}
+	if _, err := (KeyConfig{ID: "old", Algorithm: AlgHS256}).SigningKey(); err == nil {
+		t.Fatal("expected a key config without secret to be rejected")
+	}
+
+	config := DefaultConfig()
+	config.JWTSecret = testSecret
+	config.JWTPreviousKeys = []KeyConfig{{ID: "old"}}
+	if _, err := config.Keyring(); err == nil {
+		t.Fatal("expected a previous key without material to be rejected")
+	}
+}
+
+func TestInitializeRequiresSigningKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"no secret", map[string]interface{}{}},
+		{"empty secret", map[string]interface{}{"jwt_secret": ""}},
+		{"unparseable previous key", map[string]interface{}{
+			"jwt_secret":        testSecret,
+			"jwt_previous_keys": []interface{}{map[string]interface{}{"kid": "old", "secret": 42}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewPlugin().Initialize(tt.config); err == nil {
+				t.Fatal("expected Initialize to fail")
+			}
+		})
+	}
+
+	if err := NewPlugin().Initialize(map[string]interface{}{"jwt_secret": testSecret}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestShortJWTSecretIsDeprecated(t *testing.T) {
+	// The jwt_secret option accepted short secrets before the length check.
+	if err := NewPlugin().Initialize(map[string]interface{}{"jwt_secret": "secret"}); err != nil {
+		t.Fatalf("expected a short jwt_secret to be accepted, got %v", err)
+	}
+
+	// Newer options and constructors reject them.
+	config := DefaultConfig()
+	config.JWTSecret = "secret"
+	if _, err := NewJWTServiceFromConfig(config); err == nil {
+		t.Fatal("expected NewJWTServiceFromConfig to reject a short secret")
+	}
+
+	config.JWTSecret = testSecret
+	config.JWTPreviousKeys = []KeyConfig{{Secret: "secret"}}
+	if _, err := NewJWTServiceFromConfig(config); err == nil {
+		t.Fatal("expected NewJWTServiceFromConfig to reject a short previous secret")
+	}
+}
+
+func TestRotateShortJWTSecret(t *testing.T) {
+	short := "0123456789abcdef"
+
+	old := NewPlugin().(*AuthPlugin)
+	if err := old.Initialize(map[string]interface{}{"jwt_secret": short}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.TokenService().GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The short secret is kept as a previous key while a longer one signs.
+	rotated := NewPlugin().(*AuthPlugin)
+	err = rotated.Initialize(map[string]interface{}{
+		"jwt_secret":        testSecret,
+		"jwt_previous_keys": []interface{}{map[string]interface{}{"secret": short}},
+	})
+	if err != nil {
+		t.Fatalf("expected a short previous secret to be accepted, got %v", err)
+	}
+	if _, err := rotated.TokenService().ValidateToken(token); err != nil {
+		t.Fatalf("expected the token of the short secret to stay valid, got %v", err)
+	}
+
+	next, err := rotated.TokenService().GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.TokenService().ValidateToken(next); err == nil {
+		t.Fatal("expected new tokens to be signed with the new secret")
+	}
+}
+
+func TestEmptySecretForgeryRejected(t *testing.T) {
+	key, err := NewHMACKey([]byte(testSecret), AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewJWTServiceWithKey(key, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": uuid.NewString(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	})
+	forged.Header["kid"] = key.ID
+	token, err := forged.SignedString([]byte(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.ValidateToken(token); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Fatalf("expected ErrTokenSignatureInvalid, got %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	first := newTestEd25519Key(t, "first")
+	keys, err := NewKeyring(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewJWTServiceWithKeyring(keys, 900)
+
+	ctx := context.Background()
+	oldToken, err := service.GenerateToken(ctx, testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.Rotate(newTestEd25519Key(t, "second")); err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := service.GenerateToken(ctx, testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != "second" {
+		t.Fatalf("expected kid second, got %v", parsed.Header["kid"])
+	}
+
+	if _, err := service.ValidateToken(oldToken); err != nil {
+		t.Fatalf("token of the previous key rejected: %v", err)
+	}
+
+	if err := keys.Retire("second"); err == nil {
+		t.Fatal("expected the current key not to be retirable")
+	}
+	if err := keys.Retire("first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ValidateToken(oldToken); err == nil {
+		t.Fatal("token of a retired key accepted")
+	}
+	if _, err := service.ValidateToken(newToken); err != nil {
+		t.Fatalf("token of the current key rejected: %v", err)
+	}
+}
+
+func TestKeyringDerivesKeyID(t *testing.T) {
+	key := newTestEd25519Key(t, "")
+	if _, err := NewKeyring(key); err != nil {
+		t.Fatal(err)
+	}
+
+	thumbprint, err := key.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID == "" || key.ID != thumbprint {
+		t.Fatalf("expected kid %q, got %q", thumbprint, key.ID)
+	}
+}
+
+func TestPreviousKeysFromConfig(t *testing.T) {
+	const oldSecret = "old-secret-old-secret-old-secret-old"
+
+	oldKey, err := NewHMACKey([]byte(oldSecret), AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey.ID = "old"
+	oldService, err := NewJWTServiceWithKey(oldKey, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := oldService.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := NewPlugin().(*AuthPlugin)
+	err = plugin.Initialize(map[string]interface{}{
+		"jwt_secret": testSecret,
+		"jwt_key_id": "current",
+		"jwt_previous_keys": []interface{}{
+			map[string]interface{}{"kid": "old", "secret": oldSecret},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := plugin.TokenService()
+
+	if _, err := service.ValidateToken(oldToken); err != nil {
+		t.Fatalf("token of a previous key rejected: %v", err)
+	}
+
+	newToken, err := service.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != "current" {
+		t.Fatalf("expected kid current, got %v", parsed.Header["kid"])
+	}
+
+	// Runtime rotation keeps both keys until the old one is retired.
+	if err := plugin.RotateSigningKey(newTestEd25519Key(t, "next")); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := service.ValidateToken(token); err != nil {
+			t.Fatalf("token rejected after rotation: %v", err)
+		}
+	}
+	if err := plugin.RetireSigningKey("old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ValidateToken(oldToken); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Fatalf("expected ErrTokenSignatureInvalid for a retired key, got %v", err)
+	}
+	if err := plugin.RetireSigningKey("next"); err == nil {
+		t.Fatal("expected the current key not to be retirable")
+	}
+}
+
+func TestUnknownKeyIDRejected(t *testing.T) {
+	service := newTestJWTService(t)
+	token, err := service.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestJWTService(t)
+	if err := other.Keyring().Rotate(newTestEd25519Key(t, "other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Keyring().Retire(service.Keyring().Current().ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ValidateToken(token); !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Fatalf("expected ErrTokenSignatureInvalid for an unknown kid, got %v", err)
+	}
+}
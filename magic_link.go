@@ -0,0 +1,494 @@
+package auth
+
+import (
+	stdcontext "context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+// magicLinkMaxAttempts is how many wrong codes invalidate a login email.
+const magicLinkMaxAttempts = 5
+
+// errMagicLinkCooldown is returned when a login email was sent to the
+// address too recently.
+var errMagicLinkCooldown = errors.New("login email requested too recently")
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// MagicLinkVerifyRequest carries either the token of the link, or the email
+// address and the code it received.
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// magicLinkStore persists the links and codes of passwordless logins. Each
+// email holds both, only their SHA-256 digests are stored, and using either
+// one uses the other. Wrong codes are counted per email address: a new email
+// carries over the count of the previous one until a login succeeds or the
+// previous one expires.
+type magicLinkStore struct {
+	db       database.Database
+	ttl      time.Duration
+	cooldown time.Duration
+	purges   purgeSchedule
+}
+
+func newMagicLinkStore(db database.Database, ttl, cooldown int) *magicLinkStore {
+	return &magicLinkStore{
+		db:       db,
+		ttl:      time.Duration(ttl) * time.Second,
+		cooldown: time.Duration(cooldown) * time.Second,
+	}
+}
+
+// issue creates a token and a code for the email address and invalidates
+// the ones sent before, so only the latest email works. It returns
+// errMagicLinkCooldown while the previous email is within the cooldown.
+func (s *magicLinkStore) issue(ctx stdcontext.Context, email string) (string, string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	code, err := generateLoginCode()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+
+	failedAttempts, err := s.previousAttempts(ctx, email, now)
+	if err != nil {
+		return "", "", err
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("magic_link_tokens").
+		Set("used_at", now).
+		Where(query.Eq("email", email)).
+		Where(query.IsNull("used_at")).
+		Build()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return "", "", fmt.Errorf("failed to invalidate tokens: %w", err)
+	}
+
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Insert("magic_link_tokens").
+		Columns("id", "email", "token_hash", "code_hash", "failed_attempts", "expires_at", "created_at").
+		Values(uuid.New(), email, hashOpaqueToken(token), hashOpaqueToken(code), failedAttempts, now.Add(s.ttl), now).
+		Build()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return "", "", fmt.Errorf("failed to store token: %w", err)
+	}
+
+	if s.purges.due() {
+		if err := s.purge(ctx); err != nil {
+			log.Printf("[gorest-auth] magic link purge: %v", err)
+		}
+	}
+
+	return token, code, nil
+}
+
+// purge deletes the expired and used logins. issue runs it every
+// tokenPurgeInterval emails. Logins invalidated by wrong codes are kept until
+// they expire, since the next email to the address carries their count over.
+func (s *magicLinkStore) purge(ctx stdcontext.Context) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("magic_link_tokens").
+		Where(query.Or(
+			query.Lt("expires_at", time.Now()),
+			query.And(query.IsNotNull("used_at"), query.Lt("failed_attempts", magicLinkMaxAttempts)),
+		)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to delete stale tokens: %w", err)
+	}
+
+	return nil
+}
+
+// previousAttempts returns the wrong codes counted by the latest email sent
+// to the address that has not expired.
+func (s *magicLinkStore) previousAttempts(ctx stdcontext.Context, email string, now time.Time) (int, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("failed_attempts", "created_at").
+		From("magic_link_tokens").
+		Where(query.Eq("email", email)).
+		Where(query.Gt("expires_at", now)).
+		OrderBy("created_at", query.DESC).
+		Limit(1).
+		Build()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var failedAttempts int
+	var createdAt time.Time
+	err = s.db.QueryRow(ctx, queryStr, args...).Scan(&failedAttempts, &createdAt)
+	if crud.IsNotFoundError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+
+	if now.Sub(createdAt) < s.cooldown {
+		return 0, errMagicLinkCooldown
+	}
+
+	return failedAttempts, nil
+}
+
+// findToken returns the id of a valid login token and the email address it
+// was sent to.
+func (s *magicLinkStore) findToken(ctx stdcontext.Context, token string) (uuid.UUID, string, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id", "email", "expires_at", "used_at").
+		From("magic_link_tokens").
+		Where(query.Eq("token_hash", hashOpaqueToken(token))).
+		Build()
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var id uuid.UUID
+	var email string
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err = s.db.QueryRow(ctx, queryStr, args...).Scan(&id, &email, &expiresAt, &usedAt)
+	if crud.IsNotFoundError(err) {
+		return uuid.Nil, "", ErrActionTokenInvalid
+	}
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("database error: %w", err)
+	}
+
+	if usedAt != nil {
+		return uuid.Nil, "", ErrActionTokenInvalid
+	}
+	if time.Now().After(expiresAt) {
+		return uuid.Nil, "", ErrActionTokenExpired
+	}
+
+	return id, email, nil
+}
+
+// findCode returns the id of the login token whose code was sent to the
+// email address. Each attempt is counted before the code is compared, outside
+// of any transaction, and the email is invalidated once
+// magicLinkMaxAttempts is reached.
+func (s *magicLinkStore) findCode(ctx stdcontext.Context, email, code string) (uuid.UUID, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id", "code_hash", "expires_at").
+		From("magic_link_tokens").
+		Where(query.Eq("email", email)).
+		Where(query.IsNull("used_at")).
+		OrderBy("created_at", query.DESC).
+		Limit(1).
+		Build()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var id uuid.UUID
+	var codeHash string
+	var expiresAt time.Time
+	err = s.db.QueryRow(ctx, queryStr, args...).Scan(&id, &codeHash, &expiresAt)
+	if crud.IsNotFoundError(err) {
+		return uuid.Nil, ErrActionTokenInvalid
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if time.Now().After(expiresAt) {
+		return uuid.Nil, ErrActionTokenExpired
+	}
+
+	dialect := s.db.Dialect()
+	queryStr = "UPDATE magic_link_tokens SET failed_attempts = failed_attempts + 1" +
+		" WHERE id = " + dialect.Placeholder(1) +
+		" AND used_at IS NULL AND failed_attempts < " + dialect.Placeholder(2)
+
+	result, err := s.db.Exec(ctx, queryStr, id, magicLinkMaxAttempts)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	// The email was used, or its attempts are exhausted.
+	if affected == 0 {
+		return uuid.Nil, ErrActionTokenInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashOpaqueToken(code)), []byte(codeHash)) == 1 {
+		return id, nil
+	}
+
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Update("magic_link_tokens").
+		Set("used_at", time.Now()).
+		Where(query.Eq("id", id)).
+		Where(query.IsNull("used_at")).
+		Where(query.Gte("failed_attempts", magicLinkMaxAttempts)).
+		Build()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	return uuid.Nil, ErrActionTokenInvalid
+}
+
+// use marks the login token as used and clears its wrong codes, so the next
+// email starts afresh. Run it in the transaction logging the user in so a
+// failure leaves the token usable.
+func (s *magicLinkStore) use(ctx stdcontext.Context, exec queryExecutor, id uuid.UUID) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("magic_link_tokens").
+		Set("used_at", time.Now()).
+		Set("failed_attempts", 0).
+		Where(query.Eq("id", id)).
+		Where(query.IsNull("used_at")).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := exec.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return fmt.Errorf("failed to consume token: %w", err)
+	}
+
+	// Another request used the token between our read and write.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrActionTokenInvalid
+	}
+
+	return nil
+}
+
+// handleMagicLink always answers the same way, and sends the email in the
+// background, so the response never reveals whether the email exists.
+func handleMagicLink(db database.Database, magicLinks *magicLinkStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req MagicLinkRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Email == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "email is required")
+		}
+
+		go func(email, locale string) {
+			ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), mailTimeout)
+			defer cancel()
+
+			if err := sendMagicLink(ctx, db, magicLinks, config, email, locale); err != nil {
+				log.Printf("[gorest-auth] magic link: %v", err)
+			}
+		}(req.Email, requestLocale(c))
+
+		return response.SendFormatted(c, fiber.StatusAccepted, fiber.Map{
+			"message": "if this email can log in, a login link has been sent",
+		})
+	}
+}
+
+// handleVerifyMagicLink logs in the owner of the email address, creating
+// their account when auto-registration is enabled. Using the link proves
+// the address, so it is marked as verified. Users who enrolled a second
+// factor still have to provide it.
+func handleVerifyMagicLink(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, magicLinks *magicLinkStore, mfa *mfaStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req MagicLinkVerifyRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		ctx := c.Context()
+
+		var id uuid.UUID
+		var email string
+		var err error
+		switch {
+		case req.Token != "":
+			id, email, err = magicLinks.findToken(ctx, req.Token)
+		case req.Email != "" && req.Code != "":
+			email = req.Email
+			id, err = magicLinks.findCode(ctx, req.Email, req.Code)
+		default:
+			return response.SendError(c, fiber.StatusBadRequest, "token, or email and code, are required")
+		}
+		if errors.Is(err, ErrActionTokenInvalid) || errors.Is(err, ErrActionTokenExpired) {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid or expired login code")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to log in")
+		}
+
+		user, err := getUser(ctx, db, query.Eq("email", email))
+		if err != nil && !crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to log in")
+		}
+		if err != nil && !config.MagicLinkAutoRegister {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid or expired login code")
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to log in")
+		}
+		defer tx.Rollback(ctx)
+
+		err = magicLinks.use(ctx, tx, id)
+		if errors.Is(err, ErrActionTokenInvalid) {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid or expired login code")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to log in")
+		}
+
+		now := time.Now()
+		switch {
+		case user == nil:
+			user = &models.User{
+				ID:              uuid.New(),
+				Email:           email,
+				Role:            "user",
+				EmailVerifiedAt: &now,
+				CreatedAt:       now,
+			}
+			if err := createPasswordlessUser(ctx, db, tx, user); err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to create user")
+			}
+		case !user.IsEmailVerified():
+			if err := markEmailVerified(ctx, db, tx, user.ID); err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to log in")
+			}
+			user.EmailVerifiedAt = &now
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to log in")
+		}
+
+		methods, err := mfa.methods(ctx, user.ID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read second factors")
+		}
+
+		auth := tokens.NewAuthentication(tokens.AMREmail)
+		if len(methods) > 0 {
+			return sendMFAChallenge(c, tokenService, user, methods, auth, config)
+		}
+
+		return sendAuthResponse(c, tokenService, refreshTokens, user, auth, config)
+	}
+}
+
+func sendMagicLink(ctx stdcontext.Context, db database.Database, magicLinks *magicLinkStore, config Config, email, locale string) error {
+	user, err := getUser(ctx, db, query.Eq("email", email))
+	if crud.IsNotFoundError(err) {
+		if !config.MagicLinkAutoRegister {
+			// Unknown emails are silently ignored.
+			return nil
+		}
+		user, err = &models.User{Email: email}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, code, err := magicLinks.issue(ctx, email)
+	if errors.Is(err, errMagicLinkCooldown) {
+		// The previous email is still on its way.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if config.MagicLinkURL != "" {
+		link, err = actionURL(config.MagicLinkURL, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return sendMailTo(ctx, config, mailer.TemplateMagicLink, locale, user, email, map[string]any{
+		"Link":             link,
+		"Code":             code,
+		"ExpiresInMinutes": int(magicLinks.ttl.Minutes()),
+	})
+}
+
+// createPasswordlessUser registers an account for an email address that
+// logged in with a magic link.
+func createPasswordlessUser(ctx stdcontext.Context, db database.Database, exec queryExecutor, user *models.User) error {
+	queryStr, args, err := query.New(db.Dialect()).
+		Insert("users").
+		Columns("id", "firstname", "lastname", "email", "role", "email_verified_at", "created_at").
+		Values(user.ID, user.Firstname, user.Lastname, user.Email, user.Role, user.EmailVerifiedAt, user.CreatedAt).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
+// generateLoginCode returns a random 6 digit code.
+func generateLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate login code: %w", err)
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
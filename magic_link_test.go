@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+var loginCodePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+func newTestMagicLinkApp(t *testing.T, autoRegister bool) (*fiber.App, *AuthPlugin, *mailer.MemoryMailer) {
+	t.Helper()
+
+	mail := mailer.NewMemoryMailer()
+	app, plugin, _ := newTestApp(t, map[string]interface{}{
+		"mailer":                   mail,
+		"magic_link":               true,
+		"magic_link_url":           "https://app.example.com/login",
+		"magic_link_auto_register": autoRegister,
+		"magic_link_cooldown":      0,
+	})
+	return app, plugin, mail
+}
+
+// requestMagicLink asks a login email for the address and returns the token
+// of its link and its code.
+func requestMagicLink(t *testing.T, app *fiber.App, mail *mailer.MemoryMailer, n int, email string) (string, string) {
+	t.Helper()
+
+	status, result := request(t, app, "POST", "/auth/magic-link", "", map[string]string{"email": email})
+	if status != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d %v", status, result)
+	}
+
+	message := waitForMail(t, mail, n)
+	if len(message.To) != 1 || message.To[0] != email {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+	code := loginCodePattern.FindString(message.Text)
+	if code == "" {
+		t.Fatalf("no login code in message %q", message.Text)
+	}
+	return mailToken(t, message), code
+}
+
+func TestMagicLink(t *testing.T) {
+	app, plugin, mail := newTestMagicLinkApp(t, false)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+
+	token, code := requestMagicLink(t, app, mail, 0, "jane@example.com")
+
+	status, result := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"token": token})
+	if status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+	accessToken, _ := result["token"].(string)
+	claims, err := plugin.TokenService().ValidateToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(claims.AMR, tokens.AMREmail) {
+		t.Fatalf("expected the email method, got %v", claims.AMR)
+	}
+	if refreshToken, _ := result["refresh_token"].(string); refreshToken == "" {
+		t.Fatalf("expected a refresh token, got %v", result)
+	}
+
+	// Using the link proves the address.
+	user, _ := result["user"].(map[string]interface{})
+	if user["email_verified_at"] == nil {
+		t.Fatalf("expected the email to be verified: %v", user)
+	}
+
+	// The link and the code of an email are used together.
+	tests := []struct {
+		name string
+		body map[string]string
+	}{
+		{"used token", map[string]string{"token": token}},
+		{"used code", map[string]string{"email": "jane@example.com", "code": code}},
+	}
+
+	for _, tt := range tests {
+		if status, result := request(t, app, "POST", "/auth/magic-link/verify", "", tt.body); status != fiber.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d %v", tt.name, status, result)
+		}
+	}
+
+	if status, _ := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 without a code, got %d", status)
+	}
+}
+
+func TestMagicLinkCode(t *testing.T) {
+	app, plugin, mail := newTestMagicLinkApp(t, false)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+
+	// Only the latest email works.
+	first, firstCode := requestMagicLink(t, app, mail, 0, "jane@example.com")
+	_, code := requestMagicLink(t, app, mail, 1, "jane@example.com")
+	if status, _ := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"token": first}); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected a replaced link to be rejected, got %d", status)
+	}
+	if firstCode != code {
+		if status, _ := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "jane@example.com", "code": firstCode}); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected a replaced code to be rejected, got %d", status)
+		}
+	}
+
+	status, result := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "jane@example.com", "code": code})
+	if status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+
+	// Too many wrong codes invalidate the email.
+	_, code = requestMagicLink(t, app, mail, 2, "jane@example.com")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for range magicLinkMaxAttempts {
+		if status, _ := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "jane@example.com", "code": wrong}); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected a wrong code to be rejected, got %d", status)
+		}
+	}
+	if status, _ := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "jane@example.com", "code": code}); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the code to be invalidated, got %d", status)
+	}
+}
+
+func TestMagicLinkExpiry(t *testing.T) {
+	app, plugin, _ := newTestMagicLinkApp(t, false)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+
+	token, code, err := newMagicLinkStore(plugin.db, -1, 0).issue(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []map[string]string{
+		{"token": token},
+		{"email": "jane@example.com", "code": code},
+	} {
+		if status, result := request(t, app, "POST", "/auth/magic-link/verify", "", body); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected an expired login to be rejected, got %d %v", status, result)
+		}
+	}
+}
+
+func TestMagicLinkPurge(t *testing.T) {
+	db := newTestDatabase(t)
+	logins := newMagicLinkStore(db, 3600, 0)
+	ctx := context.Background()
+
+	if _, _, err := newMagicLinkStore(db, -1, 0).issue(ctx, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := logins.issue(ctx, "jane@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := logins.issue(ctx, "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Purging deletes the expired and used logins.
+	if err := logins.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM magic_link_tokens").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Fatalf("expected 2 logins to be kept, got %d", stored)
+	}
+}
+
+// wrongLoginCode returns a code that differs from the one sent.
+func wrongLoginCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestMagicLinkAttemptsPerEmail(t *testing.T) {
+	db := newTestDatabase(t)
+	logins := newMagicLinkStore(db, 3600, 0)
+	ctx := context.Background()
+
+	_, code, err := logins.issue(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range magicLinkMaxAttempts {
+		if _, err := logins.findCode(ctx, "jane@example.com", wrongLoginCode(code)); !errors.Is(err, ErrActionTokenInvalid) {
+			t.Fatalf("expected ErrActionTokenInvalid, got %v", err)
+		}
+	}
+	if _, err := logins.findCode(ctx, "jane@example.com", code); !errors.Is(err, ErrActionTokenInvalid) {
+		t.Fatalf("expected the code to be invalidated, got %v", err)
+	}
+
+	// Requesting another email does not grant new attempts, even once stale
+	// logins are purged, but its link still works.
+	if err := logins.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	token, code, err := logins.issue(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logins.findCode(ctx, "jane@example.com", code); !errors.Is(err, ErrActionTokenInvalid) {
+		t.Fatalf("expected the attempts to carry over, got %v", err)
+	}
+	id, _, err := logins.findToken(ctx, token)
+	if err != nil {
+		t.Fatalf("expected the link to be accepted, got %v", err)
+	}
+
+	// A login clears the count.
+	if err := logins.use(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	_, code, err = logins.issue(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logins.findCode(ctx, "jane@example.com", code); err != nil {
+		t.Fatalf("expected the code to be accepted, got %v", err)
+	}
+}
+
+func TestMagicLinkConcurrentCodes(t *testing.T) {
+	db := newTestDatabase(t)
+	logins := newMagicLinkStore(db, 3600, 0)
+	ctx := context.Background()
+
+	_, code, err := logins.issue(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 * magicLinkMaxAttempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logins.findCode(ctx, "jane@example.com", wrongLoginCode(code))
+		}()
+	}
+	wg.Wait()
+
+	// Attempts are counted before the code is compared, so concurrent
+	// requests cannot try more codes.
+	var failedAttempts int
+	if err := db.QueryRow(ctx, "SELECT failed_attempts FROM magic_link_tokens WHERE email = ?", "jane@example.com").Scan(&failedAttempts); err != nil {
+		t.Fatal(err)
+	}
+	if failedAttempts != magicLinkMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", magicLinkMaxAttempts, failedAttempts)
+	}
+}
+
+func TestMagicLinkCooldown(t *testing.T) {
+	mail := mailer.NewMemoryMailer()
+	app, plugin, _ := newTestApp(t, map[string]interface{}{
+		"mailer":         mail,
+		"magic_link":     true,
+		"magic_link_url": "https://app.example.com/login",
+	})
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+	createTestUser(t, plugin.db, "john@example.com", "user")
+
+	requestMagicLink(t, app, mail, 0, "jane@example.com")
+
+	// The response does not tell whether an email was sent.
+	if status, _ := request(t, app, "POST", "/auth/magic-link", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d", status)
+	}
+	requestMagicLink(t, app, mail, 1, "john@example.com")
+
+	// Emails are sent in order, so the second one for jane would be there.
+	if messages := mail.Messages(); len(messages) != 2 || messages[1].To[0] != "john@example.com" {
+		t.Fatalf("expected the second email to be skipped, got %d messages", len(messages))
+	}
+}
+
+func TestMagicLinkIPLimit(t *testing.T) {
+	app, _, _ := newTestApp(t, map[string]interface{}{
+		"mailer":              "memory",
+		"magic_link":          true,
+		"magic_link_ip_limit": 2,
+	})
+
+	for i, expected := range []int{fiber.StatusAccepted, fiber.StatusAccepted, fiber.StatusTooManyRequests} {
+		email := fmt.Sprintf("user-%d@example.com", i)
+		if status, result := request(t, app, "POST", "/auth/magic-link", "", map[string]string{"email": email}); status != expected {
+			t.Fatalf("request %d: expected %d, got %d %v", i+1, expected, status, result)
+		}
+	}
+}
+
+func TestMagicLinkAutoRegister(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		app, plugin, _ := newTestMagicLinkApp(t, false)
+
+		token, _, err := newMagicLinkStore(plugin.db, 900, 0).issue(context.Background(), "john@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, _ := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"token": token}); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected an unknown email to be rejected, got %d", status)
+		}
+
+		var count int
+		if err := plugin.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("expected no account to be created, got %d", count)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		app, _, mail := newTestMagicLinkApp(t, true)
+
+		_, code := requestMagicLink(t, app, mail, 0, "john@example.com")
+		status, result := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "john@example.com", "code": code})
+		if status != fiber.StatusOK {
+			t.Fatalf("expected 200, got %d %v", status, result)
+		}
+		user, _ := result["user"].(map[string]interface{})
+		if user["email"] != "john@example.com" || user["role"] != "user" || user["email_verified_at"] == nil {
+			t.Fatalf("unexpected user: %v", user)
+		}
+
+		// The account is found on the next login.
+		_, code = requestMagicLink(t, app, mail, 1, "john@example.com")
+		_, next := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"email": "john@example.com", "code": code})
+		if other, _ := next["user"].(map[string]interface{}); other["id"] != user["id"] {
+			t.Fatalf("expected the same account, got %v", next)
+		}
+	})
+}
+
+func TestMagicLinkMFA(t *testing.T) {
+	app, plugin, mail := newTestMagicLinkApp(t, false)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+	accessToken, _ := login(t, app, "jane@example.com", testPassword)
+
+	_, result := request(t, app, "POST", "/auth/mfa/totp/enroll", accessToken, map[string]string{"current_password": testPassword})
+	secret, _ := result["secret"].(string)
+	if status, result := request(t, app, "POST", "/auth/mfa/totp/confirm", accessToken, map[string]string{"code": totpCode(t, secret, 0)}); status != fiber.StatusOK {
+		t.Fatalf("expected the authenticator to be confirmed, got %d %v", status, result)
+	}
+
+	token, _ := requestMagicLink(t, app, mail, 0, "jane@example.com")
+	status, result := request(t, app, "POST", "/auth/magic-link/verify", "", map[string]string{"token": token})
+	if status != fiber.StatusOK || result["mfa_required"] != true || result["token"] != nil {
+		t.Fatalf("expected a second factor challenge, got %d %v", status, result)
+	}
+}
+
+func TestInitializeMagicLink(t *testing.T) {
+	if err := NewPlugin().Initialize(map[string]interface{}{"jwt_secret": testSecret, "magic_link": true}); err == nil {
+		t.Fatal("expected magic_link without a mailer to be rejected")
+	}
+
+	app, _, _ := newTestApp(t, map[string]interface{}{"mailer": "memory"})
+	if status, _ := request(t, app, "POST", "/auth/magic-link", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusNotFound {
+		t.Fatalf("expected the magic link routes not to be registered, got %d", status)
+	}
+}
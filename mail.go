@@ -0,0 +1,48 @@
+package auth
+
+import (
+	stdcontext "context"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/models"
+)
+
+// sendMail renders a message type in the locale and sends it to the user.
+// The user is available to templates as .User, along with data.
+func sendMail(ctx stdcontext.Context, config Config, name, locale string, user *models.User, data map[string]any) error {
+	return sendMailTo(ctx, config, name, locale, user, user.Email, data)
+}
+
+// sendMailTo is sendMail for an address the user does not own yet, such as a
+// new email address to confirm.
+func sendMailTo(ctx stdcontext.Context, config Config, name, locale string, user *models.User, to string, data map[string]any) error {
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["User"] = user
+
+	message, err := config.MailTemplates.Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+
+	message.From = config.MailFrom
+	message.To = []string{to}
+
+	return config.Mailer.Send(ctx, message)
+}
+
+// requestLocale returns the preferred language of the request, taken from
+// the first Accept-Language entry. It is empty when the request has no
+// preference, so templates fall back to the configured default locale.
+func requestLocale(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAcceptLanguage)
+	first, _, _ := strings.Cut(header, ",")
+	locale, _, _ := strings.Cut(first, ";")
+	locale = strings.TrimSpace(locale)
+	if locale == "*" {
+		return ""
+	}
+	return locale
+}
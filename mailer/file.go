@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file in a directory, for local
+// development.
+type FileMailer struct {
+	dir string
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	body, err := Encode(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	id := strings.Trim(messageID(""), "<>")
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), id[:8])
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), body, 0o640); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	return nil
+}
+
+// LogMailer prints a summary and the text body of every message, for local
+// development.
+type LogMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogMailer(w io.Writer) *LogMailer {
+	if w == nil {
+		w = os.Stdout
+	}
+
+	return &LogMailer{w: w}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "[gorest-auth] mail to %s: %s\n%s\n",
+		strings.Join(message.To, ", "), message.Subject, message.Text)
+	return err
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := mailer.Send(context.Background(), Message{From: "noreply@example.com", To: []string{"jane@example.com"}, Subject: "Hello", Text: "Hello Jane"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected a file per message, got %v", files)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "To: jane@example.com") {
+		t.Fatalf("unexpected file content %q", content)
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewLogMailer(&buf).Send(context.Background(), Message{To: []string{"jane@example.com"}, Subject: "Hello", Text: "Hello Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "mail to jane@example.com: Hello") || !strings.Contains(buf.String(), "Hello Jane") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+	if _, ok := mailer.Last(); ok {
+		t.Fatal("expected no message")
+	}
+
+	for _, subject := range []string{"first", "second"} {
+		if err := mailer.Send(context.Background(), Message{Subject: subject}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last, ok := mailer.Last(); !ok || last.Subject != "second" || len(mailer.Messages()) != 2 {
+		t.Fatalf("unexpected messages %v", mailer.Messages())
+	}
+
+	mailer.Reset()
+	if len(mailer.Messages()) != 0 {
+		t.Fatal("expected the messages to be cleared")
+	}
+}
@@ -0,0 +1,18 @@
+// Package mailer sends the emails of the auth flows, such as password reset
+// links, and renders them from per-locale templates.
+package mailer
+
+import "context"
+
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
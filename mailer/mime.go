@@ -0,0 +1,96 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Encode renders the message as an RFC 5322 email. A message with both a
+// text and an HTML body is sent as multipart/alternative.
+func Encode(message Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "From", message.From)
+	writeHeader(&buf, "To", strings.Join(message.To, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID(message.From))
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	switch {
+	case message.Text != "" && message.HTML != "":
+		writer := multipart.NewWriter(&buf)
+		writeHeader(&buf, "Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+		buf.WriteString("\r\n")
+
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=utf-8", message.Text},
+			{"text/html; charset=utf-8", message.HTML},
+		} {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", part.contentType)
+			header.Set("Content-Transfer-Encoding", "quoted-printable")
+			w, err := writer.CreatePart(header)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(w, part.body); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case message.HTML != "":
+		writeHeader(&buf, "Content-Type", "text/html; charset=utf-8")
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, message.HTML); err != nil {
+			return nil, err
+		}
+	default:
+		writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, message.Text); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	// Header injection through user supplied values is not possible once
+	// line breaks are removed.
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	fmt.Fprintf(buf, "%s: %s\r\n", name, value)
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     Message
+		contentType string
+	}{
+		{"text", Message{Text: "Hello Jane"}, "text/plain"},
+		{"html", Message{HTML: "<p>Hello Jane</p>"}, "text/html"},
+		{"both", Message{Text: "Hello Jane", HTML: "<p>Hello Jane</p>"}, "multipart/alternative"},
+	}
+
+	for _, tt := range tests {
+		tt.message.From = "noreply@example.com"
+		tt.message.To = []string{"jane@example.com"}
+		tt.message.Subject = "Réinitialisez votre mot de passe"
+
+		body, err := Encode(tt.message)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		parsed, err := mail.ReadMessage(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+		if err != nil || subject != tt.message.Subject {
+			t.Fatalf("%s: unexpected subject %q", tt.name, subject)
+		}
+		if !strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>") {
+			t.Fatalf("%s: unexpected message id %q", tt.name, parsed.Header.Get("Message-ID"))
+		}
+
+		mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		if err != nil || mediaType != tt.contentType {
+			t.Fatalf("%s: unexpected content type %q", tt.name, mediaType)
+		}
+		if mediaType != "multipart/alternative" {
+			continue
+		}
+
+		reader := multipart.NewReader(parsed.Body, params["boundary"])
+		var parts []string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, part.Header.Get("Content-Type"))
+		}
+		if strings.Join(parts, ",") != "text/plain; charset=utf-8,text/html; charset=utf-8" {
+			t.Fatalf("unexpected parts %v", parts)
+		}
+	}
+}
+
+func TestEncodeHeaderInjection(t *testing.T) {
+	body, err := Encode(Message{
+		From: "noreply@example.com",
+		To:   []string{"jane@example.com\r\nBcc: attacker@example.com"},
+		Text: "Hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Bcc") != "" {
+		t.Fatal("expected line breaks to be removed from headers")
+	}
+}
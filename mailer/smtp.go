@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	// SMTPStartTLS upgrades the connection when the server supports it.
+	SMTPStartTLS = "starttls"
+	// SMTPTLS connects with implicit TLS, usually on port 465.
+	SMTPTLS = "tls"
+	// SMTPNone never encrypts the connection. Only use it for local relays.
+	SMTPNone = "none"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is used when a message has no sender.
+	From string
+	// Security is one of SMTPStartTLS (default), SMTPTLS or SMTPNone.
+	Security string
+	Timeout  time.Duration
+}
+
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	if config.Security == "" {
+		config.Security = SMTPStartTLS
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	switch config.Security {
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+	default:
+		return nil, fmt.Errorf("unknown smtp security mode: %s", config.Security)
+	}
+
+	return &SMTPMailer{config: config}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	if message.From == "" {
+		message.From = m.config.From
+	}
+
+	sender, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	body, err := Encode(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	client, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.config.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+				return fmt.Errorf("smtp starttls failed: %w", err)
+			}
+		}
+	}
+
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return fmt.Errorf("smtp MAIL FROM failed: %w", err)
+	}
+
+	for _, to := range message.To {
+		recipient, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient address: %w", err)
+		}
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return fmt.Errorf("smtp RCPT TO failed: %w", err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA failed: %w", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func (m *SMTPMailer) dial(ctx context.Context) (*smtp.Client, error) {
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	dialer := &net.Dialer{Timeout: m.config.Timeout}
+
+	var conn net.Conn
+	var err error
+	if m.config.Security == SMTPTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.config.Host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+
+	deadline := time.Now().Add(m.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smtp handshake failed: %w", err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,161 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpServer is a minimal SMTP server recording the first transaction.
+type smtpServer struct {
+	listener net.Listener
+
+	mu   sync.Mutex
+	auth string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &smtpServer{listener: listener, done: make(chan struct{})}
+	go server.serve()
+	return server
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer close(s.done)
+
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			_ = text.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = argument
+			_ = text.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.from = argument
+			_ = text.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = append(s.to, argument)
+			_ = text.PrintfLine("250 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				s.mu.Unlock()
+				return
+			}
+			s.data = strings.Join(lines, "\n")
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			_ = text.PrintfLine("500 unknown command")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	server := newSMTPServer(t)
+	mailer, err := NewSMTPMailer(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "jane",
+		Password: "secret",
+		From:     "Example <noreply@example.com>",
+		Security: SMTPNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), Message{
+		To:      []string{"jane@example.com", "John <john@example.com>"},
+		Subject: "Reset your password",
+		Text:    "Hello Jane",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(server.auth, "PLAIN "))
+	if err != nil || string(credentials) != "\x00jane\x00secret" {
+		t.Fatalf("unexpected credentials %q", server.auth)
+	}
+	if server.from != "FROM:<noreply@example.com>" {
+		t.Fatalf("unexpected sender %q", server.from)
+	}
+	if strings.Join(server.to, ",") != "TO:<jane@example.com>,TO:<john@example.com>" {
+		t.Fatalf("unexpected recipients %v", server.to)
+	}
+	if !strings.Contains(server.data, "Subject: Reset your password") || !strings.Contains(server.data, "Hello Jane") {
+		t.Fatalf("unexpected data %q", server.data)
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SMTPConfig
+	}{
+		{"missing host", SMTPConfig{}},
+		{"unknown security", SMTPConfig{Host: "smtp.example.com", Security: "ssl"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewSMTPMailer(tt.config); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+
+	mailer, err := NewSMTPMailer(SMTPConfig{Host: "smtp.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mailer.config.Port != 587 || mailer.config.Security != SMTPStartTLS || mailer.config.Timeout == 0 {
+		t.Fatalf("unexpected defaults: %+v", mailer.config)
+	}
+
+	if err := mailer.Send(context.Background(), Message{From: "not an address"}); err == nil {
+		t.Fatal("expected an invalid sender to be rejected")
+	}
+}
@@ -0,0 +1,236 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// Message types sent by the plugin.
+const (
+	TemplatePasswordReset      = "password_reset"
+	TemplateEmailVerification  = "email_verification"
+	TemplateEmailChangeConfirm = "email_change_confirm"
+	TemplateEmailChangeNotice  = "email_change_notice"
+	TemplateMagicLink          = "magic_link"
+)
+
+const DefaultLocale = "en"
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// TemplateSource holds the template sources of a message type for a
+// locale. Subject and Text use text/template, HTML uses html/template. At
+// least one of Text and HTML is required.
+type TemplateSource struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type messageTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Templates renders messages by type and locale. A missing locale falls back
+// to its base language ("fr-CA" to "fr"), then to the default locale.
+type Templates struct {
+	mu            sync.RWMutex
+	defaultLocale string
+	templates     map[string]*messageTemplate
+}
+
+// NewTemplates returns the built-in English templates.
+func NewTemplates() *Templates {
+	t := &Templates{
+		defaultLocale: DefaultLocale,
+		templates:     make(map[string]*messageTemplate),
+	}
+
+	defaults, err := fs.Sub(defaultTemplates, "templates")
+	if err == nil {
+		err = t.LoadFS(defaults)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("mailer: invalid built-in templates: %v", err))
+	}
+
+	return t
+}
+
+func (t *Templates) SetDefaultLocale(locale string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.defaultLocale = normalizeLocale(locale)
+}
+
+// Register adds or replaces the template of a message type for a locale.
+func (t *Templates) Register(name, locale string, source TemplateSource) error {
+	if source.Subject == "" {
+		return fmt.Errorf("template %s/%s: subject is required", locale, name)
+	}
+	if source.Text == "" && source.HTML == "" {
+		return fmt.Errorf("template %s/%s: a text or html body is required", locale, name)
+	}
+
+	tmpl := &messageTemplate{}
+	var err error
+
+	if tmpl.subject, err = texttemplate.New("subject").Parse(source.Subject); err != nil {
+		return fmt.Errorf("template %s/%s: %w", locale, name, err)
+	}
+	if source.Text != "" {
+		if tmpl.text, err = texttemplate.New("text").Parse(source.Text); err != nil {
+			return fmt.Errorf("template %s/%s: %w", locale, name, err)
+		}
+	}
+	if source.HTML != "" {
+		if tmpl.html, err = htmltemplate.New("html").Parse(source.HTML); err != nil {
+			return fmt.Errorf("template %s/%s: %w", locale, name, err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.templates[templateKey(name, normalizeLocale(locale))] = tmpl
+	return nil
+}
+
+// LoadDir registers the templates found in dir. See LoadFS for the layout.
+func (t *Templates) LoadDir(dir string) error {
+	return t.LoadFS(os.DirFS(dir))
+}
+
+// LoadFS registers templates laid out as <locale>/<type>.subject.txt,
+// <locale>/<type>.txt and <locale>/<type>.html.
+func (t *Templates) LoadFS(fsys fs.FS) error {
+	sources := make(map[[2]string]*TemplateSource)
+
+	err := fs.WalkDir(fsys, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		locale := path.Dir(file)
+		if locale == "." || strings.Contains(locale, "/") {
+			return nil
+		}
+
+		base := path.Base(file)
+		var name string
+		var field func(*TemplateSource) *string
+		switch {
+		case strings.HasSuffix(base, ".subject.txt"):
+			name = strings.TrimSuffix(base, ".subject.txt")
+			field = func(s *TemplateSource) *string { return &s.Subject }
+		case strings.HasSuffix(base, ".txt"):
+			name = strings.TrimSuffix(base, ".txt")
+			field = func(s *TemplateSource) *string { return &s.Text }
+		case strings.HasSuffix(base, ".html"):
+			name = strings.TrimSuffix(base, ".html")
+			field = func(s *TemplateSource) *string { return &s.HTML }
+		default:
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		key := [2]string{name, locale}
+		if sources[key] == nil {
+			sources[key] = &TemplateSource{}
+		}
+		*field(sources[key]) = string(content)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load templates: %w", err)
+	}
+
+	for key, source := range sources {
+		source.Subject = strings.TrimSpace(source.Subject)
+		if err := t.Register(key[0], key[1], *source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Render builds the message of the given type in the closest available
+// locale. Recipients and sender are left to the caller.
+func (t *Templates) Render(name, locale string, data any) (Message, error) {
+	tmpl, err := t.lookup(name, locale)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var message Message
+	var buf bytes.Buffer
+
+	if err := tmpl.subject.Execute(&buf, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	message.Subject = strings.TrimSpace(buf.String())
+
+	if tmpl.text != nil {
+		buf.Reset()
+		if err := tmpl.text.Execute(&buf, data); err != nil {
+			return Message{}, fmt.Errorf("failed to render %s text body: %w", name, err)
+		}
+		message.Text = buf.String()
+	}
+
+	if tmpl.html != nil {
+		buf.Reset()
+		if err := tmpl.html.Execute(&buf, data); err != nil {
+			return Message{}, fmt.Errorf("failed to render %s html body: %w", name, err)
+		}
+		message.HTML = buf.String()
+	}
+
+	return message, nil
+}
+
+func (t *Templates) lookup(name, locale string) (*messageTemplate, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if base, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, t.defaultLocale, DefaultLocale)
+
+	for _, candidate := range candidates {
+		if tmpl, ok := t.templates[templateKey(name, candidate)]; ok {
+			return tmpl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no template for message type %s", name)
+}
+
+func templateKey(name, locale string) string {
+	return locale + "/" + name
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
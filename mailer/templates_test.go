@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type testUser struct {
+	Firstname string
+	Email     string
+}
+
+func TestBuiltInTemplates(t *testing.T) {
+	templates := NewTemplates()
+	data := map[string]any{
+		"User":             testUser{Firstname: "Jane", Email: "jane@example.com"},
+		"Link":             "https://app.example.com/action?token=abc",
+		"Code":             "123456",
+		"NewEmail":         "janet@example.com",
+		"ExpiresInMinutes": 60,
+		"ExpiresInHours":   24,
+		"ExpiresInDays":    7,
+	}
+
+	for _, name := range []string{
+		TemplatePasswordReset,
+		TemplateEmailVerification,
+		TemplateEmailChangeConfirm,
+		TemplateEmailChangeNotice,
+		TemplateMagicLink,
+	} {
+		message, err := templates.Render(name, DefaultLocale, data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if message.Subject == "" || strings.Contains(message.Subject, "\n") {
+			t.Fatalf("%s: unexpected subject %q", name, message.Subject)
+		}
+		if !strings.Contains(message.Text, "Jane") || !strings.Contains(message.HTML, "Jane") {
+			t.Fatalf("%s: expected both bodies to be rendered", name)
+		}
+		if strings.Contains(message.Text, "<no value>") {
+			t.Fatalf("%s: missing template data in %q", name, message.Text)
+		}
+	}
+
+	if _, err := templates.Render("unknown", DefaultLocale, data); err == nil {
+		t.Fatal("expected an unknown message type to be rejected")
+	}
+}
+
+func TestTemplateLocales(t *testing.T) {
+	templates := NewTemplates()
+	err := templates.LoadFS(fstest.MapFS{
+		"fr/password_reset.subject.txt": {Data: []byte("Réinitialisez votre mot de passe\n")},
+		"fr/password_reset.txt":         {Data: []byte("Bonjour {{.User.Firstname}}")},
+		"de/password_reset.subject.txt": {Data: []byte("Passwort zurücksetzen")},
+		"de/password_reset.html":        {Data: []byte("<p>Hallo {{.User.Firstname}}</p>")},
+		"README.md":                     {Data: []byte("ignored")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]any{"User": testUser{Firstname: "Jane"}}
+
+	tests := []struct {
+		locale  string
+		subject string
+	}{
+		{"fr", "Réinitialisez votre mot de passe"},
+		{"fr-CA", "Réinitialisez votre mot de passe"},
+		{"FR_ca", "Réinitialisez votre mot de passe"},
+		{"de", "Passwort zurücksetzen"},
+		{"es", "Reset your password"},
+	}
+
+	for _, tt := range tests {
+		message, err := templates.Render(TemplatePasswordReset, tt.locale, data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.locale, err)
+		}
+		if message.Subject != tt.subject {
+			t.Fatalf("%s: expected %q, got %q", tt.locale, tt.subject, message.Subject)
+		}
+	}
+
+	// Other message types keep the built-in templates.
+	if message, err := templates.Render(TemplateMagicLink, "fr", map[string]any{"Code": "123456"}); err != nil || !strings.Contains(message.Subject, "123456") {
+		t.Fatalf("expected the built-in template, got %q %v", message.Subject, err)
+	}
+
+	templates.SetDefaultLocale("fr")
+	if message, _ := templates.Render(TemplatePasswordReset, "es", data); message.Subject != "Réinitialisez votre mot de passe" {
+		t.Fatalf("expected the default locale, got %q", message.Subject)
+	}
+}
+
+func TestRegisterTemplate(t *testing.T) {
+	templates := NewTemplates()
+
+	tests := []struct {
+		name   string
+		source TemplateSource
+	}{
+		{"missing subject", TemplateSource{Text: "body"}},
+		{"missing body", TemplateSource{Subject: "subject"}},
+		{"invalid subject", TemplateSource{Subject: "{{.Broken", Text: "body"}},
+		{"invalid html", TemplateSource{Subject: "subject", HTML: "{{end}}"}},
+	}
+
+	for _, tt := range tests {
+		if err := templates.Register(TemplatePasswordReset, "en", tt.source); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+
+	err := templates.Register(TemplatePasswordReset, "en", TemplateSource{
+		Subject: "Reset for {{.Name}}",
+		HTML:    "<a href=\"{{.Link}}\">{{.Name}}</a>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := templates.Render(TemplatePasswordReset, "en", map[string]any{
+		"Name": "<script>",
+		"Link": "javascript:alert(1)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "" || strings.Contains(message.HTML, "<script>") || strings.Contains(message.HTML, "javascript:") {
+		t.Fatalf("expected the HTML body to be escaped, got %q", message.HTML)
+	}
+	if message.Subject != "Reset for <script>" {
+		t.Fatalf("unexpected subject %q", message.Subject)
+	}
+}
@@ -0,0 +1,971 @@
+package auth
+
+import (
+	stdcontext "context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest-auth/totp"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	MFAMethodTOTP = "totp"
+
+	// totpSkew accepts the codes of the previous and next periods.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// A second factor is locked for mfaLockout after mfaMaxAttempts wrong
+	// codes in a row.
+	mfaMaxAttempts = 5
+	mfaLockout     = 5 * time.Minute
+
+	// Users without a password enroll an authenticator within
+	// mfaEnrollmentMaxAge of logging in.
+	mfaEnrollmentMaxAge = 10 * time.Minute
+
+	sealedSecretPrefix = "sealed:"
+)
+
+var (
+	ErrMFANotEnrolled     = errors.New("no second factor enrolled")
+	ErrMFAAlreadyEnrolled = errors.New("an authenticator is already enrolled")
+	ErrMFACodeInvalid     = errors.New("invalid authentication code")
+	ErrMFALocked          = errors.New("too many invalid authentication codes, try again later")
+)
+
+// EnrollTOTPRequest carries the current password, which users without a
+// password leave empty.
+type EnrollTOTPRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	// OTPAuthURI is the payload of the QR code scanned by authenticator apps.
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// VerifyMFARequest carries either a code from the authenticator app or one
+// of the recovery codes.
+type VerifyMFARequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type DisableTOTPRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recovery_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAChallengeResponse is returned by login instead of the tokens when the
+// user has a second factor. The mfa_token completes the login at
+// POST /auth/mfa/verify.
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+}
+
+// mfaStore keeps the TOTP authenticators and recovery codes of users. Only
+// SHA-256 digests of recovery codes are stored.
+type mfaStore struct {
+	db       database.Database
+	secrets  *secretSealer
+	passkeys *webAuthnStore
+}
+
+// newMFAStore builds the store. passkeys is nil when WebAuthn is disabled.
+func newMFAStore(db database.Database, encryptionKey string, passkeys *webAuthnStore) (*mfaStore, error) {
+	secrets, err := newSecretSealer(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mfaStore{db: db, secrets: secrets, passkeys: passkeys}, nil
+}
+
+// methods returns the second factors the user has enrolled.
+func (s *mfaStore) methods(ctx stdcontext.Context, userID uuid.UUID) ([]string, error) {
+	var methods []string
+
+	credential, err := s.findTOTP(ctx, s.db, userID)
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		return nil, err
+	}
+	if credential != nil && credential.ConfirmedAt != nil {
+		methods = append(methods, MFAMethodTOTP)
+	}
+
+	if s.passkeys != nil {
+		credentials, err := s.passkeys.credentials(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(credentials) > 0 {
+			methods = append(methods, MFAMethodWebAuthn)
+		}
+	}
+
+	return methods, nil
+}
+
+// enroll starts enrolling a new authenticator and returns its secret. An
+// enrollment that was never confirmed is replaced.
+func (s *mfaStore) enroll(ctx stdcontext.Context, userID uuid.UUID) (string, error) {
+	credential, err := s.findTOTP(ctx, s.db, userID)
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		return "", err
+	}
+	if credential != nil && credential.ConfirmedAt != nil {
+		return "", ErrMFAAlreadyEnrolled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := s.secrets.seal(secret)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.deleteTOTP(ctx, s.db, userID); err != nil {
+		return "", err
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Insert("totp_credentials").
+		Columns("user_id", "secret", "created_at").
+		Values(userID, sealed, time.Now()).
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return "", fmt.Errorf("failed to store authenticator: %w", err)
+	}
+
+	return secret, nil
+}
+
+// confirm completes the enrollment with a first code from the authenticator
+// and returns a fresh set of recovery codes.
+func (s *mfaStore) confirm(ctx stdcontext.Context, userID uuid.UUID, code string) ([]string, error) {
+	credential, err := s.findTOTP(ctx, s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if credential.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	secret, err := s.secrets.open(credential.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrMFACodeInvalid
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("totp_credentials").
+		Set("confirmed_at", time.Now()).
+		Set("last_used_step", step).
+		Where(query.Eq("user_id", userID)).
+		Where(query.IsNull("confirmed_at")).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, queryStr, args...); err != nil {
+		return nil, fmt.Errorf("failed to confirm authenticator: %w", err)
+	}
+
+	codes, err := s.replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// check verifies a code from the confirmed authenticator of the user, or
+// one of their recovery codes. Each attempt is counted before the code is
+// verified, and the count is only cleared by a right code.
+func (s *mfaStore) check(ctx stdcontext.Context, userID uuid.UUID, code, recoveryCode string) error {
+	credential, err := s.findTOTP(ctx, s.db, userID)
+	if err != nil {
+		return err
+	}
+	if credential.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	now := time.Now()
+	reserved, err := s.reserveAttempt(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return ErrMFALocked
+	}
+
+	var ok bool
+	switch {
+	case code != "":
+		ok, err = s.useTOTP(ctx, credential, code)
+	case recoveryCode != "":
+		ok, err = s.useRecoveryCode(ctx, userID, recoveryCode)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return s.setFailures(ctx, userID, 0, nil)
+	}
+
+	credential, err = s.findTOTP(ctx, s.db, userID)
+	if err != nil {
+		return err
+	}
+
+	if credential.LockedUntil != nil && now.Before(*credential.LockedUntil) {
+		return ErrMFALocked
+	}
+	return ErrMFACodeInvalid
+}
+
+// regenerateRecoveryCodes replaces every recovery code of the user.
+func (s *mfaStore) regenerateRecoveryCodes(ctx stdcontext.Context, userID uuid.UUID) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := s.replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// remainingRecoveryCodes counts the recovery codes the user has not used.
+func (s *mfaStore) remainingRecoveryCodes(ctx stdcontext.Context, userID uuid.UUID) (int, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id").
+		From("mfa_recovery_codes").
+		Where(query.Eq("user_id", userID)).
+		Where(query.IsNull("used_at")).
+		Build()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, queryStr, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read recovery codes: %w", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// disable removes the authenticator and the recovery codes of the user.
+func (s *mfaStore) disable(ctx stdcontext.Context, userID uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.deleteTOTP(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := s.deleteRecoveryCodes(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// useTOTP accepts each code once: the period it matched must come after the
+// last one used.
+func (s *mfaStore) useTOTP(ctx stdcontext.Context, credential *models.TOTPCredential, code string) (bool, error) {
+	secret, err := s.secrets.open(credential.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok || step <= credential.LastUsedStep {
+		return false, nil
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("totp_credentials").
+		Set("last_used_step", step).
+		Where(query.Eq("user_id", credential.UserID)).
+		Where(query.Lt("last_used_step", step)).
+		Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := s.db.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to use authentication code: %w", err)
+	}
+
+	// Another request used the code between our read and write.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *mfaStore) useRecoveryCode(ctx stdcontext.Context, userID uuid.UUID, code string) (bool, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("mfa_recovery_codes").
+		Set("used_at", time.Now()).
+		Where(query.Eq("user_id", userID)).
+		Where(query.Eq("code_hash", hashOpaqueToken(normalizeRecoveryCode(code)))).
+		Where(query.IsNull("used_at")).
+		Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := s.db.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (s *mfaStore) setFailures(ctx stdcontext.Context, userID uuid.UUID, attempts int, lockedUntil *time.Time) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("totp_credentials").
+		Set("failed_attempts", attempts).
+		Set("locked_until", lockedUntil).
+		Where(query.Eq("user_id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to record authentication attempt: %w", err)
+	}
+
+	return nil
+}
+
+// reserveAttempt counts an attempt in the database itself, so that
+// concurrent attempts are all counted, and locks the second factor on the
+// last allowed one. It reports false, counting nothing, while the second
+// factor is locked.
+func (s *mfaStore) reserveAttempt(ctx stdcontext.Context, userID uuid.UUID, now time.Time) (bool, error) {
+	dialect := s.db.Dialect()
+
+	// locked_until is assigned first: MySQL evaluates assignments in order
+	// and would otherwise see the updated counter.
+	queryStr := "UPDATE totp_credentials SET" +
+		" locked_until = CASE WHEN failed_attempts + 1 >= " + dialect.Placeholder(1) +
+		" THEN " + dialect.Placeholder(2) + " ELSE NULL END," +
+		" failed_attempts = CASE WHEN failed_attempts + 1 >= " + dialect.Placeholder(3) +
+		" THEN 0 ELSE failed_attempts + 1 END" +
+		" WHERE user_id = " + dialect.Placeholder(4) +
+		" AND (locked_until IS NULL OR locked_until < " + dialect.Placeholder(5) + ")"
+
+	result, err := s.db.Exec(ctx, queryStr, mfaMaxAttempts, now.Add(mfaLockout), mfaMaxAttempts, userID, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to record authentication attempt: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record authentication attempt: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (s *mfaStore) replaceRecoveryCodes(ctx stdcontext.Context, exec queryExecutor, userID uuid.UUID) ([]string, error) {
+	if err := s.deleteRecoveryCodes(ctx, exec, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	now := time.Now()
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+
+		queryStr, args, err := query.New(s.db.Dialect()).
+			Insert("mfa_recovery_codes").
+			Columns("id", "user_id", "code_hash", "created_at").
+			Values(uuid.New(), userID, hashOpaqueToken(normalizeRecoveryCode(code)), now).
+			Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build query: %w", err)
+		}
+
+		if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+func (s *mfaStore) deleteRecoveryCodes(ctx stdcontext.Context, exec queryExecutor, userID uuid.UUID) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("mfa_recovery_codes").
+		Where(query.Eq("user_id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+func (s *mfaStore) deleteTOTP(ctx stdcontext.Context, exec queryExecutor, userID uuid.UUID) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("totp_credentials").
+		Where(query.Eq("user_id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to delete authenticator: %w", err)
+	}
+
+	return nil
+}
+
+func (s *mfaStore) findTOTP(ctx stdcontext.Context, exec queryExecutor, userID uuid.UUID) (*models.TOTPCredential, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("user_id", "secret", "confirmed_at", "last_used_step", "failed_attempts", "locked_until", "created_at").
+		From("totp_credentials").
+		Where(query.Eq("user_id", userID)).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var credential models.TOTPCredential
+	err = exec.QueryRow(ctx, queryStr, args...).
+		Scan(&credential.UserID, &credential.Secret, &credential.ConfirmedAt, &credential.LastUsedStep, &credential.FailedAttempts, &credential.LockedUntil, &credential.CreatedAt)
+	if crud.IsNotFoundError(err) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return &credential, nil
+}
+
+// generateRecoveryCode returns 50 random bits formatted as xxxxx-xxxxx.
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// secretSealer encrypts TOTP secrets with XChaCha20-Poly1305. Without a key
+// secrets are stored as is; secrets stored before a key was configured stay
+// readable.
+type secretSealer struct {
+	aead cipher.AEAD
+}
+
+func newSecretSealer(hexKey string) (*secretSealer, error) {
+	if hexKey == "" {
+		return &secretSealer{}, nil
+	}
+
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("mfa_encryption_key must be %d hex encoded bytes", chacha20poly1305.KeySize)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mfa_encryption_key: %w", err)
+	}
+
+	return &secretSealer{aead: aead}, nil
+}
+
+func (s *secretSealer) seal(secret string) (string, error) {
+	if s.aead == nil {
+		return secret, nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(secret)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to seal secret: %w", err)
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *secretSealer) open(stored string) (string, error) {
+	encoded, sealed := strings.CutPrefix(stored, sealedSecretPrefix)
+	if !sealed {
+		return stored, nil
+	}
+	if s.aead == nil {
+		return "", fmt.Errorf("TOTP secret is sealed but no mfa_encryption_key is configured")
+	}
+
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return "", fmt.Errorf("malformed sealed TOTP secret")
+	}
+
+	secret, err := s.aead.Open(nil, raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to open TOTP secret: %w", err)
+	}
+
+	return string(secret), nil
+}
+
+// sendMFAChallenge answers a login checked by its first factor with a short
+// lived token only accepted to provide the second one. auth records the
+// first factor, which the second one completes.
+func sendMFAChallenge(c *fiber.Ctx, tokenService TokenService, user *models.User, methods []string, auth tokens.Authentication, config Config) error {
+	token, err := tokenService.GenerateToken(c.Context(), user,
+		WithAuthentication(auth),
+		WithRestriction(tokens.RestrictionMFA),
+		WithTTL(time.Duration(config.MFAChallengeTTL)*time.Second))
+	if err != nil {
+		return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+	}
+
+	return response.SendFormatted(c, fiber.StatusOK, MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     methods,
+	})
+}
+
+// sendMFAError answers a failed second factor check. invalidStatus is the
+// status of a wrong code.
+func sendMFAError(c *fiber.Ctx, err error, invalidStatus int) error {
+	switch {
+	case errors.Is(err, ErrMFACodeInvalid):
+		return response.SendError(c, invalidStatus, err.Error())
+	case errors.Is(err, ErrMFALocked):
+		return response.SendError(c, fiber.StatusTooManyRequests, err.Error())
+	case errors.Is(err, ErrMFANotEnrolled):
+		return response.SendError(c, fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrMFAAlreadyEnrolled):
+		return response.SendError(c, fiber.StatusConflict, err.Error())
+	default:
+		return response.SendError(c, fiber.StatusInternalServerError, "failed to check second factor")
+	}
+}
+
+// handleVerifyMFA completes a login with the second factor. The challenge
+// token is revoked once used, or after too many wrong codes.
+func handleVerifyMFA(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, mfa *mfaStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, _ := authcontext.GetClaims(c)
+		if claims == nil || claims.Restriction != tokens.RestrictionMFA {
+			return response.SendError(c, fiber.StatusForbidden, "an mfa_token is required")
+		}
+
+		var req VerifyMFARequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Code == "" && req.RecoveryCode == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "code or recovery_code is required")
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(claims.UserID())
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		if err := mfa.check(ctx, userID, req.Code, req.RecoveryCode); err != nil {
+			if errors.Is(err, ErrMFALocked) {
+				if err := tokenService.Revoke(ctx, claims); err != nil {
+					return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke token")
+				}
+			}
+			return sendMFAError(c, err, fiber.StatusUnauthorized)
+		}
+
+		if err := tokenService.Revoke(ctx, claims); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke token")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+		}
+
+		auth := tokens.NewAuthentication(append(claims.AMR, tokens.AMROTP)...)
+		return sendAuthResponse(c, tokenService, refreshTokens, user, auth, config)
+	}
+}
+
+// handleStepUp asks a logged in user for their second factor again, e.g.
+// before an operation requiring a recent one. The challenge is completed
+// like the one of login, and returns tokens with a new auth_time.
+func handleStepUp(db database.Database, tokenService TokenService, mfa *mfaStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "database error")
+		}
+
+		methods, err := mfa.methods(ctx, user.ID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read second factors")
+		}
+		if len(methods) == 0 {
+			return response.SendError(c, fiber.StatusBadRequest, ErrMFANotEnrolled.Error())
+		}
+
+		claims, _ := authcontext.GetClaims(c)
+		return sendMFAChallenge(c, tokenService, user, methods, claims.Authentication(), config)
+	}
+}
+
+// mfaEnrollmentRequired reports whether the role of the user requires a
+// second factor the login did not use.
+func mfaEnrollmentRequired(user *models.User, auth tokens.Authentication, config Config) bool {
+	return config.mfaRequired(user) && auth.Level != tokens.ACRMultiFactor
+}
+
+func handleMFAStatus(mfa *mfaStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		methods, err := mfa.methods(ctx, userID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read second factors")
+		}
+
+		remaining, err := mfa.remainingRecoveryCodes(ctx, userID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read second factors")
+		}
+
+		if methods == nil {
+			methods = []string{}
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, fiber.Map{
+			"methods":                  methods,
+			"recovery_codes_remaining": remaining,
+		})
+	}
+}
+
+// handleEnrollTOTP requires the current password so that a stolen access
+// token cannot bind the account to another authenticator. Users without a
+// password need a recent login instead, or the token restricted to enrolling
+// a required second factor.
+func handleEnrollTOTP(db database.Database, mfa *mfaStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req EnrollTOTPRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+			}
+		}
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		user, err := getUserByID(c.Context(), db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "database error")
+		}
+
+		if fiberErr := confirmIdentity(c, config.PasswordHasher, user, req.CurrentPassword); fiberErr != nil {
+			return response.SendError(c, fiberErr.Code, fiberErr.Message)
+		}
+
+		secret, err := mfa.enroll(c.Context(), user.ID)
+		if err != nil {
+			return sendMFAError(c, err, fiber.StatusBadRequest)
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, TOTPEnrollmentResponse{
+			Secret:     secret,
+			OTPAuthURI: totp.ProvisioningURI(config.MFAIssuer, user.Email, secret),
+		})
+	}
+}
+
+// handleConfirmTOTP activates the authenticator and returns the recovery
+// codes. They are only shown once.
+func handleConfirmTOTP(mfa *mfaStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req MFACodeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Code == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "code is required")
+		}
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		codes, err := mfa.confirm(c.Context(), userID, req.Code)
+		if err != nil {
+			return sendMFAError(c, err, fiber.StatusBadRequest)
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// handleDisableTOTP requires both the current password and a second factor.
+func handleDisableTOTP(db database.Database, mfa *mfaStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req DisableTOTPRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Code == "" && req.RecoveryCode == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "code or recovery_code is required")
+		}
+
+		user, fiberErr := currentUserWithPassword(c, db, config.PasswordHasher, req.CurrentPassword)
+		if fiberErr != nil {
+			return response.SendError(c, fiberErr.Code, fiberErr.Message)
+		}
+
+		ctx := c.Context()
+
+		if err := mfa.check(ctx, user.ID, req.Code, req.RecoveryCode); err != nil {
+			return sendMFAError(c, err, fiber.StatusForbidden)
+		}
+
+		if err := mfa.disable(ctx, user.ID); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to disable second factor")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleRegenerateRecoveryCodes replaces the recovery codes, e.g. once most
+// of them were used.
+func handleRegenerateRecoveryCodes(mfa *mfaStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req MFACodeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Code == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "code is required")
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		if err := mfa.check(ctx, userID, req.Code, ""); err != nil {
+			return sendMFAError(c, err, fiber.StatusForbidden)
+		}
+
+		codes, err := mfa.regenerateRecoveryCodes(ctx, userID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate recovery codes")
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// recentlyAuthenticated reports whether the token is the one restricted to
+// enrolling a required second factor, issued by the login itself, or comes
+// from a login no older than mfaEnrollmentMaxAge.
+func recentlyAuthenticated(claims *tokens.Claims) bool {
+	if claims == nil {
+		return false
+	}
+	if claims.Restriction == tokens.RestrictionMFAEnrollment {
+		return true
+	}
+	return !claims.AuthTime.IsZero() && time.Since(claims.AuthTime) <= mfaEnrollmentMaxAge
+}
+
+// confirmIdentity checks the current password of users who have one. Users
+// without a password need a recent login instead.
+func confirmIdentity(c *fiber.Ctx, hasher password.Hasher, user *models.User, currentPassword string) *fiber.Error {
+	if user.Password == nil {
+		if claims, _ := authcontext.GetClaims(c); !recentlyAuthenticated(claims) {
+			return fiber.NewError(fiber.StatusUnauthorized, "a recent login is required")
+		}
+		return nil
+	}
+
+	if currentPassword == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "current_password is required")
+	}
+	if !user.CheckPassword(hasher, currentPassword) {
+		return fiber.NewError(fiber.StatusForbidden, "current password is incorrect")
+	}
+
+	return nil
+}
+
+// currentUserWithPassword loads the authenticated user after checking their
+// password.
+func currentUserWithPassword(c *fiber.Ctx, db database.Database, hasher password.Hasher, currentPassword string) (*models.User, *fiber.Error) {
+	if currentPassword == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "current_password is required")
+	}
+
+	userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "invalid user ID")
+	}
+
+	user, err := getUserByID(c.Context(), db, userID)
+	if crud.IsNotFoundError(err) {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "database error")
+	}
+
+	if !user.CheckPassword(hasher, currentPassword) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "current password is incorrect")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,389 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/middleware"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest-auth/totp"
+)
+
+// newTestMFAUser returns the store and a user with a confirmed authenticator,
+// along with its secret.
+func newTestMFAUser(t *testing.T) (*mfaStore, uuid.UUID, string) {
+	t.Helper()
+
+	db := newTestDatabase(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+
+	store, err := newMFAStore(db, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	secret, err := store.enroll(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.confirm(ctx, userID, totpCode(t, secret, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	return store, userID, secret
+}
+
+// totpCode returns the code of the time step delta steps away from now.
+func totpCode(t *testing.T, secret string, delta int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestMFARejectsReplayedCodes(t *testing.T) {
+	store, userID, secret := newTestMFAUser(t)
+	ctx := context.Background()
+
+	// The code confirming the enrollment was used already.
+	if err := store.check(ctx, userID, totpCode(t, secret, 0), ""); !errors.Is(err, ErrMFACodeInvalid) {
+		t.Fatalf("expected ErrMFACodeInvalid for the confirmation code, got %v", err)
+	}
+
+	// The code of the next period is within the skew window.
+	next := totpCode(t, secret, 1)
+	if err := store.check(ctx, userID, next, ""); err != nil {
+		t.Fatalf("expected the next code to be accepted, got %v", err)
+	}
+
+	for name, code := range map[string]string{"same code": next, "older code": totpCode(t, secret, 0)} {
+		if err := store.check(ctx, userID, code, ""); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("%s: expected ErrMFACodeInvalid, got %v", name, err)
+		}
+	}
+
+	// Codes outside of the skew window are rejected.
+	if err := store.check(ctx, userID, totpCode(t, secret, 3), ""); !errors.Is(err, ErrMFACodeInvalid) {
+		t.Fatalf("expected ErrMFACodeInvalid outside of the skew window, got %v", err)
+	}
+}
+
+func TestMFALockout(t *testing.T) {
+	store, userID, secret := newTestMFAUser(t)
+	ctx := context.Background()
+	wrong := totpCode(t, secret, 10)
+
+	for range mfaMaxAttempts - 1 {
+		if err := store.check(ctx, userID, wrong, ""); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("expected ErrMFACodeInvalid, got %v", err)
+		}
+	}
+
+	credential, err := store.findTOTP(ctx, store.db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.FailedAttempts != mfaMaxAttempts-1 || credential.LockedUntil != nil {
+		t.Fatalf("unexpected failures: %d, locked until %v", credential.FailedAttempts, credential.LockedUntil)
+	}
+
+	if err := store.check(ctx, userID, wrong, ""); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("expected ErrMFALocked on the last attempt, got %v", err)
+	}
+	if err := store.check(ctx, userID, totpCode(t, secret, 1), ""); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("expected a valid code to be rejected while locked, got %v", err)
+	}
+}
+
+func TestMFASuccessResetsFailures(t *testing.T) {
+	store, userID, secret := newTestMFAUser(t)
+	ctx := context.Background()
+
+	for range 2 {
+		if err := store.check(ctx, userID, totpCode(t, secret, 10), ""); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("expected ErrMFACodeInvalid, got %v", err)
+		}
+	}
+	if err := store.check(ctx, userID, totpCode(t, secret, 1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := store.findTOTP(ctx, store.db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.FailedAttempts != 0 {
+		t.Fatalf("expected the failures to be reset, got %d", credential.FailedAttempts)
+	}
+}
+
+func TestMFAReserveAttempt(t *testing.T) {
+	store, userID, _ := newTestMFAUser(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// Attempts are counted from the stored counter, not from the one a
+	// request read, so concurrent attempts are all counted.
+	for i := 1; i <= mfaMaxAttempts; i++ {
+		reserved, err := store.reserveAttempt(ctx, userID, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reserved {
+			t.Fatalf("refused attempt %d", i)
+		}
+	}
+
+	credential, err := store.findTOTP(ctx, store.db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.FailedAttempts != 0 || credential.LockedUntil == nil || !credential.LockedUntil.After(now) {
+		t.Fatalf("unexpected credential: %d failures, locked until %v", credential.FailedAttempts, credential.LockedUntil)
+	}
+
+	if reserved, err := store.reserveAttempt(ctx, userID, now); err != nil || reserved {
+		t.Fatalf("expected the attempt to be refused while locked, got %v %v", reserved, err)
+	}
+
+	// Attempts are counted again once the lockout is over.
+	if reserved, err := store.reserveAttempt(ctx, userID, now.Add(mfaLockout+time.Second)); err != nil || !reserved {
+		t.Fatalf("expected the attempt to be reserved after the lockout, got %v %v", reserved, err)
+	}
+	credential, err = store.findTOTP(ctx, store.db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.FailedAttempts != 1 || credential.LockedUntil != nil {
+		t.Fatalf("unexpected credential: %d failures, locked until %v", credential.FailedAttempts, credential.LockedUntil)
+	}
+}
+
+func TestMFAConcurrentAttempts(t *testing.T) {
+	store, userID, secret := newTestMFAUser(t)
+	ctx := context.Background()
+	wrong := totpCode(t, secret, 10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*mfaMaxAttempts)
+	for range 4 * mfaMaxAttempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.check(ctx, userID, wrong, "")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Only the allowed attempts get their code verified.
+	invalid := 0
+	for err := range errs {
+		switch {
+		case errors.Is(err, ErrMFACodeInvalid):
+			invalid++
+		case !errors.Is(err, ErrMFALocked):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if invalid != mfaMaxAttempts-1 {
+		t.Fatalf("expected %d invalid attempts, got %d", mfaMaxAttempts-1, invalid)
+	}
+}
+
+// enrollTOTP enrolls and confirms an authenticator for the user of the token,
+// and returns its secret and the recovery codes.
+func enrollTOTP(t *testing.T, app *fiber.App, token string) (string, []string) {
+	t.Helper()
+
+	status, result := request(t, app, "POST", "/auth/mfa/totp/enroll", token, map[string]string{"current_password": testPassword})
+	if status != fiber.StatusOK {
+		t.Fatalf("enrollment failed: %d %v", status, result)
+	}
+	secret, _ := result["secret"].(string)
+
+	status, result = request(t, app, "POST", "/auth/mfa/totp/confirm", token, map[string]string{"code": totpCode(t, secret, 0)})
+	if status != fiber.StatusOK {
+		t.Fatalf("confirmation failed: %d %v", status, result)
+	}
+
+	var recoveryCodes []string
+	codes, _ := result["recovery_codes"].([]interface{})
+	for _, code := range codes {
+		value, _ := code.(string)
+		recoveryCodes = append(recoveryCodes, value)
+	}
+	return secret, recoveryCodes
+}
+
+// verifyMFA completes a second factor challenge and returns the new access
+// token.
+func verifyMFA(t *testing.T, app *fiber.App, challenge map[string]interface{}, body map[string]string) string {
+	t.Helper()
+
+	mfaToken, _ := challenge["mfa_token"].(string)
+	if challenge["mfa_required"] != true || mfaToken == "" {
+		t.Fatalf("expected a second factor challenge, got %v", challenge)
+	}
+
+	status, result := request(t, app, "POST", "/auth/mfa/verify", mfaToken, body)
+	if status != fiber.StatusOK {
+		t.Fatalf("second factor rejected: %d %v", status, result)
+	}
+	token, _ := result["token"].(string)
+	return token
+}
+
+func TestMFARequiredRoles(t *testing.T) {
+	app, plugin, db := newTestApp(t, map[string]interface{}{"mfa_required_roles": []interface{}{"admin"}})
+	adminID := createTestUser(t, db, "admin@example.com", "admin")
+	createTestUser(t, db, "jane@example.com", "user")
+
+	// Other roles log in with their password only.
+	status, result := request(t, app, "POST", "/auth/login", "", map[string]string{"email": "jane@example.com", "password": testPassword})
+	if status != fiber.StatusOK || result["mfa_enrollment_required"] != nil {
+		t.Fatalf("unexpected login: %d %v", status, result)
+	}
+
+	// Until they enroll a second factor, admins only get a token to enroll one.
+	status, result = request(t, app, "POST", "/auth/login", "", map[string]string{"email": "admin@example.com", "password": testPassword})
+	if status != fiber.StatusOK || result["mfa_enrollment_required"] != true {
+		t.Fatalf("expected the enrollment to be required, got %d %v", status, result)
+	}
+	token, _ := result["token"].(string)
+	claims, err := plugin.TokenService().ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Restriction != tokens.RestrictionMFAEnrollment {
+		t.Fatalf("expected a restricted token, got %q", claims.Restriction)
+	}
+	if status, _ := request(t, app, "PUT", "/users/"+adminID.String(), token, map[string]string{"firstname": "Ada"}); status != fiber.StatusForbidden {
+		t.Fatalf("expected the restricted token to be rejected, got %d", status)
+	}
+
+	secret, _ := enrollTOTP(t, app, token)
+
+	// Logging in again asks for the second factor, and grants a full token.
+	_, challenge := request(t, app, "POST", "/auth/login", "", map[string]string{"email": "admin@example.com", "password": testPassword})
+	token = verifyMFA(t, app, challenge, map[string]string{"code": totpCode(t, secret, 1)})
+	claims, err = plugin.TokenService().ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Restriction != "" || claims.ACR != tokens.ACRMultiFactor || !slices.Contains(claims.AMR, tokens.AMROTP) || claims.AuthTime.IsZero() {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if status, result := request(t, app, "PUT", "/users/"+adminID.String(), token, map[string]string{"firstname": "Ada"}); status != fiber.StatusOK {
+		t.Fatalf("expected the token to be accepted, got %d %v", status, result)
+	}
+}
+
+func TestAdminStepUp(t *testing.T) {
+	app, _, db := newTestApp(t, map[string]interface{}{"admin_step_up_max_age": 300})
+	createTestUser(t, db, "admin@example.com", "admin")
+	janeID := createTestUser(t, db, "jane@example.com", "user")
+	token, _ := login(t, app, "admin@example.com", testPassword)
+
+	// A password login does not allow to change roles or delete users.
+	status, result := request(t, app, "PUT", "/users/"+janeID.String(), token, map[string]string{"role": "admin"})
+	if status != fiber.StatusUnauthorized || result["code"] != middleware.ErrorInsufficientUserAuthentication {
+		t.Fatalf("expected a step-up challenge, got %d %v", status, result)
+	}
+	if status, result := request(t, app, "DELETE", "/users/"+janeID.String(), token, nil); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected a step-up challenge, got %d %v", status, result)
+	}
+
+	if status, result := request(t, app, "DELETE", "/users/"+uuid.New().String(), token, nil); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected a step-up challenge before the lookup, got %d %v", status, result)
+	}
+
+	// Users who may not make the change are denied rather than challenged,
+	// and do not learn whether the user exists.
+	janeToken, _ := login(t, app, "jane@example.com", testPassword)
+	if status, result := request(t, app, "PUT", "/users/"+janeID.String(), janeToken, map[string]string{"role": "admin"}); status != fiber.StatusForbidden {
+		t.Fatalf("expected 403, got %d %v", status, result)
+	}
+	for _, id := range []uuid.UUID{janeID, uuid.New()} {
+		if status, result := request(t, app, "DELETE", "/users/"+id.String(), janeToken, nil); status != fiber.StatusForbidden {
+			t.Fatalf("expected 403, got %d %v", status, result)
+		}
+	}
+
+	// Other changes do not need a second factor.
+	if status, result := request(t, app, "PUT", "/users/"+janeID.String(), token, map[string]string{"firstname": "Janet"}); status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+
+	if status, _ := request(t, app, "POST", "/auth/step-up", token, nil); status != fiber.StatusBadRequest {
+		t.Fatalf("expected the step-up to require a second factor, got %d", status)
+	}
+
+	_, recoveryCodes := enrollTOTP(t, app, token)
+	_, challenge := request(t, app, "POST", "/auth/step-up", token, nil)
+	token = verifyMFA(t, app, challenge, map[string]string{"recovery_code": recoveryCodes[0]})
+
+	if status, result := request(t, app, "PUT", "/users/"+janeID.String(), token, map[string]string{"role": "admin"}); status != fiber.StatusOK {
+		t.Fatalf("expected the role change to be allowed, got %d %v", status, result)
+	}
+	if status, result := request(t, app, "DELETE", "/users/"+janeID.String(), token, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected the deletion to be allowed, got %d %v", status, result)
+	}
+}
+
+func TestEnrollTOTPWithoutPassword(t *testing.T) {
+	app, plugin, db := newTestApp(t, nil)
+	user := createTestUserWithoutPassword(t, db, "jane@example.com")
+	ctx := context.Background()
+
+	stale := tokens.NewAuthentication(tokens.AMREmail)
+	stale.Time = time.Now().Add(-mfaEnrollmentMaxAge - time.Minute)
+
+	tests := []struct {
+		name     string
+		opts     []TokenOption
+		expected int
+	}{
+		{"unknown login", nil, fiber.StatusUnauthorized},
+		{"stale login", []TokenOption{WithAuthentication(stale)}, fiber.StatusUnauthorized},
+		{"enrollment token", []TokenOption{WithAuthentication(stale), WithRestriction(tokens.RestrictionMFAEnrollment)}, fiber.StatusOK},
+		{"recent login", []TokenOption{WithAuthentication(tokens.NewAuthentication(tokens.AMREmail))}, fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		token, err := plugin.TokenService().GenerateToken(ctx, user, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, result := request(t, app, "POST", "/auth/mfa/totp/enroll", token, nil); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+}
+
+func TestInitializeMFARequiredRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"unknown role", map[string]interface{}{"mfa_required_roles": []interface{}{"owner"}}},
+		{"negative step-up age", map[string]interface{}{"admin_step_up_max_age": -1}},
+	}
+
+	for _, tt := range tests {
+		tt.config["jwt_secret"] = testSecret
+		if err := NewPlugin().Initialize(tt.config); err == nil {
+			t.Fatalf("%s: expected Initialize to fail", tt.name)
+		}
+	}
+}
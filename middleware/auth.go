@@ -1,19 +1,110 @@
 package middleware
 
 import (
+	stdcontext "context"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/crud"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/rbac"
 )
 
 type JWTValidator interface {
-	ValidateToken(tokenString string) (string, error)
+	ValidateToken(tokenString string) (*tokens.Claims, error)
 }
 
-func AuthMiddleware(jwt JWTValidator, db database.Database) fiber.Handler {
+// RevocationChecker is implemented by validators that can tell whether an
+// otherwise valid token has been revoked before its expiry.
+type RevocationChecker interface {
+	IsRevoked(ctx stdcontext.Context, claims *tokens.Claims) (bool, error)
+}
+
+// Option customizes how the auth middleware resolves the roles of a user.
+type Option func(*options)
+
+type options struct {
+	tokenRoles   bool
+	roleCache    *RoleCache
+	restrictions map[string]bool
+}
+
+// WithTokenRoles trusts the roles signed into the access token instead of
+// querying them. Role changes only apply to tokens issued afterwards. Tokens
+// without a roles claim still fall back to the database.
+func WithTokenRoles() Option {
+	return func(o *options) {
+		o.tokenRoles = true
+	}
+}
+
+// WithRoleCache caches the roles fetched from the database.
+func WithRoleCache(cache *RoleCache) Option {
+	return func(o *options) {
+		o.roleCache = cache
+	}
+}
+
+// AllowRestrictions accepts restricted tokens, such as the ones issued
+// before the email address of the user is verified. Restricted tokens are
+// rejected by default.
+func AllowRestrictions(restrictions ...string) Option {
+	return func(o *options) {
+		if o.restrictions == nil {
+			o.restrictions = make(map[string]bool)
+		}
+		for _, restriction := range restrictions {
+			o.restrictions[restriction] = true
+		}
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) allows(claims *tokens.Claims) bool {
+	return claims.Restriction == "" || o.restrictions[claims.Restriction]
+}
+
+func (o *options) roles(ctx stdcontext.Context, db database.Database, claims *tokens.Claims) ([]string, error) {
+	if o.tokenRoles && claims.Roles != nil {
+		return claims.Roles, nil
+	}
+
+	userID := claims.UserID()
+	if o.roleCache != nil {
+		if roles, ok := o.roleCache.Get(userID); ok {
+			return roles, nil
+		}
+	}
+
+	var role string
+	err := db.QueryRow(ctx,
+		"SELECT role FROM users WHERE id = "+db.Dialect().Placeholder(1),
+		userID,
+	).Scan(&role)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{role}
+	if o.roleCache != nil {
+		o.roleCache.Set(userID, roles)
+	}
+
+	return roles, nil
+}
+
+func AuthMiddleware(jwt JWTValidator, db database.Database, opts ...Option) fiber.Handler {
+	o := newOptions(opts)
+
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -30,33 +121,61 @@ func AuthMiddleware(jwt JWTValidator, db database.Database) fiber.Handler {
 		}
 
 		tokenString := parts[1]
-		userID, err := jwt.ValidateToken(tokenString)
+		claims, err := jwt.ValidateToken(tokenString)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "invalid or expired token",
 			})
 		}
+		userID := claims.UserID()
 
-		var role string
-		err = db.QueryRow(c.Context(),
-			"SELECT role FROM users WHERE id = "+db.Dialect().Placeholder(1),
-			userID,
-		).Scan(&role)
+		if checker, ok := jwt.(RevocationChecker); ok {
+			revoked, err := checker.IsRevoked(c.Context(), claims)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "failed to check token revocation",
+				})
+			}
+			if revoked {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "invalid or expired token",
+				})
+			}
+		}
+
+		if !o.allows(claims) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":       "token is restricted",
+				"restriction": claims.Restriction,
+			})
+		}
+
+		roles, err := o.roles(c.Context(), db, claims)
+		if crud.IsNotFoundError(err) {
+			// The user was deleted after the token was issued.
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "user not found",
+			})
+		}
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "failed to fetch user role",
 			})
 		}
 
-		c.SetUserContext(rbac.WithUser(c.Context(), userID, []string{role}))
+		c.SetUserContext(rbac.WithUser(c.Context(), userID, roles))
 
 		context.SetUserID(c, userID)
+		context.SetToken(c, tokenString)
+		context.SetClaims(c, claims)
 
 		return c.Next()
 	}
 }
 
-func OptionalAuthMiddleware(jwt JWTValidator, db database.Database) fiber.Handler {
+func OptionalAuthMiddleware(jwt JWTValidator, db database.Database, opts ...Option) fiber.Handler {
+	o := newOptions(opts)
+
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -69,16 +188,29 @@ func OptionalAuthMiddleware(jwt JWTValidator, db database.Database) fiber.Handle
 		}
 
 		tokenString := parts[1]
-		userID, err := jwt.ValidateToken(tokenString)
+		claims, err := jwt.ValidateToken(tokenString)
 		if err != nil {
 			return c.Next()
 		}
+		userID := claims.UserID()
+
+		if checker, ok := jwt.(RevocationChecker); ok {
+			revoked, err := checker.IsRevoked(c.Context(), claims)
+			if err != nil || revoked {
+				return c.Next()
+			}
+		}
+
+		if !o.allows(claims) {
+			return c.Next()
+		}
 
-		var role string
-		err = db.QueryRow(c.Context(), "SELECT role FROM users WHERE id = "+db.Dialect().Placeholder(1), userID).Scan(&role)
+		roles, err := o.roles(c.Context(), db, claims)
 		if err == nil {
-			c.SetUserContext(rbac.WithUser(c.Context(), userID, []string{role}))
+			c.SetUserContext(rbac.WithUser(c.Context(), userID, roles))
 			context.SetUserID(c, userID)
+			context.SetToken(c, tokenString)
+			context.SetClaims(c, claims)
 		}
 
 		return c.Next()
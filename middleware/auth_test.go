@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/database"
+	_ "github.com/nicolasbonnici/gorest/database/sqlite"
+	"github.com/nicolasbonnici/gorest/rbac"
+)
+
+type stubValidator struct {
+	claims  map[string]*tokens.Claims
+	revoked map[string]bool
+}
+
+func (v *stubValidator) ValidateToken(tokenString string) (*tokens.Claims, error) {
+	claims, ok := v.claims[tokenString]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func (v *stubValidator) IsRevoked(_ stdcontext.Context, claims *tokens.Claims) (bool, error) {
+	return v.revoked[claims.ID], nil
+}
+
+// countingDatabase counts the role queries of the middleware.
+type countingDatabase struct {
+	database.Database
+	queries atomic.Int32
+}
+
+func (d *countingDatabase) QueryRow(ctx stdcontext.Context, query string, args ...interface{}) database.Row {
+	d.queries.Add(1)
+	return d.Database.QueryRow(ctx, query, args...)
+}
+
+func newTestDatabase(t *testing.T) *countingDatabase {
+	t.Helper()
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := database.Open("sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, statement := range []string{
+		"CREATE TABLE users (id TEXT PRIMARY KEY, role TEXT NOT NULL)",
+		"INSERT INTO users (id, role) VALUES ('jane', 'user')",
+	} {
+		if _, err := db.Exec(stdcontext.Background(), statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &countingDatabase{Database: db}
+}
+
+func newTestApp(validator JWTValidator, db database.Database, opts ...Option) *fiber.App {
+	app := fiber.New()
+	app.Get("/", AuthMiddleware(validator, db, opts...), func(c *fiber.Ctx) error {
+		roles, _ := rbac.GetRoles(c.UserContext())
+		return c.SendString(strings.Join(roles, ","))
+	})
+	return app
+}
+
+func get(t *testing.T, app *fiber.App, token string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestAuthMiddlewareTokenRoles(t *testing.T) {
+	validator := &stubValidator{claims: map[string]*tokens.Claims{
+		"embedded": {ID: "1", Subject: "jane", Roles: []string{"admin"}},
+		"legacy":   {ID: "2", Subject: "jane"},
+	}}
+
+	t.Run("trusted", func(t *testing.T) {
+		db := newTestDatabase(t)
+		app := newTestApp(validator, db, WithTokenRoles())
+
+		if status, roles := get(t, app, "embedded"); status != fiber.StatusOK || roles != "admin" {
+			t.Fatalf("unexpected response: %d %q", status, roles)
+		}
+		if queries := db.queries.Load(); queries != 0 {
+			t.Fatalf("expected no role query, got %d", queries)
+		}
+
+		// Tokens issued without the roles claim fall back to the database.
+		if status, roles := get(t, app, "legacy"); status != fiber.StatusOK || roles != "user" {
+			t.Fatalf("unexpected response: %d %q", status, roles)
+		}
+		if queries := db.queries.Load(); queries != 1 {
+			t.Fatalf("expected a role query, got %d", queries)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		db := newTestDatabase(t)
+		app := newTestApp(validator, db)
+
+		if status, roles := get(t, app, "embedded"); status != fiber.StatusOK || roles != "user" {
+			t.Fatalf("expected the stored role, got %d %q", status, roles)
+		}
+	})
+}
+
+func TestAuthMiddlewareRoleCache(t *testing.T) {
+	validator := &stubValidator{claims: map[string]*tokens.Claims{
+		"jane":    {ID: "1", Subject: "jane"},
+		"deleted": {ID: "2", Subject: "john"},
+	}}
+	db := newTestDatabase(t)
+	cache := NewRoleCache(time.Minute)
+	app := newTestApp(validator, db, WithRoleCache(cache))
+
+	for range 3 {
+		if status, roles := get(t, app, "jane"); status != fiber.StatusOK || roles != "user" {
+			t.Fatalf("unexpected response: %d %q", status, roles)
+		}
+	}
+	if queries := db.queries.Load(); queries != 1 {
+		t.Fatalf("expected a single role query, got %d", queries)
+	}
+
+	if _, err := db.Exec(stdcontext.Background(), "UPDATE users SET role = 'admin' WHERE id = 'jane'"); err != nil {
+		t.Fatal(err)
+	}
+	cache.Invalidate("jane")
+	if status, roles := get(t, app, "jane"); status != fiber.StatusOK || roles != "admin" {
+		t.Fatalf("expected the updated role, got %d %q", status, roles)
+	}
+
+	if status, _ := get(t, app, "deleted"); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 for a deleted user, got %d", status)
+	}
+}
+
+func TestRoleCacheExpiry(t *testing.T) {
+	cache := NewRoleCache(-time.Second)
+	cache.Set("jane", []string{"user"})
+	if _, ok := cache.Get("jane"); ok {
+		t.Fatal("expected an expired entry to be ignored")
+	}
+
+	cache = NewRoleCache(time.Minute)
+	cache.Set("jane", []string{"user"})
+	cache.Clear()
+	if _, ok := cache.Get("jane"); ok {
+		t.Fatal("expected a cleared entry to be ignored")
+	}
+}
+
+func TestRoleCacheManyEntries(t *testing.T) {
+	cache := NewRoleCache(-time.Second)
+	for i := 0; i < 10*roleCacheSweepInterval; i++ {
+		cache.Set(fmt.Sprintf("user-%d", i), []string{"user"})
+	}
+	if len(cache.entries) > roleCacheSweepInterval {
+		t.Fatalf("expected expired entries to be swept, got %d entries", len(cache.entries))
+	}
+
+	cache.Set("jane", []string{"user"})
+	if _, ok := cache.Get("jane"); ok {
+		t.Fatal("expected an expired entry to be ignored")
+	}
+	if _, ok := cache.entries["jane"]; ok {
+		t.Fatal("expected an expired entry to be dropped when read")
+	}
+
+	cache = NewRoleCache(time.Minute)
+	for i := 0; i < 10*roleCacheSweepInterval; i++ {
+		cache.Set(fmt.Sprintf("user-%d", i), []string{"user"})
+	}
+	for i := 0; i < 10*roleCacheSweepInterval; i++ {
+		if _, ok := cache.Get(fmt.Sprintf("user-%d", i)); !ok {
+			t.Fatalf("expected user-%d to be cached", i)
+		}
+	}
+}
+
+func TestAuthMiddlewareRejections(t *testing.T) {
+	validator := &stubValidator{
+		claims: map[string]*tokens.Claims{
+			"revoked":    {ID: "1", Subject: "jane"},
+			"restricted": {ID: "2", Subject: "jane", Restriction: tokens.RestrictionEmailUnverified},
+		},
+		revoked: map[string]bool{"1": true},
+	}
+	db := newTestDatabase(t)
+	app := newTestApp(validator, db)
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"missing", "", fiber.StatusUnauthorized},
+		{"invalid", "forged", fiber.StatusUnauthorized},
+		{"revoked", "revoked", fiber.StatusUnauthorized},
+		{"restricted", "restricted", fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		if status, _ := get(t, app, tt.token); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d", tt.name, tt.expected, status)
+		}
+	}
+
+	allowed := newTestApp(validator, db, AllowRestrictions(tokens.RestrictionEmailUnverified))
+	if status, _ := get(t, allowed, "restricted"); status != fiber.StatusOK {
+		t.Fatalf("expected an allowed restriction to pass, got %d", status)
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// roleCacheSweepInterval is how many insertions happen between two sweeps of
+// the expired entries of a RoleCache.
+const roleCacheSweepInterval = 1024
+
+// RoleCache keeps the roles fetched from the database for a short time so the
+// auth middleware does not query them on every request. Entries must be
+// invalidated when the roles of a user change.
+type RoleCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]roleCacheEntry
+	inserts int
+}
+
+type roleCacheEntry struct {
+	roles     []string
+	expiresAt time.Time
+}
+
+func NewRoleCache(ttl time.Duration) *RoleCache {
+	return &RoleCache{
+		ttl:     ttl,
+		entries: make(map[string]roleCacheEntry),
+	}
+}
+
+func (c *RoleCache) Get(userID string) ([]string, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[userID]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		c.mu.Lock()
+		if current, ok := c.entries[userID]; ok && time.Now().After(current.expiresAt) {
+			delete(c.entries, userID)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	return entry.roles, true
+}
+
+func (c *RoleCache) Set(userID string, roles []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// Entries are dropped when read after expiring. Those never read again
+	// are swept from time to time rather than on every insertion.
+	c.inserts++
+	if c.inserts >= roleCacheSweepInterval {
+		c.inserts = 0
+		for id, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+	}
+
+	c.entries[userID] = roleCacheEntry{
+		roles:     roles,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// Invalidate drops the cached roles of the user.
+func (c *RoleCache) Invalidate(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
+
+func (c *RoleCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]roleCacheEntry)
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+// ErrorInsufficientUserAuthentication is the error code of a step-up
+// challenge (RFC 9470).
+const ErrorInsufficientUserAuthentication = "insufficient_user_authentication"
+
+// StepUpSatisfied reports whether the token was issued after a multi-factor
+// login no older than maxAge.
+func StepUpSatisfied(claims *tokens.Claims, maxAge time.Duration) bool {
+	if claims == nil || claims.ACR != tokens.ACRMultiFactor || claims.AuthTime.IsZero() {
+		return false
+	}
+	return time.Since(claims.AuthTime) <= maxAge
+}
+
+// RequireStepUp only lets through tokens issued after a multi-factor login
+// no older than maxAge. It must run after AuthMiddleware.
+func RequireStepUp(maxAge time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, _ := context.GetClaims(c)
+		if !StepUpSatisfied(claims, maxAge) {
+			return SendStepUpRequired(c, maxAge)
+		}
+		return c.Next()
+	}
+}
+
+// SendStepUpRequired answers with a step-up challenge: clients log in again
+// with a second factor, e.g. through POST /auth/step-up, and retry.
+func SendStepUpRequired(c *fiber.Ctx, maxAge time.Duration) error {
+	seconds := int(maxAge.Seconds())
+
+	c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(
+		`Bearer error="%s", error_description="a recent second factor is required", acr_values="%s", max_age=%d`,
+		ErrorInsufficientUserAuthentication, tokens.ACRMultiFactor, seconds))
+
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error":      "step-up authentication required",
+		"code":       ErrorInsufficientUserAuthentication,
+		"acr_values": tokens.ACRMultiFactor,
+		"max_age":    seconds,
+	})
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func TestStepUpSatisfied(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		claims   *tokens.Claims
+		expected bool
+	}{
+		{"recent second factor", &tokens.Claims{ACR: tokens.ACRMultiFactor, AuthTime: now.Add(-time.Minute)}, true},
+		{"old second factor", &tokens.Claims{ACR: tokens.ACRMultiFactor, AuthTime: now.Add(-time.Hour)}, false},
+		{"single factor", &tokens.Claims{ACR: tokens.ACRSingleFactor, AuthTime: now}, false},
+		{"no auth time", &tokens.Claims{ACR: tokens.ACRMultiFactor}, false},
+		{"no claims", nil, false},
+	}
+
+	for _, tt := range tests {
+		if satisfied := StepUpSatisfied(tt.claims, 5*time.Minute); satisfied != tt.expected {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.expected, satisfied)
+		}
+	}
+}
+
+func TestRequireStepUp(t *testing.T) {
+	validator := &stubValidator{claims: map[string]*tokens.Claims{
+		"mfa":      {ID: "1", Subject: "jane", ACR: tokens.ACRMultiFactor, AuthTime: time.Now()},
+		"password": {ID: "2", Subject: "jane", ACR: tokens.ACRSingleFactor, AuthTime: time.Now()},
+	}}
+	db := newTestDatabase(t)
+
+	app := fiber.New()
+	app.Get("/", AuthMiddleware(validator, db), RequireStepUp(5*time.Minute), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	if status, _ := get(t, app, "mfa"); status != fiber.StatusNoContent {
+		t.Fatalf("expected a recent second factor to pass, got %d", status)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer password")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+	challenge := resp.Header.Get(fiber.HeaderWWWAuthenticate)
+	if !strings.Contains(challenge, `error="insufficient_user_authentication"`) || !strings.Contains(challenge, "max_age=300") {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != ErrorInsufficientUserAuthentication || body["acr_values"] != tokens.ACRMultiFactor || body["max_age"] != float64(300) {
+		t.Fatalf("unexpected body: %v", body)
+	}
+}
@@ -95,5 +95,757 @@ func GetMigrations() migrations.MigrationSource {
 		},
 	)
 
+	builder.Add(
+		"20261016000001000",
+		"create_refresh_tokens_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS refresh_tokens (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					family_id UUID NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					revoked_at TIMESTAMP(0) WITH TIME ZONE,
+					replaced_by UUID,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS refresh_tokens (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					family_id CHAR(36) NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP NOT NULL,
+					revoked_at TIMESTAMP NULL,
+					replaced_by CHAR(36) NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_refresh_token_user (user_id),
+					INDEX idx_refresh_token_family (family_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS refresh_tokens (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					family_id TEXT NOT NULL,
+					token_hash TEXT UNIQUE NOT NULL,
+					expires_at DATETIME NOT NULL,
+					revoked_at DATETIME,
+					replaced_by TEXT,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			if err := migrations.CreateIndex(ctx, db, "idx_refresh_token_user", "refresh_tokens", "user_id"); err != nil {
+				return err
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_refresh_token_family", "refresh_tokens", "family_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_refresh_token_family", "refresh_tokens")
+				_ = migrations.DropIndex(ctx, db, "idx_refresh_token_user", "refresh_tokens")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "refresh_tokens")
+		},
+	)
+
+	builder.Add(
+		"20261016000002000",
+		"create_token_revocation_tables",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS revoked_tokens (
+					jti VARCHAR(64) PRIMARY KEY,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS revoked_tokens (
+					jti VARCHAR(64) PRIMARY KEY,
+					expires_at TIMESTAMP NOT NULL,
+					INDEX idx_revoked_token_expires (expires_at)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS revoked_tokens (
+					jti TEXT PRIMARY KEY,
+					expires_at DATETIME NOT NULL
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() != "mysql" {
+				if err := migrations.CreateIndex(ctx, db, "idx_revoked_token_expires", "revoked_tokens", "expires_at"); err != nil {
+					return err
+				}
+			}
+
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS user_token_revocations (
+					user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					revoked_before TIMESTAMP(3) WITH TIME ZONE NOT NULL
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS user_token_revocations (
+					user_id CHAR(36) PRIMARY KEY,
+					revoked_before TIMESTAMP(3) NOT NULL,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS user_token_revocations (
+					user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					revoked_before DATETIME NOT NULL
+				)`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.DropTableIfExists(ctx, db, "user_token_revocations"); err != nil {
+				return err
+			}
+
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_revoked_token_expires", "revoked_tokens")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "revoked_tokens")
+		},
+	)
+
+	builder.Add(
+		"20261016000003000",
+		"create_password_reset_tokens_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS password_reset_tokens (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					used_at TIMESTAMP(0) WITH TIME ZONE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS password_reset_tokens (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP NOT NULL,
+					used_at TIMESTAMP NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_password_reset_token_user (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS password_reset_tokens (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash TEXT UNIQUE NOT NULL,
+					expires_at DATETIME NOT NULL,
+					used_at DATETIME,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_password_reset_token_user", "password_reset_tokens", "user_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_password_reset_token_user", "password_reset_tokens")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "password_reset_tokens")
+		},
+	)
+
+	builder.Add(
+		"20261016000004000",
+		"add_email_verification",
+		func(ctx context.Context, db database.Database) error {
+			// Accounts created before verification existed are considered
+			// verified so enabling it does not lock them out.
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP(0) WITH TIME ZONE`,
+				MySQL:    `ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP NULL`,
+				SQLite:   `ALTER TABLE users ADD COLUMN email_verified_at DATETIME`,
+			}); err != nil {
+				return err
+			}
+
+			if _, err := db.Exec(ctx, `UPDATE users SET email_verified_at = created_at`); err != nil {
+				return err
+			}
+
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS email_verification_tokens (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					used_at TIMESTAMP(0) WITH TIME ZONE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS email_verification_tokens (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP NOT NULL,
+					used_at TIMESTAMP NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_email_verification_token_user (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS email_verification_tokens (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash TEXT UNIQUE NOT NULL,
+					expires_at DATETIME NOT NULL,
+					used_at DATETIME,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_email_verification_token_user", "email_verification_tokens", "user_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_email_verification_token_user", "email_verification_tokens")
+			}
+
+			if err := migrations.DropTableIfExists(ctx, db, "email_verification_tokens"); err != nil {
+				return err
+			}
+
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at`,
+				MySQL:    `ALTER TABLE users DROP COLUMN email_verified_at`,
+				SQLite:   `ALTER TABLE users DROP COLUMN email_verified_at`,
+			})
+		},
+	)
+
+	builder.Add(
+		"20261016000005000",
+		"create_email_changes_table",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users ADD COLUMN pending_email VARCHAR(255)`,
+				MySQL:    `ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NULL`,
+				SQLite:   `ALTER TABLE users ADD COLUMN pending_email TEXT`,
+			}); err != nil {
+				return err
+			}
+
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS email_changes (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					old_email VARCHAR(255) NOT NULL,
+					new_email VARCHAR(255) NOT NULL,
+					confirm_token_hash VARCHAR(64) UNIQUE NOT NULL,
+					revert_token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					revert_expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					confirmed_at TIMESTAMP(0) WITH TIME ZONE,
+					reverted_at TIMESTAMP(0) WITH TIME ZONE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS email_changes (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					old_email VARCHAR(255) NOT NULL,
+					new_email VARCHAR(255) NOT NULL,
+					confirm_token_hash VARCHAR(64) UNIQUE NOT NULL,
+					revert_token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP NOT NULL,
+					revert_expires_at TIMESTAMP NOT NULL,
+					confirmed_at TIMESTAMP NULL,
+					reverted_at TIMESTAMP NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_email_changes_user (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS email_changes (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					old_email TEXT NOT NULL,
+					new_email TEXT NOT NULL,
+					confirm_token_hash TEXT UNIQUE NOT NULL,
+					revert_token_hash TEXT UNIQUE NOT NULL,
+					expires_at DATETIME NOT NULL,
+					revert_expires_at DATETIME NOT NULL,
+					confirmed_at DATETIME,
+					reverted_at DATETIME,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_email_changes_user", "email_changes", "user_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_email_changes_user", "email_changes")
+			}
+
+			if err := migrations.DropTableIfExists(ctx, db, "email_changes"); err != nil {
+				return err
+			}
+
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users DROP COLUMN IF EXISTS pending_email`,
+				MySQL:    `ALTER TABLE users DROP COLUMN pending_email`,
+				SQLite:   `ALTER TABLE users DROP COLUMN pending_email`,
+			})
+		},
+	)
+
+	builder.Add(
+		"20261016000006000",
+		"create_password_history_table",
+		func(ctx context.Context, db database.Database) error {
+			// Sub-second precision keeps the order of passwords changed within
+			// the same second.
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS password_history (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					password_hash VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP(6) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS password_history (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					password_hash VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+					INDEX idx_password_history_user (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS password_history (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					password_hash TEXT NOT NULL,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_password_history_user", "password_history", "user_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_password_history_user", "password_history")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "password_history")
+		},
+	)
+
+	builder.Add(
+		"20261016000007000",
+		"add_password_expiry_to_users",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMP(0) WITH TIME ZONE`,
+				MySQL:    `ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMP NULL`,
+				SQLite:   `ALTER TABLE users ADD COLUMN password_changed_at DATETIME`,
+			}); err != nil {
+				return err
+			}
+
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE`,
+				MySQL:    `ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE`,
+				SQLite:   `ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT 0`,
+			})
+		},
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users DROP COLUMN IF EXISTS must_change_password`,
+				MySQL:    `ALTER TABLE users DROP COLUMN must_change_password`,
+				SQLite:   `ALTER TABLE users DROP COLUMN must_change_password`,
+			}); err != nil {
+				return err
+			}
+
+			return migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at`,
+				MySQL:    `ALTER TABLE users DROP COLUMN password_changed_at`,
+				SQLite:   `ALTER TABLE users DROP COLUMN password_changed_at`,
+			})
+		},
+	)
+
+	builder.Add(
+		"20261016000008000",
+		"create_mfa_tables",
+		func(ctx context.Context, db database.Database) error {
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS totp_credentials (
+					user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					secret VARCHAR(255) NOT NULL,
+					confirmed_at TIMESTAMP(0) WITH TIME ZONE,
+					last_used_step BIGINT NOT NULL DEFAULT 0,
+					failed_attempts INTEGER NOT NULL DEFAULT 0,
+					locked_until TIMESTAMP(0) WITH TIME ZONE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS totp_credentials (
+					user_id CHAR(36) PRIMARY KEY,
+					secret VARCHAR(255) NOT NULL,
+					confirmed_at TIMESTAMP NULL,
+					last_used_step BIGINT NOT NULL DEFAULT 0,
+					failed_attempts INT NOT NULL DEFAULT 0,
+					locked_until TIMESTAMP NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS totp_credentials (
+					user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					secret TEXT NOT NULL,
+					confirmed_at DATETIME,
+					last_used_step INTEGER NOT NULL DEFAULT 0,
+					failed_attempts INTEGER NOT NULL DEFAULT 0,
+					locked_until DATETIME,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					code_hash VARCHAR(64) UNIQUE NOT NULL,
+					used_at TIMESTAMP(0) WITH TIME ZONE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					code_hash VARCHAR(64) UNIQUE NOT NULL,
+					used_at TIMESTAMP NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_mfa_recovery_codes_user (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					code_hash TEXT UNIQUE NOT NULL,
+					used_at DATETIME,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_mfa_recovery_codes_user", "mfa_recovery_codes", "user_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_mfa_recovery_codes_user", "mfa_recovery_codes")
+			}
+
+			if err := migrations.DropTableIfExists(ctx, db, "mfa_recovery_codes"); err != nil {
+				return err
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "totp_credentials")
+		},
+	)
+
+	builder.Add(
+		"20261016000009000",
+		"create_webauthn_tables",
+		func(ctx context.Context, db database.Database) error {
+			// Credential IDs may be longer than an indexable column, so they
+			// are looked up by digest.
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+					id UUID PRIMARY KEY,
+					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					credential_id TEXT NOT NULL,
+					credential_id_hash VARCHAR(64) UNIQUE NOT NULL,
+					public_key TEXT NOT NULL,
+					sign_count BIGINT NOT NULL DEFAULT 0,
+					transports VARCHAR(255) NOT NULL DEFAULT '',
+					name VARCHAR(255) NOT NULL DEFAULT '',
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP(0) WITH TIME ZONE
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+					id CHAR(36) PRIMARY KEY,
+					user_id CHAR(36) NOT NULL,
+					credential_id TEXT NOT NULL,
+					credential_id_hash VARCHAR(64) UNIQUE NOT NULL,
+					public_key TEXT NOT NULL,
+					sign_count BIGINT NOT NULL DEFAULT 0,
+					transports VARCHAR(255) NOT NULL DEFAULT '',
+					name VARCHAR(255) NOT NULL DEFAULT '',
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP NULL,
+					INDEX idx_webauthn_credentials_user (user_id),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					credential_id TEXT NOT NULL,
+					credential_id_hash TEXT UNIQUE NOT NULL,
+					public_key TEXT NOT NULL,
+					sign_count INTEGER NOT NULL DEFAULT 0,
+					transports TEXT NOT NULL DEFAULT '',
+					name TEXT NOT NULL DEFAULT '',
+					created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+					last_used_at DATETIME
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS webauthn_challenges (
+					id UUID PRIMARY KEY,
+					challenge_hash VARCHAR(64) UNIQUE NOT NULL,
+					user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+					purpose VARCHAR(16) NOT NULL,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS webauthn_challenges (
+					id CHAR(36) PRIMARY KEY,
+					challenge_hash VARCHAR(64) UNIQUE NOT NULL,
+					user_id CHAR(36) NULL,
+					purpose VARCHAR(16) NOT NULL,
+					expires_at TIMESTAMP NOT NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS webauthn_challenges (
+					id TEXT PRIMARY KEY,
+					challenge_hash TEXT UNIQUE NOT NULL,
+					user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+					purpose TEXT NOT NULL,
+					expires_at DATETIME NOT NULL,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_webauthn_credentials_user", "webauthn_credentials", "user_id")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_webauthn_credentials_user", "webauthn_credentials")
+			}
+
+			if err := migrations.DropTableIfExists(ctx, db, "webauthn_challenges"); err != nil {
+				return err
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "webauthn_credentials")
+		},
+	)
+
+	builder.Add(
+		"20261016000010000",
+		"create_magic_link_tokens_table",
+		func(ctx context.Context, db database.Database) error {
+			// Tokens are bound to an email address rather than a user so that
+			// unknown addresses can register with them.
+			if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE IF NOT EXISTS magic_link_tokens (
+					id UUID PRIMARY KEY,
+					email VARCHAR(255) NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					code_hash VARCHAR(64) NOT NULL,
+					failed_attempts INTEGER NOT NULL DEFAULT 0,
+					expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+					used_at TIMESTAMP(0) WITH TIME ZONE,
+					created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`,
+				MySQL: `CREATE TABLE IF NOT EXISTS magic_link_tokens (
+					id CHAR(36) PRIMARY KEY,
+					email VARCHAR(255) NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					code_hash VARCHAR(64) NOT NULL,
+					failed_attempts INT NOT NULL DEFAULT 0,
+					expires_at TIMESTAMP NOT NULL,
+					used_at TIMESTAMP NULL,
+					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					INDEX idx_magic_link_tokens_email (email)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE IF NOT EXISTS magic_link_tokens (
+					id TEXT PRIMARY KEY,
+					email TEXT NOT NULL,
+					token_hash TEXT UNIQUE NOT NULL,
+					code_hash TEXT NOT NULL,
+					failed_attempts INTEGER NOT NULL DEFAULT 0,
+					expires_at DATETIME NOT NULL,
+					used_at DATETIME,
+					created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+				)`,
+			}); err != nil {
+				return err
+			}
+
+			if db.DriverName() == "mysql" {
+				return nil
+			}
+
+			return migrations.CreateIndex(ctx, db, "idx_magic_link_tokens_email", "magic_link_tokens", "email")
+		},
+		func(ctx context.Context, db database.Database) error {
+			if db.DriverName() != "mysql" {
+				_ = migrations.DropIndex(ctx, db, "idx_magic_link_tokens_email", "magic_link_tokens")
+			}
+
+			return migrations.DropTableIfExists(ctx, db, "magic_link_tokens")
+		},
+	)
+
+	builder.Add(
+		"20261016000011000",
+		"add_authentication_to_refresh_tokens",
+		func(ctx context.Context, db database.Database) error {
+			// Families started before the columns existed refresh into tokens
+			// without authentication claims, which never satisfy a step-up.
+			for _, statement := range []migrations.DialectSQL{
+				{
+					Postgres: `ALTER TABLE refresh_tokens ADD COLUMN amr VARCHAR(255) NOT NULL DEFAULT ''`,
+					MySQL:    `ALTER TABLE refresh_tokens ADD COLUMN amr VARCHAR(255) NOT NULL DEFAULT ''`,
+					SQLite:   `ALTER TABLE refresh_tokens ADD COLUMN amr TEXT NOT NULL DEFAULT ''`,
+				},
+				{
+					Postgres: `ALTER TABLE refresh_tokens ADD COLUMN acr VARCHAR(16) NOT NULL DEFAULT ''`,
+					MySQL:    `ALTER TABLE refresh_tokens ADD COLUMN acr VARCHAR(16) NOT NULL DEFAULT ''`,
+					SQLite:   `ALTER TABLE refresh_tokens ADD COLUMN acr TEXT NOT NULL DEFAULT ''`,
+				},
+				{
+					Postgres: `ALTER TABLE refresh_tokens ADD COLUMN auth_time TIMESTAMP(0) WITH TIME ZONE`,
+					MySQL:    `ALTER TABLE refresh_tokens ADD COLUMN auth_time TIMESTAMP NULL`,
+					SQLite:   `ALTER TABLE refresh_tokens ADD COLUMN auth_time DATETIME`,
+				},
+			} {
+				if err := migrations.SQL(ctx, db, statement); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		func(ctx context.Context, db database.Database) error {
+			for _, column := range []string{"auth_time", "acr", "amr"} {
+				if err := migrations.SQL(ctx, db, migrations.DialectSQL{
+					Postgres: `ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ` + column,
+					MySQL:    `ALTER TABLE refresh_tokens DROP COLUMN ` + column,
+					SQLite:   `ALTER TABLE refresh_tokens DROP COLUMN ` + column,
+				}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	)
+
+	builder.Add(
+		"20261016000012000",
+		"drop_user_token_revocations_foreign_key",
+		func(ctx context.Context, db database.Database) error {
+			// The revocation of a deleted user must outlive them: access
+			// tokens embedding their roles are accepted without reading
+			// the users table.
+			return rebuildUserTokenRevocations(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE user_token_revocations_new (
+					user_id UUID PRIMARY KEY,
+					revoked_before TIMESTAMP(3) WITH TIME ZONE NOT NULL
+				)`,
+				MySQL: `CREATE TABLE user_token_revocations_new (
+					user_id CHAR(36) PRIMARY KEY,
+					revoked_before TIMESTAMP(3) NOT NULL
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE user_token_revocations_new (
+					user_id TEXT PRIMARY KEY,
+					revoked_before DATETIME NOT NULL
+				)`,
+			}, "")
+		},
+		func(ctx context.Context, db database.Database) error {
+			return rebuildUserTokenRevocations(ctx, db, migrations.DialectSQL{
+				Postgres: `CREATE TABLE user_token_revocations_new (
+					user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					revoked_before TIMESTAMP(3) WITH TIME ZONE NOT NULL
+				)`,
+				MySQL: `CREATE TABLE user_token_revocations_new (
+					user_id CHAR(36) PRIMARY KEY,
+					revoked_before TIMESTAMP(3) NOT NULL,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				SQLite: `CREATE TABLE user_token_revocations_new (
+					user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					revoked_before DATETIME NOT NULL
+				)`,
+			}, " WHERE user_id IN (SELECT id FROM users)")
+		},
+	)
+
 	return builder.Build()
 }
+
+// rebuildUserTokenRevocations replaces user_token_revocations with the table
+// created by create, copying the rows matching filter. Rebuilding is the only
+// way to change a foreign key that works on every database.
+func rebuildUserTokenRevocations(ctx context.Context, db database.Database, create migrations.DialectSQL, filter string) error {
+	if err := migrations.SQL(ctx, db, create); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(ctx, `INSERT INTO user_token_revocations_new (user_id, revoked_before)
+		SELECT user_id, revoked_before FROM user_token_revocations`+filter); err != nil {
+		return err
+	}
+
+	if err := migrations.DropTableIfExists(ctx, db, "user_token_revocations"); err != nil {
+		return err
+	}
+
+	return migrations.SQL(ctx, db, migrations.DialectSQL{
+		Postgres: `ALTER TABLE user_token_revocations_new RENAME TO user_token_revocations`,
+		MySQL:    `RENAME TABLE user_token_revocations_new TO user_token_revocations`,
+		SQLite:   `ALTER TABLE user_token_revocations_new RENAME TO user_token_revocations`,
+	})
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EmailChange struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	UserID           uuid.UUID  `json:"user_id" db:"user_id"`
+	OldEmail         string     `json:"old_email" db:"old_email"`
+	NewEmail         string     `json:"new_email" db:"new_email"`
+	ConfirmTokenHash string     `json:"-" db:"confirm_token_hash"`
+	RevertTokenHash  string     `json:"-" db:"revert_token_hash"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at"`
+	RevertExpiresAt  time.Time  `json:"revert_expires_at" db:"revert_expires_at"`
+	ConfirmedAt      *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+	RevertedAt       *time.Time `json:"reverted_at,omitempty" db:"reverted_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+}
+
+func (EmailChange) TableName() string {
+	return "email_changes"
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPCredential is the authenticator app enrolled by a user. It only
+// counts as a second factor once confirmed.
+type TOTPCredential struct {
+	UserID         uuid.UUID  `json:"user_id" db:"user_id"`
+	Secret         string     `json:"-" db:"secret"`
+	ConfirmedAt    *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+	LastUsedStep   int64      `json:"-" db:"last_used_step"`
+	FailedAttempts int        `json:"-" db:"failed_attempts"`
+	LockedUntil    *time.Time `json:"-" db:"locked_until"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+func (TOTPCredential) TableName() string {
+	return "totp_credentials"
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	CodeHash  string     `json:"-" db:"code_hash"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RefreshToken struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	FamilyID   uuid.UUID  `json:"family_id" db:"family_id"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ReplacedBy *uuid.UUID `json:"replaced_by,omitempty" db:"replaced_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+
+	// AMR (comma separated), ACR and AuthTime describe the login that
+	// started the family, and are copied into refreshed access tokens.
+	AMR      string     `json:"amr" db:"amr"`
+	ACR      string     `json:"acr" db:"acr"`
+	AuthTime *time.Time `json:"auth_time,omitempty" db:"auth_time"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -4,7 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/nicolasbonnici/gorest-auth/password"
 )
 
 type User struct {
@@ -16,31 +16,64 @@ type User struct {
 	Role      string     `json:"role" db:"role" gorm:"not null;default:'user'" rbac:"read:*;write:admin"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at" rbac:"read:*;write:none"`
 	UpdatedAt *time.Time `json:"updated_at,omitempty" db:"updated_at" rbac:"read:*;write:none"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" db:"email_verified_at" rbac:"read:*;write:none"`
+	PendingEmail    *string    `json:"pending_email,omitempty" db:"pending_email" rbac:"read:*;write:none"`
+
+	PasswordChangedAt  *time.Time `json:"password_changed_at,omitempty" db:"password_changed_at" rbac:"read:*;write:none"`
+	MustChangePassword *bool      `json:"must_change_password" db:"must_change_password" rbac:"read:*;write:admin"`
 }
 
 func (User) TableName() string {
 	return "users"
 }
 
-func (u *User) HashPassword() error {
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// PasswordChangeRequired reports whether the user must change their password,
+// e.g. after an admin set a temporary one.
+func (u *User) PasswordChangeRequired() bool {
+	return u.MustChangePassword != nil && *u.MustChangePassword
+}
+
+// PasswordExpired reports whether the password is older than maxAge. Users
+// who never changed their password count from their creation date.
+func (u *User) PasswordExpired(maxAge time.Duration) bool {
+	changedAt := u.CreatedAt
+	if u.PasswordChangedAt != nil {
+		changedAt = *u.PasswordChangedAt
+	}
+	return time.Since(changedAt) > maxAge
+}
+
+// HashPassword replaces the plain text password with its hash, made by the
+// hasher.
+func (u *User) HashPassword(hasher password.Hasher) error {
 	if u.Password == nil || *u.Password == "" {
 		return nil
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*u.Password), bcrypt.DefaultCost)
+	hashedStr, err := hasher.Hash(*u.Password)
 	if err != nil {
 		return err
 	}
 
-	hashedStr := string(hashedPassword)
 	u.Password = &hashedStr
 	return nil
 }
 
-func (u *User) CheckPassword(password string) bool {
+func (u *User) CheckPassword(hasher password.Hasher, plain string) bool {
 	if u.Password == nil {
 		return false
 	}
-	err := bcrypt.CompareHashAndPassword([]byte(*u.Password), []byte(password))
-	return err == nil
+	ok, err := hasher.Verify(plain, *u.Password)
+	return err == nil && ok
+}
+
+// NeedsRehash reports whether the password hash should be upgraded to the
+// algorithm and parameters of the hasher.
+func (u *User) NeedsRehash(hasher password.Hasher) bool {
+	return u.Password != nil && hasher.NeedsRehash(*u.Password)
 }
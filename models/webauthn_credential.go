@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WebAuthnCredential struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	UserID           uuid.UUID  `json:"user_id" db:"user_id"`
+	CredentialID     string     `json:"credential_id" db:"credential_id"`
+	CredentialIDHash string     `json:"-" db:"credential_id_hash"`
+	PublicKey        string     `json:"-" db:"public_key"`
+	SignCount        int64      `json:"-" db:"sign_count"`
+	Transports       string     `json:"-" db:"transports"`
+	Name             string     `json:"name" db:"name"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
@@ -0,0 +1,257 @@
+package paseto
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test vectors of https://github.com/paseto-standard/test-vectors (v4.json).
+
+const (
+	vectorLocalKey     = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	vectorSecretKey    = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorPublicKey    = "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorZeroNonce    = "0000000000000000000000000000000000000000000000000000000000000000"
+	vectorNonce        = "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8"
+	vectorSecretClaims = `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorHiddenClaims = `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorSignedClaims = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorKeyIDFooter  = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+)
+
+type vector struct {
+	name     string
+	nonce    string
+	payload  string
+	footer   string
+	implicit string
+	token    string
+}
+
+var localVectors = []vector{
+	{
+		name:    "4-E-1",
+		nonce:   vectorZeroNonce,
+		payload: vectorSecretClaims,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+	},
+	{
+		name:    "4-E-2",
+		nonce:   vectorZeroNonce,
+		payload: vectorHiddenClaims,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+	},
+	{
+		name:    "4-E-3",
+		nonce:   vectorNonce,
+		payload: vectorSecretClaims,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+	},
+	{
+		name:    "4-E-4",
+		nonce:   vectorNonce,
+		payload: vectorHiddenClaims,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ",
+	},
+	{
+		name:    "4-E-5",
+		nonce:   vectorNonce,
+		payload: vectorSecretClaims,
+		footer:  vectorKeyIDFooter,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+	{
+		name:    "4-E-6",
+		nonce:   vectorNonce,
+		payload: vectorHiddenClaims,
+		footer:  vectorKeyIDFooter,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+	{
+		name:     "4-E-7",
+		nonce:    vectorNonce,
+		payload:  vectorSecretClaims,
+		footer:   vectorKeyIDFooter,
+		implicit: `{"test-vector":"4-E-7"}`,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t40KCCWLA7GYL9KFHzKlwY9_RnIfRrMQpueydLEAZGGcA.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+	{
+		name:     "4-E-8",
+		nonce:    vectorNonce,
+		payload:  vectorHiddenClaims,
+		footer:   vectorKeyIDFooter,
+		implicit: `{"test-vector":"4-E-8"}`,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t5uvqQbMGlLLNYBc7A6_x7oqnpUK5WLvj24eE4DVPDZjw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+	{
+		name:     "4-E-9",
+		nonce:    vectorNonce,
+		payload:  vectorHiddenClaims,
+		footer:   "arbitrary-string-that-isn't-json",
+		implicit: `{"test-vector":"4-E-9"}`,
+		token:    "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6tybdlmnMwcDMw0YxA_gFSE_IUWl78aMtOepFYSWYfQA.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24",
+	},
+}
+
+var publicVectors = []vector{
+	{
+		name:    "4-S-1",
+		payload: vectorSignedClaims,
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+	},
+	{
+		name:    "4-S-2",
+		payload: vectorSignedClaims,
+		footer:  vectorKeyIDFooter,
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+	{
+		name:     "4-S-3",
+		payload:  vectorSignedClaims,
+		footer:   vectorKeyIDFooter,
+		implicit: `{"test-vector":"4-S-3"}`,
+		token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+}
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestLocalVectors(t *testing.T) {
+	key := mustDecodeHex(t, vectorLocalKey)
+
+	for _, v := range localVectors {
+		t.Run(v.name, func(t *testing.T) {
+			token, err := encrypt(key, mustDecodeHex(t, v.nonce), []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != v.token {
+				t.Fatalf("unexpected token:\n got %s\nwant %s", token, v.token)
+			}
+
+			payload, footer, err := Decrypt(key, v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != v.payload || string(footer) != v.footer {
+				t.Fatalf("unexpected payload %s and footer %s", payload, footer)
+			}
+		})
+	}
+}
+
+func TestPublicVectors(t *testing.T) {
+	secretKey := ed25519.PrivateKey(mustDecodeHex(t, vectorSecretKey))
+	publicKey := ed25519.PublicKey(mustDecodeHex(t, vectorPublicKey))
+
+	for _, v := range publicVectors {
+		t.Run(v.name, func(t *testing.T) {
+			token, err := Sign(secretKey, []byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != v.token {
+				t.Fatalf("unexpected token:\n got %s\nwant %s", token, v.token)
+			}
+
+			payload, footer, err := Verify(publicKey, v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != v.payload || string(footer) != v.footer {
+				t.Fatalf("unexpected payload %s and footer %s", payload, footer)
+			}
+		})
+	}
+}
+
+func TestLocalRejectsTamperedTokens(t *testing.T) {
+	key := mustDecodeHex(t, vectorLocalKey)
+	v := localVectors[6]
+
+	otherKey := append([]byte(nil), key...)
+	otherKey[0] ^= 0xff
+
+	tests := []struct {
+		name     string
+		key      []byte
+		token    string
+		implicit string
+		expected error
+	}{
+		{"wrong key", otherKey, v.token, v.implicit, ErrInvalidSignature},
+		{"wrong implicit assertion", key, v.token, `{"test-vector":"4-E-8"}`, ErrInvalidSignature},
+		{"missing implicit assertion", key, v.token, "", ErrInvalidSignature},
+		{"other footer", key, v.token[:strings.LastIndex(v.token, ".")] + ".e30", v.implicit, ErrInvalidSignature},
+		{"public token", key, publicVectors[0].token, "", ErrMalformed},
+		{"too short", key, "v4.local.AAAA", "", ErrMalformed},
+		{"invalid base64", key, "v4.local.!!!!", "", ErrMalformed},
+		{"extra part", key, v.token + ".e30", v.implicit, ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Decrypt(tt.key, tt.token, []byte(tt.implicit)); !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestPublicRejectsTamperedTokens(t *testing.T) {
+	publicKey := ed25519.PublicKey(mustDecodeHex(t, vectorPublicKey))
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := publicVectors[2]
+
+	tests := []struct {
+		name     string
+		key      ed25519.PublicKey
+		token    string
+		implicit string
+		expected error
+	}{
+		{"wrong key", otherKey, v.token, v.implicit, ErrInvalidSignature},
+		{"wrong implicit assertion", publicKey, v.token, `{"test-vector":"4-S-2"}`, ErrInvalidSignature},
+		{"removed footer", publicKey, v.token[:strings.LastIndex(v.token, ".")], v.implicit, ErrInvalidSignature},
+		{"local token", publicKey, localVectors[0].token, "", ErrMalformed},
+		{"too short", publicKey, "v4.public.AAAA", "", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Verify(tt.key, tt.token, []byte(tt.implicit)); !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestFooter(t *testing.T) {
+	for _, v := range append(append([]vector(nil), localVectors...), publicVectors...) {
+		footer, err := Footer(v.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(footer) != v.footer {
+			t.Fatalf("%s: unexpected footer %q", v.name, footer)
+		}
+	}
+
+	if _, err := Footer("v3.local.AAAA"); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected ErrMalformed, got %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	stdcontext "context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/paseto"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+// PasetoService issues PASETO v4 access tokens, either signed with Ed25519
+// (v4.public) or encrypted with a shared key (v4.local). Unlike JWT, the
+// token header fixes the algorithm, so there is no algorithm to negotiate.
+type PasetoService struct {
+	tokenPolicy
+	format   string
+	keys     *Keyring
+	localKey []byte
+}
+
+type pasetoFooter struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+// NewPasetoPublicService creates a v4.public service. Every key of the
+// keyring must be an Ed25519 key; the kid of the signing key is stored in
+// the token footer.
+func NewPasetoPublicService(keys *Keyring, ttl int) (*PasetoService, error) {
+	// Keys rotated in later must be Ed25519 keys too.
+	if err := keys.restrict(AlgEdDSA); err != nil {
+		return nil, fmt.Errorf("%s tokens require Ed25519 keys: %w", TokenFormatPasetoPublic, err)
+	}
+
+	return &PasetoService{
+		tokenPolicy: newTokenPolicy(ttl),
+		format:      TokenFormatPasetoPublic,
+		keys:        keys,
+	}, nil
+}
+
+// NewPasetoLocalService creates a v4.local service encrypting tokens with
+// the given 32 byte key.
+func NewPasetoLocalService(key []byte, ttl int) (*PasetoService, error) {
+	if len(key) != paseto.KeySize {
+		return nil, fmt.Errorf("%s key must be %d bytes", TokenFormatPasetoLocal, paseto.KeySize)
+	}
+
+	return &PasetoService{
+		tokenPolicy: newTokenPolicy(ttl),
+		format:      TokenFormatPasetoLocal,
+		localKey:    key,
+	}, nil
+}
+
+// NewPasetoServiceFromConfig creates a PasetoService for the configured
+// token format. v4.public uses the Ed25519 keys of the JWT key settings and
+// v4.local uses PasetoLocalKey.
+func NewPasetoServiceFromConfig(config Config) (*PasetoService, error) {
+	var service *PasetoService
+
+	switch config.TokenFormat {
+	case TokenFormatPasetoPublic:
+		keys, err := config.Keyring()
+		if err != nil {
+			return nil, err
+		}
+		service, err = NewPasetoPublicService(keys, config.JWTTTL)
+		if err != nil {
+			return nil, err
+		}
+	case TokenFormatPasetoLocal:
+		key, err := hex.DecodeString(config.PasetoLocalKey)
+		if err != nil {
+			return nil, fmt.Errorf("paseto_local_key must be hex encoded: %w", err)
+		}
+		service, err = NewPasetoLocalService(key, config.JWTTTL)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown PASETO token format: %s", config.TokenFormat)
+	}
+
+	service.configure(config)
+	return service, nil
+}
+
+// Keyring returns the Ed25519 keys of a v4.public service, or nil for
+// v4.local.
+func (s *PasetoService) Keyring() *Keyring {
+	return s.keys
+}
+
+func (s *PasetoService) Format() string {
+	return s.format
+}
+
+func (s *PasetoService) GenerateToken(ctx stdcontext.Context, user *models.User, opts ...TokenOption) (string, error) {
+	claims, err := s.newClaims(ctx, user, opts)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(pasetoPayload(claims))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode claims: %w", err)
+	}
+
+	if s.format == TokenFormatPasetoLocal {
+		return paseto.Encrypt(s.localKey, payload, nil, nil)
+	}
+
+	key := s.keys.Current()
+	if !key.CanSign() {
+		return "", fmt.Errorf("signing key is not configured")
+	}
+
+	footer, err := json.Marshal(pasetoFooter{KeyID: key.ID})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode footer: %w", err)
+	}
+
+	signKey, ok := key.signKey.(ed25519.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("key %s is not an Ed25519 key", key.ID)
+	}
+
+	return paseto.Sign(signKey, payload, footer, nil)
+}
+
+func (s *PasetoService) ValidateToken(tokenString string) (*tokens.Claims, error) {
+	payload, err := s.open(tokenString)
+	if errors.Is(err, paseto.ErrMalformed) {
+		return nil, fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenSignatureInvalid, err)
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(payload, &values); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims: %w", ErrTokenMalformed, err)
+	}
+
+	claims, err := fromPasetoPayload(values)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (s *PasetoService) open(tokenString string) ([]byte, error) {
+	if s.format == TokenFormatPasetoLocal {
+		payload, _, err := paseto.Decrypt(s.localKey, tokenString, nil)
+		return payload, err
+	}
+
+	footer, err := paseto.Footer(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	key := s.keys.Current()
+	if len(footer) > 0 {
+		var decoded pasetoFooter
+		if err := json.Unmarshal(footer, &decoded); err != nil {
+			return nil, paseto.ErrMalformed
+		}
+		var ok bool
+		key, ok = s.keys.Lookup(decoded.KeyID)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", decoded.KeyID)
+		}
+	}
+
+	publicKey, ok := key.verifyKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an Ed25519 key", key.ID)
+	}
+
+	payload, _, err := paseto.Verify(publicKey, tokenString, nil)
+	return payload, err
+}
+
+// pasetoPayload encodes the claims following the PASETO registered claims:
+// times are RFC 3339 strings and a single audience is a plain string.
+func pasetoPayload(claims *tokens.Claims) map[string]any {
+	payload := make(map[string]any, len(claims.Custom)+8)
+	for name, value := range claims.Custom {
+		payload[name] = value
+	}
+
+	payload["jti"] = claims.ID
+	payload["sub"] = claims.Subject
+	payload["exp"] = claims.ExpiresAt.UTC().Format(time.RFC3339)
+	payload["iat"] = claims.IssuedAt.UTC().Format(time.RFC3339Nano)
+	payload["nbf"] = claims.NotBefore.UTC().Format(time.RFC3339)
+	if claims.Issuer != "" {
+		payload["iss"] = claims.Issuer
+	}
+	switch len(claims.Audience) {
+	case 0:
+	case 1:
+		payload["aud"] = claims.Audience[0]
+	default:
+		payload["aud"] = claims.Audience
+	}
+	if claims.Roles != nil {
+		payload["roles"] = claims.Roles
+	}
+	if claims.Restriction != "" {
+		payload["restriction"] = claims.Restriction
+	}
+	if len(claims.AMR) > 0 {
+		payload["amr"] = claims.AMR
+	}
+	if claims.ACR != "" {
+		payload["acr"] = claims.ACR
+	}
+	if !claims.AuthTime.IsZero() {
+		payload["auth_time"] = claims.AuthTime.UTC().Format(time.RFC3339)
+	}
+
+	return payload
+}
+
+func fromPasetoPayload(values map[string]any) (*tokens.Claims, error) {
+	claims := &tokens.Claims{
+		Custom: make(map[string]any),
+	}
+
+	claims.ID, _ = values["jti"].(string)
+	claims.Subject, _ = values["sub"].(string)
+	claims.Issuer, _ = values["iss"].(string)
+	claims.Restriction, _ = values["restriction"].(string)
+	claims.ACR, _ = values["acr"].(string)
+	claims.AMR = stringList(values["amr"])
+	claims.Audience = stringList(values["aud"])
+	if _, ok := values["roles"]; ok {
+		claims.Roles = stringList(values["roles"])
+	}
+
+	for name, target := range map[string]*time.Time{
+		"exp":       &claims.ExpiresAt,
+		"iat":       &claims.IssuedAt,
+		"nbf":       &claims.NotBefore,
+		"auth_time": &claims.AuthTime,
+	} {
+		value, ok := values[name].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s claim", ErrTokenMalformed, name)
+		}
+		*target = parsed
+	}
+
+	for name, value := range values {
+		if !tokens.IsReserved(name) {
+			claims.Custom[name] = value
+		}
+	}
+
+	return claims, nil
+}
+
+// stringList reads a claim holding either a string or a list of strings.
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func testEd25519PEM(t *testing.T) string {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func newTestPasetoPublicService(t *testing.T) *PasetoService {
+	t.Helper()
+
+	keys, err := NewKeyring(newTestEd25519Key(t, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewPasetoPublicService(keys, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestPasetoPublicService(t *testing.T) {
+	service := newTestPasetoPublicService(t)
+	service.SetIssuer("https://auth.example.com")
+	ctx := context.Background()
+	user := testUser()
+
+	token, err := service.GenerateToken(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "v4.public.") {
+		t.Fatalf("unexpected token: %s", token)
+	}
+
+	claims, err := service.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != user.ID.String() || claims.Issuer != "https://auth.example.com" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	if err := service.Keyring().Rotate(newTestEd25519Key(t, "second")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ValidateToken(token); err != nil {
+		t.Fatalf("token of the previous key rejected: %v", err)
+	}
+
+	tampered := token[:len(token)-4] + "AAAA"
+	if _, err := service.ValidateToken(tampered); err == nil {
+		t.Fatal("tampered token accepted")
+	}
+}
+
+func TestPasetoPublicServiceRequiresEd25519Keys(t *testing.T) {
+	hmacKey, err := NewHMACKey([]byte(testSecret), AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewKeyring(hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPasetoPublicService(keys, 900); err == nil {
+		t.Fatal("expected an HMAC keyring to be rejected")
+	}
+
+	service := newTestPasetoPublicService(t)
+	if err := service.Keyring().Rotate(hmacKey); err == nil {
+		t.Fatal("expected the rotation to an HMAC key to be rejected")
+	}
+	if err := service.Keyring().AddVerificationKey(hmacKey); err == nil {
+		t.Fatal("expected an HMAC verification key to be rejected")
+	}
+	if _, err := service.GenerateToken(context.Background(), testUser()); err != nil {
+		t.Fatalf("signing key changed by a rejected rotation: %v", err)
+	}
+}
+
+func TestInitializePasetoPublicRequiresEd25519Keys(t *testing.T) {
+	err := NewPlugin().Initialize(map[string]interface{}{
+		"token_format": TokenFormatPasetoPublic,
+		"jwt_secret":   testSecret,
+	})
+	if err == nil {
+		t.Fatal("expected Initialize to reject a shared secret for v4.public")
+	}
+
+	plugin := NewPlugin().(*AuthPlugin)
+	if err := plugin.Initialize(map[string]interface{}{
+		"token_format":    TokenFormatPasetoPublic,
+		"jwt_private_key": testEd25519PEM(t),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	hmacKey, err := NewHMACKey([]byte(testSecret), AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plugin.RotateSigningKey(hmacKey); err == nil {
+		t.Fatal("expected RotateSigningKey to reject an HMAC key")
+	}
+	if err := plugin.RotateSigningKey(newTestEd25519Key(t, "second")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasetoLocalService(t *testing.T) {
+	if _, err := NewPasetoLocalService(make([]byte, 16), 900); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+
+	service, err := NewPasetoLocalService(make([]byte, 32), 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := service.GenerateToken(context.Background(), testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "v4.local.") {
+		t.Fatalf("unexpected token: %s", token)
+	}
+	if _, err := service.ValidateToken(token); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewPasetoLocalService(append(make([]byte, 31), 1), 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ValidateToken(token); err == nil {
+		t.Fatal("token decrypted with another key")
+	}
+}
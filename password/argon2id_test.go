@@ -0,0 +1,55 @@
+package password
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestArgon2idHasherAppliesDefaults(t *testing.T) {
+	hasher := NewArgon2idHasher(Argon2idParams{})
+
+	encoded, err := hasher.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != DefaultArgon2idParams {
+		t.Fatalf("expected the default parameters, got %+v", params)
+	}
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := NewArgon2idHasher(Argon2idParams{Memory: 1024, Iterations: 1})
+
+	encoded, err := hasher.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := hasher.Verify("correct-horse-battery", encoded); err != nil || !ok {
+		t.Fatalf("expected the password to match, got %v %v", ok, err)
+	}
+	if ok, _ := hasher.Verify("wrong-password", encoded); ok {
+		t.Fatal("expected a wrong password not to match")
+	}
+
+	if hasher.NeedsRehash(encoded) {
+		t.Fatal("expected a hash with the same parameters to be kept")
+	}
+	if !NewArgon2idHasher(Argon2idParams{Memory: 2048, Iterations: 1}).NeedsRehash(encoded) {
+		t.Fatal("expected a hash with less memory to be rehashed")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	for _, params := range []string{"m=1024,t=0,p=1", "m=1024,t=1,p=0", "m=-1,t=1,p=1", "m=1024,t=1"} {
+		encoded := fmt.Sprintf("$argon2id$v=19$%s$c2FsdHNhbHRzYWx0c2FsdA$a2V5", params)
+		if _, err := Verify("password", encoded); err == nil {
+			t.Fatalf("expected %s to be rejected", params)
+		}
+	}
+}
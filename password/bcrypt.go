@@ -0,0 +1,60 @@
+package password
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DefaultBcryptCost = bcrypt.DefaultCost
+
+// bcryptMaxLength is the number of bytes bcrypt uses; longer passwords are
+// rejected instead of silently truncated.
+const bcryptMaxLength = 72
+
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = DefaultBcryptCost
+	}
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxLength {
+		return "", ErrPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	return Verify(password, encoded)
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	if Algorithm(encoded) != AlgorithmBcrypt {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost
+}
+
+func verifyBcrypt(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
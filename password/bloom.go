@@ -0,0 +1,129 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// bloomMagic starts every bloom filter file, followed by the number of bits
+// (uint64), the number of hash functions (uint32), all big endian, and the
+// bits.
+const bloomMagic = "PWBLOOM1"
+
+var ErrMalformedBloomFilter = errors.New("malformed bloom filter")
+
+// BloomFilter is a compact, in memory, set of SHA-1 digests. It may report
+// a password that is not in the list as breached, at the false positive
+// rate chosen when it was built, but never misses one that is.
+type BloomFilter struct {
+	bits   []byte
+	size   uint64
+	hashes uint32
+}
+
+// NewBloomFilter sizes a filter for count digests at the false positive
+// rate.
+func NewBloomFilter(count uint64, falsePositiveRate float64) (*BloomFilter, error) {
+	if count == 0 {
+		return nil, fmt.Errorf("count must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("false positive rate must be between 0 and 1")
+	}
+
+	size := uint64(math.Ceil(-float64(count) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint32(math.Max(1, math.Round(float64(size)/float64(count)*math.Ln2)))
+
+	return &BloomFilter{
+		bits:   make([]byte, (size+7)/8),
+		size:   size,
+		hashes: hashes,
+	}, nil
+}
+
+func LoadBloomFilter(path string) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadBloomFilter(bufio.NewReader(file))
+}
+
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	header := make([]byte, len(bloomMagic)+12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrMalformedBloomFilter
+	}
+	if string(header[:len(bloomMagic)]) != bloomMagic {
+		return nil, ErrMalformedBloomFilter
+	}
+
+	f := &BloomFilter{
+		size:   binary.BigEndian.Uint64(header[len(bloomMagic):]),
+		hashes: binary.BigEndian.Uint32(header[len(bloomMagic)+8:]),
+	}
+	if f.size == 0 || f.hashes == 0 {
+		return nil, ErrMalformedBloomFilter
+	}
+
+	f.bits = make([]byte, (f.size+7)/8)
+	if _, err := io.ReadFull(r, f.bits); err != nil {
+		return nil, ErrMalformedBloomFilter
+	}
+
+	return f, nil
+}
+
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, len(bloomMagic)+12)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint64(header[len(bloomMagic):], f.size)
+	binary.BigEndian.PutUint32(header[len(bloomMagic)+8:], f.hashes)
+
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+
+	m, err := w.Write(f.bits)
+	return int64(n + m), err
+}
+
+// Add inserts a SHA-1 digest.
+func (f *BloomFilter) Add(digest [sha1.Size]byte) {
+	h1, h2 := bloomHashes(digest)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.size
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains reports whether the SHA-1 digest may have been added.
+func (f *BloomFilter) Contains(digest [sha1.Size]byte) bool {
+	h1, h2 := bloomHashes(digest)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.size
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *BloomFilter) Breached(password string) (bool, error) {
+	return f.Contains(sha1.Sum([]byte(password))), nil
+}
+
+// bloomHashes derives the two hashes of double hashing from the digest,
+// which is already uniformly distributed.
+func bloomHashes(digest [sha1.Size]byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(digest[0:8]), binary.BigEndian.Uint64(digest[8:16]) | 1
+}
@@ -0,0 +1,91 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func sha1Sum(password string) [sha1.Size]byte {
+	return sha1.Sum([]byte(password))
+}
+
+func TestBloomFilter(t *testing.T) {
+	const count = 10000
+	filter, err := NewBloomFilter(count, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range count {
+		filter.Add(sha1Sum(fmt.Sprintf("breached-%d", i)))
+	}
+
+	for i := range count {
+		if !filter.Contains(sha1Sum(fmt.Sprintf("breached-%d", i))) {
+			t.Fatalf("expected breached-%d to be found", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := range count {
+		if breached, _ := filter.Breached(fmt.Sprintf("safe-%d", i)); breached {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / count; rate > 0.02 {
+		t.Fatalf("false positive rate %f is above the one requested", rate)
+	}
+
+	var buf bytes.Buffer
+	if _, err := filter.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breached, _ := loaded.Breached("breached-42"); !breached {
+		t.Fatal("expected the loaded filter to hold the same hashes")
+	}
+}
+
+func TestReadMalformedBloomFilter(t *testing.T) {
+	filter, err := NewBloomFilter(10, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := filter.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", append([]byte("NOTBLOOM"), valid[len(bloomMagic):]...)},
+		{"truncated", valid[:len(valid)-1]},
+		{"zero size", append([]byte(bloomMagic), make([]byte, 12)...)},
+	}
+
+	for _, tt := range tests {
+		if _, err := ReadBloomFilter(bytes.NewReader(tt.content)); !errors.Is(err, ErrMalformedBloomFilter) {
+			t.Fatalf("%s: expected ErrMalformedBloomFilter, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestNewBloomFilter(t *testing.T) {
+	for _, rate := range []float64{0, 1, -0.5} {
+		if _, err := NewBloomFilter(10, rate); err == nil {
+			t.Fatalf("expected rate %f to be rejected", rate)
+		}
+	}
+	if _, err := NewBloomFilter(0, 0.01); err == nil {
+		t.Fatal("expected an empty filter to be rejected")
+	}
+}
@@ -0,0 +1,202 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachChecker reports whether a password appears in a list of passwords
+// exposed by data breaches, such as the Have I Been Pwned Pwned Passwords.
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+var ErrMalformedHashList = errors.New("malformed hash list")
+
+// sha1HexLength is the length of a hex encoded SHA-1 digest.
+const sha1HexLength = 40
+
+// OpenBreachChecker opens a local copy of the Pwned Passwords SHA-1 hashes.
+// path is either a bloom filter built by cmd/hibp-bloom, a single file of
+// HASH:COUNT lines ordered by hash, or a directory of range files named
+// after the first five characters of the hashes and holding SUFFIX:COUNT
+// lines, as written by the official downloader.
+func OpenBreachChecker(path string) (BreachChecker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return NewHashRangeDir(path), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(file, magic); err == nil && string(magic) == bloomMagic {
+		return LoadBloomFilter(path)
+	}
+
+	return OpenHashList(path)
+}
+
+// HashList looks passwords up in a file of HASH:COUNT lines ordered by hash,
+// with a binary search on the file so it is never loaded in memory.
+type HashList struct {
+	file *os.File
+	size int64
+}
+
+func OpenHashList(path string) (*HashList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &HashList{file: file, size: info.Size()}, nil
+}
+
+func (l *HashList) Close() error {
+	return l.file.Close()
+}
+
+func (l *HashList) Breached(password string) (bool, error) {
+	target := sha1Hex(password)
+
+	low, high := int64(0), l.size
+	for low < high {
+		mid := low + (high-low)/2
+
+		hash, start, next, err := l.lineAfter(mid)
+		if errors.Is(err, io.EOF) {
+			high = mid
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch compared := strings.Compare(hash, target); {
+		case compared == 0:
+			return true, nil
+		case compared < 0:
+			low = next
+		default:
+			if start == low {
+				return false, nil
+			}
+			high = mid
+		}
+	}
+
+	return false, nil
+}
+
+// lineAfter returns the hash of the first line starting at or after offset,
+// along with the offsets of that line and of the following one.
+func (l *HashList) lineAfter(offset int64) (string, int64, int64, error) {
+	// Reading from the byte before offset finds a line starting right at
+	// offset. Lines are about 50 bytes long, so one read holds the end of
+	// the current line and the whole next one.
+	skip := offset > 0
+	if skip {
+		offset--
+	}
+
+	buf := make([]byte, 256)
+	n, err := l.file.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, 0, err
+	}
+	buf = buf[:n]
+
+	start := 0
+	if skip {
+		newline := bytes.IndexByte(buf, '\n')
+		if newline < 0 {
+			return "", 0, 0, io.EOF
+		}
+		start = newline + 1
+	}
+
+	line := buf[start:]
+	end := bytes.IndexByte(line, '\n')
+	if end >= 0 {
+		line = line[:end+1]
+	} else if offset+int64(n) < l.size {
+		return "", 0, 0, ErrMalformedHashList
+	}
+
+	if len(line) == 0 {
+		return "", 0, 0, io.EOF
+	}
+	if len(line) < sha1HexLength {
+		return "", 0, 0, ErrMalformedHashList
+	}
+
+	lineStart := offset + int64(start)
+	return strings.ToUpper(string(line[:sha1HexLength])), lineStart, lineStart + int64(len(line)), nil
+}
+
+// HashRangeDir looks passwords up in a directory of range files.
+type HashRangeDir struct {
+	dir string
+}
+
+func NewHashRangeDir(dir string) *HashRangeDir {
+	return &HashRangeDir{dir: dir}
+}
+
+func (d *HashRangeDir) Breached(password string) (bool, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(d.dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read range file: %w", err)
+	}
+	return false, nil
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
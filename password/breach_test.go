@@ -0,0 +1,155 @@
+package password
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var breachedPasswords = []string{"password", "123456", "qwerty", "letmein", "dragon", "monkey", "sunshine"}
+
+// writeHashList writes the Pwned Passwords file of the passwords, one
+// HASH:COUNT line per password ordered by hash, with CRLF line endings.
+func writeHashList(t *testing.T, passwords []string) string {
+	t.Helper()
+
+	hashes := make([]string, len(passwords))
+	for i, password := range passwords {
+		hashes[i] = sha1Hex(password)
+	}
+	slices.Sort(hashes)
+
+	var content []byte
+	for i, hash := range hashes {
+		content = fmt.Appendf(content, "%s:%d\r\n", hash, i+1)
+	}
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkBreached(t *testing.T, checker BreachChecker) {
+	t.Helper()
+
+	for _, password := range breachedPasswords {
+		if breached, err := checker.Breached(password); err != nil || !breached {
+			t.Fatalf("expected %q to be breached, got %v %v", password, breached, err)
+		}
+	}
+	for _, password := range []string{"correct-horse-battery", "", "Password"} {
+		if breached, err := checker.Breached(password); err != nil || breached {
+			t.Fatalf("expected %q not to be breached, got %v %v", password, breached, err)
+		}
+	}
+}
+
+func TestHashList(t *testing.T) {
+	for n := 1; n <= len(breachedPasswords); n++ {
+		list, err := OpenHashList(writeHashList(t, breachedPasswords[:n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer list.Close()
+
+		for _, password := range breachedPasswords[:n] {
+			if breached, err := list.Breached(password); err != nil || !breached {
+				t.Fatalf("%d hashes: expected %q to be breached, got %v %v", n, password, breached, err)
+			}
+		}
+		for _, password := range breachedPasswords[n:] {
+			if breached, err := list.Breached(password); err != nil || breached {
+				t.Fatalf("%d hashes: expected %q not to be breached, got %v %v", n, password, breached, err)
+			}
+		}
+	}
+}
+
+func TestHashRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	for i, password := range breachedPasswords {
+		hash := sha1Hex(password)
+		// The downloader may write range files with or without extension.
+		name := hash[:5]
+		if i%2 == 0 {
+			name += ".txt"
+		}
+		content := fmt.Sprintf("0000000000000000000000000000000000F:1\r\n%s:%d\r\n", hash[5:], i+1)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBreached(t, NewHashRangeDir(dir))
+}
+
+func TestOpenBreachChecker(t *testing.T) {
+	list := writeHashList(t, breachedPasswords)
+
+	filter, err := NewBloomFilter(uint64(len(breachedPasswords)), 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range breachedPasswords {
+		filter.Add(sha1Sum(password))
+	}
+	bloom := filepath.Join(t.TempDir(), "pwned.bloom")
+	file, err := os.Create(bloom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{list, "*password.HashList"},
+		{bloom, "*password.BloomFilter"},
+		{t.TempDir(), "*password.HashRangeDir"},
+	}
+
+	for _, tt := range tests {
+		checker, err := OpenBreachChecker(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := fmt.Sprintf("%T", checker); kind != tt.expected {
+			t.Fatalf("%s: expected %s, got %s", tt.path, tt.expected, kind)
+		}
+		if tt.expected != "*password.HashRangeDir" {
+			checkBreached(t, checker)
+		}
+	}
+
+	if _, err := OpenBreachChecker(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected a missing file to be rejected")
+	}
+}
+
+func TestBreachedPolicy(t *testing.T) {
+	list, err := OpenHashList(writeHashList(t, breachedPasswords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	policy := DefaultPolicy()
+	policy.Breached = list
+
+	if rules := violatedRules(t, policy.Check("sunshine")); !slices.Equal(rules, []string{RuleBreached}) {
+		t.Fatalf("expected a breached password to be rejected, got %v", rules)
+	}
+	if err := policy.Check("correct-horse-battery"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package password hashes user passwords. Hashes are encoded in the PHC
+// string format ($argon2id$...) or the modular crypt format used by bcrypt
+// ($2a$...), so the algorithm and its parameters travel with every hash.
+package password
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnknownHash     = errors.New("unknown password hash format")
+	ErrMalformedHash   = errors.New("malformed password hash")
+	ErrPasswordTooLong = errors.New("password too long")
+)
+
+// Hasher hashes new passwords with one algorithm and verifies hashes made by
+// any supported algorithm, so users can be migrated on their next login.
+type Hasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+
+	// Verify reports whether the password matches the encoded hash.
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash reports whether the hash was made with another algorithm
+	// or weaker parameters than the ones of the hasher.
+	NeedsRehash(encoded string) bool
+}
+
+// New returns a hasher for the algorithm with its default parameters.
+func New(algorithm string) (Hasher, error) {
+	switch algorithm {
+	case AlgorithmArgon2id:
+		return NewArgon2idHasher(DefaultArgon2idParams), nil
+	case AlgorithmBcrypt:
+		return NewBcryptHasher(DefaultBcryptCost), nil
+	default:
+		return nil, errors.New("unsupported password hashing algorithm: " + algorithm)
+	}
+}
+
+// Verify checks the password against a hash made by any supported algorithm.
+func Verify(password, encoded string) (bool, error) {
+	switch Algorithm(encoded) {
+	case AlgorithmArgon2id:
+		return verifyArgon2id(password, encoded)
+	case AlgorithmBcrypt:
+		return verifyBcrypt(password, encoded)
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// Algorithm returns the algorithm an encoded hash was made with, or an empty
+// string when the format is unknown.
+func Algorithm(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,221 @@
+// Package history remembers the previous passwords of users so they cannot
+// reuse them.
+package history
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// Executor runs queries on the database or in a transaction.
+type Executor interface {
+	Query(ctx context.Context, query string, args ...interface{}) (database.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) database.Row
+	Exec(ctx context.Context, query string, args ...interface{}) (database.Result, error)
+}
+
+// Store keeps the hashes of replaced passwords in the password_history
+// table. A nil *Store keeps nothing.
+type Store struct {
+	db     database.Database
+	depth  int
+	hasher password.Hasher
+}
+
+// NewStore rejects the last depth passwords of every user, the current one
+// included. The hasher verifies passwords against the kept hashes.
+func NewStore(db database.Database, depth int, hasher password.Hasher) *Store {
+	return &Store{db: db, depth: depth, hasher: hasher}
+}
+
+// Check returns a *password.PolicyError when the password is the current
+// password of the user or one of the previous ones kept.
+func (s *Store) Check(ctx context.Context, exec Executor, userID uuid.UUID, plain string) error {
+	if s == nil || s.depth <= 0 {
+		return nil
+	}
+
+	current, err := s.currentHash(ctx, exec, userID)
+	if err != nil {
+		return err
+	}
+
+	hashes, err := s.previousHashes(ctx, exec, userID)
+	if err != nil {
+		return err
+	}
+
+	if current != "" {
+		hashes = append([]string{current}, hashes...)
+	}
+
+	for _, hash := range hashes {
+		// Hashes made with a retired pepper can no longer be verified.
+		if ok, err := s.hasher.Verify(plain, hash); err == nil && ok {
+			return &password.PolicyError{Violations: []password.Violation{{
+				Rule:    password.RuleHistory,
+				Message: fmt.Sprintf("password must differ from your last %d passwords", s.depth),
+			}}}
+		}
+	}
+
+	return nil
+}
+
+// RecordCurrent keeps the current password of the user before it is replaced
+// and forgets the passwords past the depth.
+func (s *Store) RecordCurrent(ctx context.Context, exec Executor, userID uuid.UUID) error {
+	current, err := s.Current(ctx, exec, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.Record(ctx, exec, userID, current)
+}
+
+// Current returns the hash of the current password of the user, to Record
+// once the update replacing it succeeded. It is empty when nothing is kept.
+func (s *Store) Current(ctx context.Context, exec Executor, userID uuid.UUID) (string, error) {
+	if s == nil || s.depth <= 1 {
+		return "", nil
+	}
+
+	return s.currentHash(ctx, exec, userID)
+}
+
+// Record keeps a replaced password hash of the user and forgets the passwords
+// past the depth.
+func (s *Store) Record(ctx context.Context, exec Executor, userID uuid.UUID, hash string) error {
+	if s == nil || s.depth <= 1 || hash == "" {
+		return nil
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Insert("password_history").
+		Columns("id", "user_id", "password_hash", "created_at").
+		Values(uuid.New(), userID, hash, time.Now()).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to store password history: %w", err)
+	}
+
+	return s.prune(ctx, exec, userID)
+}
+
+// prune keeps the depth - 1 most recent entries, the current password being
+// the last one.
+func (s *Store) prune(ctx context.Context, exec Executor, userID uuid.UUID) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id").
+		From("password_history").
+		Where(query.Eq("user_id", userID)).
+		OrderBy("created_at", query.DESC).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := exec.Query(ctx, queryStr, args...)
+	if err != nil {
+		return fmt.Errorf("failed to read password history: %w", err)
+	}
+
+	var stale []any
+	for kept := 0; rows.Next(); kept++ {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read password history: %w", err)
+		}
+		if kept >= s.depth-1 {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read password history: %w", err)
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Delete("password_history").
+		Where(query.In("id", stale...)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) currentHash(ctx context.Context, exec Executor, userID uuid.UUID) (string, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("password").
+		From("users").
+		Where(query.Eq("id", userID)).
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var hash *string
+	if err := exec.QueryRow(ctx, queryStr, args...).Scan(&hash); err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	if hash == nil {
+		return "", nil
+	}
+	return *hash, nil
+}
+
+func (s *Store) previousHashes(ctx context.Context, exec Executor, userID uuid.UUID) ([]string, error) {
+	if s.depth <= 1 {
+		return nil, nil
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("password_hash").
+		From("password_history").
+		Where(query.Eq("user_id", userID)).
+		OrderBy("created_at", query.DESC).
+		Limit(s.depth - 1).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := exec.Query(ctx, queryStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password history: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to read password history: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
@@ -0,0 +1,193 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	authmigrations "github.com/nicolasbonnici/gorest-auth/migrations"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest/database"
+	_ "github.com/nicolasbonnici/gorest/database/sqlite"
+	"github.com/nicolasbonnici/gorest/migrations"
+)
+
+var testHasher = password.NewArgon2idHasher(password.Argon2idParams{Memory: 1024, Iterations: 1})
+
+func newTestDatabase(t *testing.T) database.Database {
+	t.Helper()
+
+	db, err := database.Open("sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(1)", t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrations.NewMigrator(db, authmigrations.GetMigrations()).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// createUser inserts a user with the password and returns its ID.
+func createUser(t *testing.T, db database.Database, plain string) uuid.UUID {
+	t.Helper()
+
+	userID := uuid.New()
+	if _, err := db.Exec(context.Background(),
+		"INSERT INTO users (id, firstname, lastname, email) VALUES (?, 'Jane', 'Doe', 'jane@example.com')", userID.String()); err != nil {
+		t.Fatal(err)
+	}
+	setPassword(t, db, userID, plain)
+
+	return userID
+}
+
+func setPassword(t *testing.T, db database.Database, userID uuid.UUID, plain string) {
+	t.Helper()
+
+	encoded, err := testHasher.Hash(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(context.Background(), "UPDATE users SET password = ? WHERE id = ?", encoded, userID.String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// changePassword keeps the current password of the user and replaces it, as
+// the password change handlers do.
+func changePassword(t *testing.T, db database.Database, store *Store, userID uuid.UUID, plain string) {
+	t.Helper()
+
+	if err := store.RecordCurrent(context.Background(), db, userID); err != nil {
+		t.Fatal(err)
+	}
+	setPassword(t, db, userID, plain)
+}
+
+func assertRejected(t *testing.T, db database.Database, store *Store, userID uuid.UUID, plain string, rejected bool) {
+	t.Helper()
+
+	err := store.Check(context.Background(), db, userID, plain)
+	if !rejected {
+		if err != nil {
+			t.Fatalf("expected %q to be accepted, got %v", plain, err)
+		}
+		return
+	}
+
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) || len(policyErr.Violations) != 1 || policyErr.Violations[0].Rule != password.RuleHistory {
+		t.Fatalf("expected %q to be rejected by the history, got %v", plain, err)
+	}
+}
+
+func countEntries(t *testing.T, db database.Database, userID uuid.UUID) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM password_history WHERE user_id = ?", userID.String()).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestStoreCheck(t *testing.T) {
+	db := newTestDatabase(t)
+	store := NewStore(db, 3, testHasher)
+	userID := createUser(t, db, "first-password")
+
+	// The current password counts as one of the last ones.
+	assertRejected(t, db, store, userID, "first-password", true)
+	assertRejected(t, db, store, userID, "second-password", false)
+
+	changePassword(t, db, store, userID, "second-password")
+	assertRejected(t, db, store, userID, "first-password", true)
+	assertRejected(t, db, store, userID, "second-password", true)
+	assertRejected(t, db, store, userID, "third-password", false)
+}
+
+func TestStoreRecordCurrent(t *testing.T) {
+	db := newTestDatabase(t)
+	store := NewStore(db, 3, testHasher)
+	userID := createUser(t, db, "first-password")
+
+	changePassword(t, db, store, userID, "second-password")
+	if count := countEntries(t, db, userID); count != 1 {
+		t.Fatalf("expected the replaced password to be kept, got %d entries", count)
+	}
+
+	var stored string
+	if err := db.QueryRow(context.Background(), "SELECT password_hash FROM password_history WHERE user_id = ?", userID.String()).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := testHasher.Verify("first-password", stored); err != nil || !ok {
+		t.Fatalf("expected the hash of the replaced password, got %s", stored)
+	}
+
+	// Users without a password have nothing to keep.
+	other := uuid.New()
+	if _, err := db.Exec(context.Background(),
+		"INSERT INTO users (id, firstname, lastname, email) VALUES (?, 'John', 'Doe', 'john@example.com')", other.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordCurrent(context.Background(), db, other); err != nil {
+		t.Fatal(err)
+	}
+	if count := countEntries(t, db, other); count != 0 {
+		t.Fatalf("expected nothing to be kept, got %d entries", count)
+	}
+}
+
+func TestStorePrunesToDepth(t *testing.T) {
+	db := newTestDatabase(t)
+	store := NewStore(db, 3, testHasher)
+	userID := createUser(t, db, "password-0")
+
+	for i := 1; i <= 5; i++ {
+		changePassword(t, db, store, userID, fmt.Sprintf("password-%d", i))
+	}
+
+	// The current password and the two previous ones are kept.
+	if count := countEntries(t, db, userID); count != 2 {
+		t.Fatalf("expected 2 entries, got %d", count)
+	}
+	for i := 0; i <= 5; i++ {
+		assertRejected(t, db, store, userID, fmt.Sprintf("password-%d", i), i >= 3)
+	}
+}
+
+func TestStoreDisabled(t *testing.T) {
+	db := newTestDatabase(t)
+	userID := createUser(t, db, "first-password")
+
+	for _, tt := range []struct {
+		name  string
+		store *Store
+	}{
+		{"nil", nil},
+		{"zero depth", NewStore(db, 0, testHasher)},
+	} {
+		changePassword(t, db, tt.store, userID, "second-password")
+		assertRejected(t, db, tt.store, userID, "second-password", false)
+		if current, err := tt.store.Current(context.Background(), db, userID); err != nil || current != "" {
+			t.Fatalf("%s: expected no current hash, got %q %v", tt.name, current, err)
+		}
+		if count := countEntries(t, db, userID); count != 0 {
+			t.Fatalf("%s: expected nothing to be kept, got %d entries", tt.name, count)
+		}
+	}
+
+	// A depth of one only rejects the current password.
+	store := NewStore(db, 1, testHasher)
+	changePassword(t, db, store, userID, "third-password")
+	assertRejected(t, db, store, userID, "third-password", true)
+	assertRejected(t, db, store, userID, "second-password", false)
+	if count := countEntries(t, db, userID); count != 0 {
+		t.Fatalf("expected nothing to be kept, got %d entries", count)
+	}
+}
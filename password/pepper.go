@@ -0,0 +1,139 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const pepperPrefix = "$pepper$"
+
+// minPepperLength is the minimum size, in bytes, of a pepper secret.
+const minPepperLength = 16
+
+var ErrUnknownPepper = errors.New("unknown password pepper version")
+
+// Peppers holds the server-side secrets mixed into passwords before they are
+// hashed. Previous versions are kept to verify existing hashes until users
+// log in again.
+type Peppers struct {
+	current string
+	secrets map[string][]byte
+}
+
+func NewPeppers(version string, secret []byte, previous map[string][]byte) (*Peppers, error) {
+	p := &Peppers{
+		current: version,
+		secrets: make(map[string][]byte),
+	}
+
+	for v, s := range previous {
+		if err := p.add(v, s); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.add(version, secret); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Version returns the version used for new hashes.
+func (p *Peppers) Version() string {
+	return p.current
+}
+
+func (p *Peppers) add(version string, secret []byte) error {
+	if version == "" || strings.Contains(version, "$") {
+		return fmt.Errorf("invalid pepper version %q", version)
+	}
+	if len(secret) < minPepperLength {
+		return fmt.Errorf("pepper %q must be at least %d bytes", version, minPepperLength)
+	}
+	p.secrets[version] = secret
+	return nil
+}
+
+// apply returns the HMAC-SHA256 of the password keyed by the pepper, encoded
+// so it stays within the 72 bytes bcrypt uses.
+func (p *Peppers) apply(version, password string) (string, error) {
+	secret, ok := p.secrets[version]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownPepper, version)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// PepperedHasher peppers passwords before handing them to another hasher.
+// Its hashes are the ones of the inner hasher prefixed with the pepper
+// version, e.g. $pepper$2$argon2id$v=19$...
+type PepperedHasher struct {
+	inner   Hasher
+	peppers *Peppers
+}
+
+func NewPepperedHasher(inner Hasher, peppers *Peppers) *PepperedHasher {
+	return &PepperedHasher{inner: inner, peppers: peppers}
+}
+
+func (h *PepperedHasher) Hash(password string) (string, error) {
+	peppered, err := h.peppers.apply(h.peppers.current, password)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := h.inner.Hash(peppered)
+	if err != nil {
+		return "", err
+	}
+
+	return pepperPrefix + h.peppers.current + encoded, nil
+}
+
+// Verify also accepts hashes made before the pepper was enabled.
+func (h *PepperedHasher) Verify(password, encoded string) (bool, error) {
+	version, inner, ok := splitPeppered(encoded)
+	if !ok {
+		return h.inner.Verify(password, encoded)
+	}
+
+	peppered, err := h.peppers.apply(version, password)
+	if err != nil {
+		return false, err
+	}
+
+	return h.inner.Verify(peppered, inner)
+}
+
+func (h *PepperedHasher) NeedsRehash(encoded string) bool {
+	version, inner, ok := splitPeppered(encoded)
+	if !ok || version != h.peppers.current {
+		return true
+	}
+
+	return h.inner.NeedsRehash(inner)
+}
+
+// splitPeppered returns the pepper version and the inner hash of a peppered
+// hash.
+func splitPeppered(encoded string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(encoded, pepperPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	version, inner, ok := strings.Cut(rest, "$")
+	if !ok {
+		return "", "", false
+	}
+
+	return version, "$" + inner, true
+}
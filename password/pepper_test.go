@@ -0,0 +1,135 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	testPepper     = []byte("0123456789abcdef0123456789abcdef")
+	previousPepper = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func newTestPeppers(t *testing.T, version string, secret []byte, previous map[string][]byte) *Peppers {
+	t.Helper()
+
+	peppers, err := NewPeppers(version, secret, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peppers
+}
+
+func TestPepperedHasher(t *testing.T) {
+	inner := NewArgon2idHasher(Argon2idParams{Memory: 1024, Iterations: 1})
+	hasher := NewPepperedHasher(inner, newTestPeppers(t, "1", testPepper, nil))
+
+	encoded, err := hasher.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$pepper$1$argon2id$") {
+		t.Fatalf("unexpected hash %s", encoded)
+	}
+
+	if ok, err := hasher.Verify("correct-horse-battery", encoded); err != nil || !ok {
+		t.Fatalf("expected the password to match, got %v %v", ok, err)
+	}
+	if ok, _ := hasher.Verify("wrong-password", encoded); ok {
+		t.Fatal("expected a wrong password not to match")
+	}
+	if hasher.NeedsRehash(encoded) {
+		t.Fatal("expected a current hash not to need a rehash")
+	}
+
+	// The stored hash alone does not verify the password.
+	_, stored, _ := splitPeppered(encoded)
+	if ok, _ := inner.Verify("correct-horse-battery", stored); ok {
+		t.Fatal("expected the inner hash to need the pepper")
+	}
+
+	// Other secrets do not verify the hash.
+	other := NewPepperedHasher(inner, newTestPeppers(t, "1", previousPepper, nil))
+	if ok, _ := other.Verify("correct-horse-battery", encoded); ok {
+		t.Fatal("expected another pepper not to match")
+	}
+}
+
+func TestPepperRotation(t *testing.T) {
+	inner := NewBcryptHasher(4)
+	previous := NewPepperedHasher(inner, newTestPeppers(t, "1", previousPepper, nil))
+	hasher := NewPepperedHasher(inner, newTestPeppers(t, "2", testPepper, map[string][]byte{"1": previousPepper}))
+
+	unpeppered, err := inner.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := previous.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := hasher.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		encoded     string
+		needsRehash bool
+	}{
+		{"unpeppered", unpeppered, true},
+		{"previous pepper", old, true},
+		{"current pepper", current, false},
+	}
+
+	for _, tt := range tests {
+		if ok, err := hasher.Verify("correct-horse-battery", tt.encoded); err != nil || !ok {
+			t.Fatalf("%s: expected the password to match, got %v %v", tt.name, ok, err)
+		}
+		if hasher.NeedsRehash(tt.encoded) != tt.needsRehash {
+			t.Fatalf("%s: expected NeedsRehash to be %t", tt.name, tt.needsRehash)
+		}
+	}
+
+	// Retired versions can no longer be verified.
+	retired := NewPepperedHasher(inner, newTestPeppers(t, "2", testPepper, nil))
+	if _, err := retired.Verify("correct-horse-battery", old); !errors.Is(err, ErrUnknownPepper) {
+		t.Fatalf("expected ErrUnknownPepper, got %v", err)
+	}
+}
+
+func TestPepperLongPasswords(t *testing.T) {
+	hasher := NewPepperedHasher(NewBcryptHasher(4), newTestPeppers(t, "1", testPepper, nil))
+	long := strings.Repeat("a", 100)
+
+	encoded, err := hasher.Hash(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bcrypt ignores bytes after the 72nd, the HMAC does not.
+	if ok, _ := hasher.Verify(strings.Repeat("a", 99)+"b", encoded); ok {
+		t.Fatal("expected passwords differing after 72 bytes not to match")
+	}
+}
+
+func TestNewPeppers(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		secret   []byte
+		previous map[string][]byte
+	}{
+		{"empty version", "", testPepper, nil},
+		{"version with separator", "1$2", testPepper, nil},
+		{"short secret", "1", []byte("short"), nil},
+		{"short previous secret", "2", testPepper, map[string][]byte{"1": []byte("short")}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewPeppers(tt.version, tt.secret, tt.previous); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package password
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleUppercase        = "uppercase"
+	RuleLowercase        = "lowercase"
+	RuleDigit            = "digit"
+	RuleSymbol           = "symbol"
+	RuleCharacterClasses = "character_classes"
+	RuleUserInfo         = "user_info"
+	RuleStrength         = "strength"
+	RuleBreached         = "breached"
+	RuleHistory          = "history"
+)
+
+// minUserInfoFragment is the shortest user detail, in characters, looked for
+// in passwords.
+const minUserInfoFragment = 3
+
+// Policy is the set of rules passwords chosen by users must follow. Lengths
+// are counted in characters. Zero values disable a rule.
+type Policy struct {
+	MinLength int
+	MaxLength int
+
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+
+	// MinCharacterClasses is how many of lowercase, uppercase, digits and
+	// symbols the password must mix.
+	MinCharacterClasses int
+
+	// DisallowUserInfo rejects passwords containing the email, first name or
+	// last name of the user.
+	DisallowUserInfo bool
+
+	// MinEntropy is the minimum estimated strength, in bits. See Entropy.
+	MinEntropy float64
+
+	// Breached rejects passwords exposed by data breaches.
+	Breached BreachChecker
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength: 8,
+		MaxLength: 128,
+	}
+}
+
+// Violation is a rule a password does not follow.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule a password does not follow.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Check returns a *PolicyError when the password breaks rules, or the error
+// of the breach checker. userInfo are the details of the user, such as email
+// and names, checked by DisallowUserInfo.
+func (p *Policy) Check(password string, userInfo ...string) error {
+	var violations []Violation
+	fail := func(rule, format string, args ...any) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		fail(RuleMinLength, "password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		fail(RuleMaxLength, "password must be at most %d characters", p.MaxLength)
+	}
+
+	classes := characterClasses(password)
+	if p.RequireUppercase && !classes.upper {
+		fail(RuleUppercase, "password must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !classes.lower {
+		fail(RuleLowercase, "password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !classes.digit {
+		fail(RuleDigit, "password must contain a digit")
+	}
+	if p.RequireSymbol && !classes.symbol {
+		fail(RuleSymbol, "password must contain a symbol")
+	}
+	if p.MinCharacterClasses > 0 && classes.count() < p.MinCharacterClasses {
+		fail(RuleCharacterClasses, "password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharacterClasses)
+	}
+
+	if p.DisallowUserInfo && containsUserInfo(password, userInfo) {
+		fail(RuleUserInfo, "password must not contain your email address or name")
+	}
+
+	if p.MinEntropy > 0 && Entropy(password) < p.MinEntropy {
+		fail(RuleStrength, "password is too easy to guess")
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Breached(password)
+		if err != nil {
+			return fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+		if breached {
+			fail(RuleBreached, "password has appeared in a data breach")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Entropy estimates the strength of a password in bits, from the size of
+// the character classes it uses. Repeated characters and runs such as "abc"
+// or "321" count for half a character. It is a coarse estimate that does not
+// know about dictionary words.
+func Entropy(password string) float64 {
+	classes := characterClasses(password)
+
+	pool := 0
+	if classes.lower {
+		pool += 26
+	}
+	if classes.upper {
+		pool += 26
+	}
+	if classes.digit {
+		pool += 10
+	}
+	if classes.symbol {
+		pool += 33
+	}
+	if classes.other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	var length float64
+	var previous rune
+	for i, r := range []rune(password) {
+		delta := r - previous
+		if i > 0 && (delta >= -1 && delta <= 1) {
+			length += 0.5
+		} else {
+			length++
+		}
+		previous = r
+	}
+
+	return length * math.Log2(float64(pool))
+}
+
+type classSet struct {
+	lower, upper, digit, symbol, other bool
+}
+
+func (c classSet) count() int {
+	count := 0
+	for _, present := range []bool{c.lower, c.upper, c.digit, c.symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+func characterClasses(password string) classSet {
+	var classes classSet
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			classes.lower = true
+		case r >= 'A' && r <= 'Z':
+			classes.upper = true
+		case r >= '0' && r <= '9':
+			classes.digit = true
+		case r < unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSymbol(r) || r == ' '):
+			classes.symbol = true
+		case unicode.IsLower(r):
+			classes.lower, classes.other = true, true
+		case unicode.IsUpper(r):
+			classes.upper, classes.other = true, true
+		default:
+			classes.other = true
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				classes.symbol = true
+			}
+		}
+	}
+	return classes
+}
+
+// containsUserInfo reports whether the password contains one of the user
+// details. Emails are split into their local part and domain name.
+func containsUserInfo(password string, userInfo []string) bool {
+	lowered := strings.ToLower(password)
+
+	var fragments []string
+	for _, info := range userInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		if local, domain, ok := strings.Cut(info, "@"); ok {
+			name, _, _ := strings.Cut(domain, ".")
+			fragments = append(fragments, local, name)
+			continue
+		}
+		fragments = append(fragments, strings.Fields(info)...)
+	}
+
+	for _, fragment := range fragments {
+		if utf8.RuneCountInString(fragment) >= minUserInfoFragment && strings.Contains(lowered, fragment) {
+			return true
+		}
+	}
+	return false
+}
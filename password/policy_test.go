@@ -0,0 +1,76 @@
+package password
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func violatedRules(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a *PolicyError, got %v", err)
+	}
+
+	rules := make([]string, len(policyErr.Violations))
+	for i, violation := range policyErr.Violations {
+		rules[i] = violation.Rule
+	}
+	return rules
+}
+
+func TestPolicyCheck(t *testing.T) {
+	userInfo := []string{"jane.doe@example.com", "Jane", "Jo Berg"}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		expected []string
+	}{
+		{"default", *DefaultPolicy(), "correct-horse-battery", nil},
+		{"too short", *DefaultPolicy(), "short", []string{RuleMinLength}},
+		{"too long", Policy{MaxLength: 10}, "correct-horse-battery", []string{RuleMaxLength}},
+		{"characters not bytes", Policy{MinLength: 8, MaxLength: 8}, "éééééééé", nil},
+		{"uppercase", Policy{RequireUppercase: true}, "lowercase", []string{RuleUppercase}},
+		{"lowercase", Policy{RequireLowercase: true}, "UPPERCASE", []string{RuleLowercase}},
+		{"digit", Policy{RequireDigit: true}, "no-digits", []string{RuleDigit}},
+		{"symbol", Policy{RequireSymbol: true}, "NoSymbols1", []string{RuleSymbol}},
+		{"every class", Policy{RequireUppercase: true, RequireLowercase: true, RequireDigit: true, RequireSymbol: true}, "Abcdef1!", nil},
+		{"mixed classes", Policy{MinCharacterClasses: 3}, "lowercase1", []string{RuleCharacterClasses}},
+		{"enough classes", Policy{MinCharacterClasses: 3}, "Lowercase1", nil},
+		{"email local part", Policy{DisallowUserInfo: true}, "my-JANE.DOE-password", []string{RuleUserInfo}},
+		{"email domain", Policy{DisallowUserInfo: true}, "i-work-at-example", []string{RuleUserInfo}},
+		{"name", Policy{DisallowUserInfo: true}, "berg-is-my-name", []string{RuleUserInfo}},
+		{"short fragments", Policy{DisallowUserInfo: true}, "jo-is-too-short", nil},
+		{"weak", Policy{MinEntropy: 50}, "aaaaaaaaaa", []string{RuleStrength}},
+		{"strong", Policy{MinEntropy: 50}, "correct-horse-battery", nil},
+		{"every violation", Policy{MinLength: 8, RequireDigit: true, RequireSymbol: true}, "short", []string{RuleMinLength, RuleDigit, RuleSymbol}},
+	}
+
+	for _, tt := range tests {
+		if rules := violatedRules(t, tt.policy.Check(tt.password, userInfo...)); !slices.Equal(rules, tt.expected) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.expected, rules)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	ordered := []string{"", "abcdefgh", "hunter22", "correct-horse-battery", "c0rrect-H0rse-b@ttery"}
+
+	for i := 1; i < len(ordered); i++ {
+		if Entropy(ordered[i]) <= Entropy(ordered[i-1]) {
+			t.Fatalf("expected %q to be stronger than %q", ordered[i], ordered[i-1])
+		}
+	}
+
+	// Repeats and runs count for half a character.
+	if Entropy("aaaaaaaa") != Entropy("abcdefgh") || Entropy("abcdefgh") != Entropy("acegikmo")/2+Entropy("a")/2 {
+		t.Fatalf("unexpected entropy for a run: %f", Entropy("abcdefgh"))
+	}
+}
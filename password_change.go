@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/password/history"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+// ChangePasswordRequest carries the current password, which users without a
+// password leave empty.
+type ChangePasswordRequest struct {
+	CurrentPassword     string `json:"current_password"`
+	NewPassword         string `json:"new_password" validate:"required"`
+	RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+}
+
+// passwordChangeRequired reports whether the user must change their password
+// before getting an unrestricted token.
+func passwordChangeRequired(user *models.User, config Config) bool {
+	if user.PasswordChangeRequired() {
+		return true
+	}
+	// Users logging in with magic links or passkeys have no password to
+	// expire.
+	return config.PasswordExpiryDays > 0 && user.Password != nil &&
+		user.PasswordExpired(time.Duration(config.PasswordExpiryDays)*24*time.Hour)
+}
+
+// sendPasswordPolicyError lists the rules of the password policy a new
+// password breaks.
+func sendPasswordPolicyError(c *fiber.Ctx, err error) error {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		return response.SendError(c, fiber.StatusInternalServerError, "failed to check password")
+	}
+
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":      "password does not meet the password policy",
+		"violations": policyErr.Violations,
+	})
+}
+
+// handleChangePassword requires the current password so that a stolen access
+// token is not enough to take over the account. Users without a password,
+// setting their first one, need a recent login instead. resets may be nil
+// when no mailer is configured. A token restricted to the password change is
+// replaced by an unrestricted pair.
+func handleChangePassword(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, resets *actionTokenStore, passwordHistory *history.Store, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ChangePasswordRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change password")
+		}
+
+		if fiberErr := confirmIdentity(c, config.PasswordHasher, user, req.CurrentPassword); fiberErr != nil {
+			return response.SendError(c, fiberErr.Code, fiberErr.Message)
+		}
+
+		if err := config.PasswordPolicy.Check(req.NewPassword, user.Email, user.Firstname, user.Lastname); err != nil {
+			return sendPasswordPolicyError(c, err)
+		}
+
+		if user.CheckPassword(config.PasswordHasher, req.NewPassword) {
+			return response.SendError(c, fiber.StatusBadRequest, "new password must be different from the current password")
+		}
+
+		if err := passwordHistory.Check(ctx, db, userID, req.NewPassword); err != nil {
+			return sendPasswordPolicyError(c, err)
+		}
+
+		hashed := models.User{Password: &req.NewPassword}
+		if err := hashed.HashPassword(config.PasswordHasher); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to hash password")
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change password")
+		}
+		defer tx.Rollback(ctx)
+
+		if err := passwordHistory.RecordCurrent(ctx, tx, userID); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change password")
+		}
+
+		if err := updatePassword(ctx, db, tx, userID, *hashed.Password); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change password")
+		}
+
+		if resets != nil {
+			if err := resets.invalidateUser(ctx, tx, userID); err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to change password")
+			}
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to change password")
+		}
+
+		claims, _ := authcontext.GetClaims(c)
+		restricted := claims != nil && claims.Restriction == tokens.RestrictionPasswordChange
+
+		switch {
+		case req.RevokeOtherSessions:
+			if err := revokeSessions(ctx, tokenService, refreshTokens, userID.String()); err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke sessions")
+			}
+		case restricted:
+			if err := tokenService.Revoke(ctx, claims); err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke token")
+			}
+		default:
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		changedAt := time.Now()
+		user.PasswordChangedAt = &changedAt
+		user.MustChangePassword = new(bool)
+
+		// The new pair keeps the authentication of the current session.
+		var auth tokens.Authentication
+		if claims != nil {
+			auth = claims.Authentication()
+		}
+
+		issued, err := issueTokens(ctx, tokenService, refreshTokens, user, auth, config)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, issued)
+	}
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func TestChangePassword(t *testing.T) {
+	app, _, db := newTestApp(t, nil)
+	createTestUser(t, db, "jane@example.com", "user")
+	token, refreshToken := login(t, app, "jane@example.com", testPassword)
+
+	tests := []struct {
+		name     string
+		token    string
+		body     map[string]interface{}
+		expected int
+	}{
+		{"unauthenticated", "", map[string]interface{}{"current_password": testPassword, "new_password": "another-password"}, fiber.StatusUnauthorized},
+		{"wrong current password", token, map[string]interface{}{"current_password": "wrong-password", "new_password": "another-password"}, fiber.StatusForbidden},
+		{"policy violation", token, map[string]interface{}{"current_password": testPassword, "new_password": "short"}, fiber.StatusBadRequest},
+		{"same password", token, map[string]interface{}{"current_password": testPassword, "new_password": testPassword}, fiber.StatusBadRequest},
+		{"changed", token, map[string]interface{}{"current_password": testPassword, "new_password": "another-password"}, fiber.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		status, result := request(t, app, "POST", "/auth/password/change", tt.token, tt.body)
+		if status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	login(t, app, "jane@example.com", "another-password")
+
+	if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}); status != fiber.StatusOK {
+		t.Fatalf("expected sessions to be kept, got %d", status)
+	}
+}
+
+func TestChangePasswordWithoutPassword(t *testing.T) {
+	app, plugin, db := newTestApp(t, nil)
+	user := createTestUserWithoutPassword(t, db, "jane@example.com")
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, "UPDATE users SET must_change_password = ? WHERE id = ?", true, user.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := tokens.NewAuthentication(tokens.AMREmail)
+	stale.Time = time.Now().Add(-mfaEnrollmentMaxAge - time.Minute)
+
+	tests := []struct {
+		name     string
+		auth     tokens.Authentication
+		expected int
+	}{
+		{"stale login", stale, fiber.StatusUnauthorized},
+		{"recent login", tokens.NewAuthentication(tokens.AMREmail), fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		token, err := plugin.TokenService().GenerateToken(ctx, user, WithAuthentication(tt.auth), WithRestriction(tokens.RestrictionPasswordChange))
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, result := request(t, app, "POST", "/auth/password/change", token, map[string]string{"new_password": "a-new-password"})
+		if status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	// The first password lifts the required change.
+	token, _ := login(t, app, "jane@example.com", "a-new-password")
+	if claims, err := plugin.TokenService().ValidateToken(token); err != nil || claims.Restriction != "" {
+		t.Fatalf("expected an unrestricted token, got %v", err)
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	app, _, db := newTestApp(t, nil)
+	createTestUser(t, db, "jane@example.com", "user")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	otherToken, otherRefreshToken := login(t, app, "jane@example.com", testPassword)
+
+	start := time.Now()
+	status, result := request(t, app, "POST", "/auth/password/change", token, map[string]interface{}{
+		"current_password":      testPassword,
+		"new_password":          "another-password",
+		"revoke_other_sessions": true,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("password change took %s", elapsed)
+	}
+
+	for _, revoked := range []string{token, otherToken} {
+		if status, _ := request(t, app, "POST", "/auth/logout", revoked, nil); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected a revoked token to be rejected, got %d", status)
+		}
+	}
+	if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": otherRefreshToken}); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected a revoked refresh token to be rejected, got %d", status)
+	}
+
+	// The replacement pair is valid right away.
+	newToken, _ := result["token"].(string)
+	newRefreshToken, _ := result["refresh_token"].(string)
+	if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": newRefreshToken}); status != fiber.StatusOK {
+		t.Fatalf("expected the new refresh token to be accepted, got %d", status)
+	}
+	if status, _ := request(t, app, "POST", "/auth/logout", newToken, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected the new token to be accepted, got %d", status)
+	}
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	app, _, db := newTestApp(t, nil)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	adminID := createTestUser(t, db, "admin@example.com", "admin")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	adminToken, _ := login(t, app, "admin@example.com", testPassword)
+
+	for name, tt := range map[string]struct {
+		token string
+		id    string
+	}{
+		"own password":             {token, userID.String()},
+		"own password as an admin": {adminToken, adminID.String()},
+	} {
+		status, result := request(t, app, "PUT", "/users/"+tt.id, tt.token, map[string]string{"password": "another-password"})
+		if status != fiber.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d %v", name, status, result)
+		}
+	}
+	login(t, app, "jane@example.com", testPassword)
+
+	// Other fields are still updated without the current password.
+	if status, result := request(t, app, "PUT", "/users/"+userID.String(), token, map[string]string{"firstname": "Janet"}); status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+
+	// Admins may set a temporary password for another user.
+	if status, result := request(t, app, "PUT", "/users/"+userID.String(), adminToken, map[string]string{"password": "temporary-password"}); status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+	login(t, app, "jane@example.com", "temporary-password")
+}
+
+func TestPasswordChangeRequired(t *testing.T) {
+	app, plugin, db := newTestApp(t, map[string]interface{}{"password_expiry_days": 30})
+	expiredID := createTestUser(t, db, "jane@example.com", "user")
+	flaggedID := createTestUser(t, db, "john@example.com", "user")
+	createTestUser(t, db, "joe@example.com", "user")
+
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, "UPDATE users SET password_changed_at = ? WHERE id = ?", time.Now().AddDate(0, 0, -31), expiredID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "UPDATE users SET must_change_password = ? WHERE id = ?", true, flaggedID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email    string
+		id       string
+		required bool
+	}{
+		{"jane@example.com", expiredID.String(), true},
+		{"john@example.com", flaggedID.String(), true},
+		{"joe@example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		status, result := request(t, app, "POST", "/auth/login", "", map[string]string{"email": tt.email, "password": testPassword})
+		if status != fiber.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %v", tt.email, status, result)
+		}
+		if required, _ := result["password_change_required"].(bool); required != tt.required {
+			t.Fatalf("%s: expected password_change_required to be %t, got %v", tt.email, tt.required, result)
+		}
+
+		token, _ := result["token"].(string)
+		refreshToken, _ := result["refresh_token"].(string)
+		claims, err := plugin.TokenService().ValidateToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (claims.Restriction == tokens.RestrictionPasswordChange) != tt.required {
+			t.Fatalf("%s: unexpected restriction %q", tt.email, claims.Restriction)
+		}
+		if !tt.required {
+			continue
+		}
+
+		// The token only allows to change the password, even once refreshed.
+		if status, _ := request(t, app, "PUT", "/users/"+tt.id, token, map[string]string{"firstname": "Janet"}); status != fiber.StatusForbidden {
+			t.Fatalf("%s: expected a restricted token to be rejected, got %d", tt.email, status)
+		}
+		status, result = request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken})
+		if status != fiber.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %v", tt.email, status, result)
+		}
+		if claims, err := plugin.TokenService().ValidateToken(result["token"].(string)); err != nil || claims.Restriction != tokens.RestrictionPasswordChange {
+			t.Fatalf("%s: expected a refreshed token to stay restricted, got %v", tt.email, err)
+		}
+
+		// Changing the password replaces the restricted session.
+		status, result = request(t, app, "POST", "/auth/password/change", token, map[string]string{"current_password": testPassword, "new_password": "another-password"})
+		if status != fiber.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %v", tt.email, status, result)
+		}
+		newToken, _ := result["token"].(string)
+		loginToken, _ := login(t, app, tt.email, "another-password")
+		for _, token := range []string{newToken, loginToken} {
+			if claims, err := plugin.TokenService().ValidateToken(token); err != nil || claims.Restriction != "" {
+				t.Fatalf("%s: expected an unrestricted token once changed, got %v", tt.email, err)
+			}
+		}
+	}
+
+	if err := NewPlugin().Initialize(map[string]interface{}{"jwt_secret": testSecret, "password_expiry_days": -1}); err == nil {
+		t.Fatal("expected a negative password_expiry_days to be rejected")
+	}
+}
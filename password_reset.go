@@ -0,0 +1,210 @@
+package auth
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password/history"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// mailTimeout bounds the work done in the background after the forgot
+// password response has been sent.
+const mailTimeout = 30 * time.Second
+
+func newPasswordResetStore(db database.Database, ttl int) *actionTokenStore {
+	return &actionTokenStore{
+		db:    db,
+		table: "password_reset_tokens",
+		ttl:   time.Duration(ttl) * time.Second,
+	}
+}
+
+// handleForgotPassword always answers the same way, and sends the email in
+// the background, so the response never reveals whether the email exists.
+func handleForgotPassword(db database.Database, resets *actionTokenStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ForgotPasswordRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Email == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "email is required")
+		}
+
+		go func(email, locale string) {
+			ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), mailTimeout)
+			defer cancel()
+
+			if err := sendPasswordReset(ctx, db, resets, config, email, locale); err != nil {
+				log.Printf("[gorest-auth] password reset: %v", err)
+			}
+		}(req.Email, requestLocale(c))
+
+		return response.SendFormatted(c, fiber.StatusAccepted, fiber.Map{
+			"message": "if an account exists for this email, a password reset link has been sent",
+		})
+	}
+}
+
+func handleResetPassword(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, resets *actionTokenStore, passwordHistory *history.Store, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ResetPasswordRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		if req.Token == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "token is required")
+		}
+
+		ctx := c.Context()
+
+		resetUserID, err := resets.peek(ctx, req.Token)
+		if errors.Is(err, ErrActionTokenInvalid) || errors.Is(err, ErrActionTokenExpired) {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid or expired reset token")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+
+		owner, err := getUserByID(ctx, db, resetUserID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid or expired reset token")
+		}
+
+		if err := config.PasswordPolicy.Check(req.Password, owner.Email, owner.Firstname, owner.Lastname); err != nil {
+			return sendPasswordPolicyError(c, err)
+		}
+
+		if err := passwordHistory.Check(ctx, db, owner.ID, req.Password); err != nil {
+			return sendPasswordPolicyError(c, err)
+		}
+
+		user := models.User{Password: &req.Password}
+		if err := user.HashPassword(config.PasswordHasher); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to hash password")
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+		defer tx.Rollback(ctx)
+
+		userID, err := resets.consume(ctx, tx, req.Token)
+		if errors.Is(err, ErrActionTokenInvalid) || errors.Is(err, ErrActionTokenExpired) {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid or expired reset token")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+
+		if err := passwordHistory.RecordCurrent(ctx, tx, userID); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+
+		if err := updatePassword(ctx, db, tx, userID, *user.Password); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+
+		if err := resets.invalidateUser(ctx, tx, userID); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to reset password")
+		}
+
+		if err := revokeSessions(ctx, tokenService, refreshTokens, userID.String()); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke sessions")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func sendPasswordReset(ctx stdcontext.Context, db database.Database, resets *actionTokenStore, config Config, email, locale string) error {
+	user, err := getUser(ctx, db, query.Eq("email", email))
+	if err != nil {
+		// Unknown emails are silently ignored.
+		return nil
+	}
+
+	// The previous email is still on its way.
+	recent, err := resets.issuedWithin(ctx, user.ID, time.Duration(config.PasswordResetCooldown)*time.Second)
+	if err != nil || recent {
+		return err
+	}
+
+	token, err := resets.issue(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	link, err := actionURL(config.PasswordResetURL, token)
+	if err != nil {
+		return err
+	}
+
+	return sendMail(ctx, config, mailer.TemplatePasswordReset, locale, user, map[string]any{
+		"Link":             link,
+		"ExpiresInMinutes": int(resets.ttl.Minutes()),
+	})
+}
+
+// updatePassword also lifts a required password change.
+func updatePassword(ctx stdcontext.Context, db database.Database, exec queryExecutor, userID uuid.UUID, passwordHash string) error {
+	now := time.Now()
+	queryStr, args, err := query.New(db.Dialect()).
+		Update("users").
+		Set("password", passwordHash).
+		Set("password_changed_at", now).
+		Set("must_change_password", false).
+		Set("updated_at", now).
+		Where(query.Eq("id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// actionURL appends the token to the link configured for an email flow.
+func actionURL(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid link URL: %w", err)
+	}
+
+	values := link.Query()
+	values.Set("token", token)
+	link.RawQuery = values.Encode()
+
+	return link.String(), nil
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+)
+
+func newTestResetApp(t *testing.T) (*fiber.App, *AuthPlugin, *mailer.MemoryMailer) {
+	t.Helper()
+
+	mail := mailer.NewMemoryMailer()
+	app, plugin, _ := newTestApp(t, map[string]interface{}{
+		"mailer":             mail,
+		"mail_from":          "noreply@example.com",
+		"password_reset_url": "https://app.example.com/reset-password",
+	})
+	return app, plugin, mail
+}
+
+func TestPasswordReset(t *testing.T) {
+	app, plugin, mail := newTestResetApp(t)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+	token, refreshToken := login(t, app, "jane@example.com", testPassword)
+
+	status, result := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"})
+	if status != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d %v", status, result)
+	}
+
+	message := waitForMail(t, mail, 0)
+	if len(message.To) != 1 || message.To[0] != "jane@example.com" || message.From != "noreply@example.com" {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+	resetToken := mailToken(t, message)
+
+	tests := []struct {
+		name     string
+		body     map[string]string
+		expected int
+	}{
+		{"missing token", map[string]string{"password": "a-new-password"}, fiber.StatusBadRequest},
+		{"unknown token", map[string]string{"token": "unknown", "password": "a-new-password"}, fiber.StatusBadRequest},
+		{"weak password", map[string]string{"token": resetToken, "password": "short"}, fiber.StatusBadRequest},
+		{"valid", map[string]string{"token": resetToken, "password": "a-new-password"}, fiber.StatusNoContent},
+		{"used token", map[string]string{"token": resetToken, "password": "another-password"}, fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if status, result := request(t, app, "POST", "/auth/password/reset", "", tt.body); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	// Existing sessions are revoked.
+	if status, _ := request(t, app, "POST", "/auth/logout", token, nil); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the access token to be revoked, got %d", status)
+	}
+	if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the refresh token to be revoked, got %d", status)
+	}
+
+	if status, _ := request(t, app, "POST", "/auth/login", "", map[string]string{"email": "jane@example.com", "password": testPassword}); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the old password to be rejected, got %d", status)
+	}
+	login(t, app, "jane@example.com", "a-new-password")
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	app, plugin, mail := newTestResetApp(t)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+
+	_, known := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"})
+	status, unknown := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "john@example.com"})
+	if status != fiber.StatusAccepted || unknown["message"] != known["message"] {
+		t.Fatalf("expected the same response for an unknown email, got %d %v", status, unknown)
+	}
+
+	resets := newPasswordResetStore(plugin.db, 3600)
+	if err := sendPasswordReset(context.Background(), plugin.db, resets, plugin.config, "john@example.com", "en"); err != nil {
+		t.Fatalf("expected an unknown email to be ignored, got %v", err)
+	}
+	waitForMail(t, mail, 0)
+	for _, message := range mail.Messages() {
+		if message.To[0] != "jane@example.com" {
+			t.Fatalf("unexpected message to %v", message.To)
+		}
+	}
+
+	if status, _ := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 without an email, got %d", status)
+	}
+}
+
+func TestForgotPasswordCooldown(t *testing.T) {
+	app, plugin, mail := newTestResetApp(t)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+	createTestUser(t, plugin.db, "john@example.com", "user")
+
+	request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"})
+	firstToken := mailToken(t, waitForMail(t, mail, 0))
+
+	// The response does not tell whether an email was sent.
+	if status, _ := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d", status)
+	}
+	request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "john@example.com"})
+	waitForMail(t, mail, 1)
+
+	if messages := mail.Messages(); len(messages) != 2 || messages[1].To[0] != "john@example.com" {
+		t.Fatalf("expected the second email to be skipped, got %d messages", len(messages))
+	}
+
+	// The skipped request does not invalidate the link already sent.
+	if status, result := request(t, app, "POST", "/auth/password/reset", "", map[string]string{"token": firstToken, "password": "a-new-password"}); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d %v", status, result)
+	}
+}
+
+func TestForgotPasswordIPLimit(t *testing.T) {
+	app, _, _ := newTestApp(t, map[string]interface{}{
+		"mailer":                  "memory",
+		"password_reset_url":      "https://app.example.com/reset-password",
+		"password_reset_ip_limit": 2,
+	})
+
+	for i, expected := range []int{fiber.StatusAccepted, fiber.StatusAccepted, fiber.StatusTooManyRequests} {
+		email := fmt.Sprintf("user-%d@example.com", i)
+		if status, result := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": email}); status != expected {
+			t.Fatalf("request %d: expected %d, got %d %v", i+1, expected, status, result)
+		}
+	}
+}
+
+func TestPasswordResetTokens(t *testing.T) {
+	app, plugin, _ := newTestResetApp(t)
+	userID := createTestUser(t, plugin.db, "jane@example.com", "user")
+	ctx := context.Background()
+
+	expired, err := newPasswordResetStore(plugin.db, -1).issue(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := request(t, app, "POST", "/auth/password/reset", "", map[string]string{"token": expired, "password": "a-new-password"}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected an expired token to be rejected, got %d", status)
+	}
+
+	// Only the latest link works.
+	resets := newPasswordResetStore(plugin.db, 3600)
+	first, err := resets.issue(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := resets.issue(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := request(t, app, "POST", "/auth/password/reset", "", map[string]string{"token": first, "password": "a-new-password"}); status != fiber.StatusBadRequest {
+		t.Fatalf("expected a superseded token to be rejected, got %d", status)
+	}
+	if status, _ := request(t, app, "POST", "/auth/password/reset", "", map[string]string{"token": second, "password": "a-new-password"}); status != fiber.StatusNoContent {
+		t.Fatalf("expected the latest token to be accepted, got %d", status)
+	}
+
+	var stored int
+	if err := plugin.db.QueryRow(ctx, "SELECT COUNT(*) FROM password_reset_tokens WHERE token_hash = ?", second).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatal("expected only a digest of the token to be stored")
+	}
+}
+
+func TestPasswordResetTokensPurge(t *testing.T) {
+	db := newTestDatabase(t)
+	jane := createTestUser(t, db, "jane@example.com", "user")
+	john := createTestUser(t, db, "john@example.com", "user")
+	resets := newPasswordResetStore(db, 3600)
+	ctx := context.Background()
+
+	if _, err := newPasswordResetStore(db, -1).issue(ctx, jane); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := resets.issue(ctx, jane); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := resets.issue(ctx, john); err != nil {
+		t.Fatal(err)
+	}
+
+	// Purging deletes the expired and used tokens.
+	if err := resets.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM password_reset_tokens").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Fatalf("expected 2 tokens to be kept, got %d", stored)
+	}
+}
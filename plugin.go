@@ -1,18 +1,30 @@
 package auth
 
 import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
 	"github.com/nicolasbonnici/gorest-auth/middleware"
 	authmigrations "github.com/nicolasbonnici/gorest-auth/migrations"
 	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/revocation"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/plugin"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthPlugin struct {
 	config Config
 	db     database.Database
-	jwt    *JWTService
+	tokens TokenService
 }
 
 func NewPlugin() plugin.Plugin {
@@ -33,28 +45,441 @@ func (p *AuthPlugin) Initialize(config map[string]interface{}) error {
 
 	if jwtSecret, ok := config["jwt_secret"].(string); ok {
 		p.config.JWTSecret = jwtSecret
+		p.config.legacyJWTSecret = true
 	}
 
 	if jwtTTL, ok := config["jwt_ttl"].(int); ok {
 		p.config.JWTTTL = jwtTTL
 	}
 
-	p.jwt = NewJWTService(p.config.JWTSecret, p.config.JWTTTL)
+	if algorithm, ok := config["jwt_algorithm"].(string); ok {
+		p.config.JWTAlgorithm = algorithm
+	}
+
+	if tokenFormat, ok := config["token_format"].(string); ok {
+		p.config.TokenFormat = tokenFormat
+	}
+
+	if localKey, ok := config["paseto_local_key"].(string); ok {
+		p.config.PasetoLocalKey = localKey
+	}
+
+	if privateKey, ok := config["jwt_private_key"].(string); ok {
+		p.config.JWTPrivateKey = privateKey
+	}
+
+	if privateKeyFile, ok := config["jwt_private_key_file"].(string); ok {
+		p.config.JWTPrivateKeyFile = privateKeyFile
+	}
+
+	if publicKey, ok := config["jwt_public_key"].(string); ok {
+		p.config.JWTPublicKey = publicKey
+	}
+
+	if publicKeyFile, ok := config["jwt_public_key_file"].(string); ok {
+		p.config.JWTPublicKeyFile = publicKeyFile
+	}
+
+	if keyID, ok := config["jwt_key_id"].(string); ok {
+		p.config.JWTKeyID = keyID
+	}
+
+	if previousKeys, ok := config["jwt_previous_keys"].([]interface{}); ok {
+		for _, entry := range previousKeys {
+			keyConfig, ok := entry.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid jwt_previous_keys entry: expected a map")
+			}
+			p.config.JWTPreviousKeys = append(p.config.JWTPreviousKeys, parseKeyConfig(keyConfig))
+		}
+	}
+
+	if issuer, ok := config["issuer"].(string); ok {
+		p.config.Issuer = issuer
+	}
+
+	if audience, ok := config["audience"].(string); ok {
+		p.config.Audiences = []string{audience}
+	}
+
+	if audiences, ok := config["audiences"].([]interface{}); ok {
+		for _, audience := range audiences {
+			if value, ok := audience.(string); ok {
+				p.config.Audiences = append(p.config.Audiences, value)
+			}
+		}
+	}
+
+	if clockSkew, ok := config["clock_skew"].(int); ok {
+		p.config.ClockSkew = clockSkew
+	}
+
+	if maxAge, ok := config["discovery_cache_max_age"].(int); ok {
+		p.config.DiscoveryCacheMaxAge = maxAge
+	}
+
+	if refreshTTL, ok := config["refresh_token_ttl"].(int); ok {
+		p.config.RefreshTokenTTL = refreshTTL
+	}
+
+	if provider, ok := config["claims_provider"].(ClaimsProvider); ok {
+		p.config.ClaimsProvider = provider
+	}
+
+	if embedRoles, ok := config["embed_roles"].(bool); ok {
+		p.config.EmbedRoles = embedRoles
+	}
+
+	if roleCacheTTL, ok := config["role_cache_ttl"].(int); ok {
+		p.config.RoleCacheTTL = roleCacheTTL
+	}
+	if p.config.RoleCacheTTL > 0 {
+		p.config.RoleCache = middleware.NewRoleCache(time.Duration(p.config.RoleCacheTTL) * time.Second)
+	}
+
+	if clients, ok := config["introspection_clients"].([]interface{}); ok {
+		for _, entry := range clients {
+			clientConfig, ok := entry.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid introspection_clients entry: expected a map")
+			}
+			client := IntrospectionClient{}
+			client.ID, _ = clientConfig["client_id"].(string)
+			client.Secret, _ = clientConfig["client_secret"].(string)
+			if client.ID == "" || client.Secret == "" {
+				return fmt.Errorf("introspection client requires a client_id and a client_secret")
+			}
+			p.config.IntrospectionClients = append(p.config.IntrospectionClients, client)
+		}
+	}
+
+	m, err := p.mailer(config)
+	if err != nil {
+		return err
+	}
+	p.config.Mailer = m
+
+	if from, ok := config["mail_from"].(string); ok {
+		p.config.MailFrom = from
+	}
+
+	if locale, ok := config["mail_default_locale"].(string); ok {
+		p.config.MailTemplates.SetDefaultLocale(locale)
+	}
+
+	if templatesDir, ok := config["mail_templates_dir"].(string); ok {
+		if err := p.config.MailTemplates.LoadDir(templatesDir); err != nil {
+			return err
+		}
+	}
+
+	if resetURL, ok := config["password_reset_url"].(string); ok {
+		p.config.PasswordResetURL = resetURL
+	}
+
+	if resetTTL, ok := config["password_reset_token_ttl"].(int); ok {
+		p.config.PasswordResetTokenTTL = resetTTL
+	}
+
+	if cooldown, ok := config["password_reset_cooldown"].(int); ok {
+		if cooldown < 0 {
+			return fmt.Errorf("password_reset_cooldown must not be negative")
+		}
+		p.config.PasswordResetCooldown = cooldown
+	}
+
+	if ipLimit, ok := config["password_reset_ip_limit"].(int); ok {
+		if ipLimit < 0 {
+			return fmt.Errorf("password_reset_ip_limit must not be negative")
+		}
+		p.config.PasswordResetIPLimit = ipLimit
+	}
+
+	if verification, ok := config["email_verification"].(string); ok {
+		p.config.EmailVerification = verification
+	}
+
+	if verificationURL, ok := config["email_verification_url"].(string); ok {
+		p.config.EmailVerificationURL = verificationURL
+	}
+
+	if verificationTTL, ok := config["email_verification_token_ttl"].(int); ok {
+		p.config.EmailVerificationTokenTTL = verificationTTL
+	}
+
+	if cooldown, ok := config["email_verification_cooldown"].(int); ok {
+		if cooldown < 0 {
+			return fmt.Errorf("email_verification_cooldown must not be negative")
+		}
+		p.config.EmailVerificationCooldown = cooldown
+	}
+
+	if ipLimit, ok := config["email_verification_ip_limit"].(int); ok {
+		if ipLimit < 0 {
+			return fmt.Errorf("email_verification_ip_limit must not be negative")
+		}
+		p.config.EmailVerificationIPLimit = ipLimit
+	}
+
+	if changeURL, ok := config["email_change_url"].(string); ok {
+		p.config.EmailChangeURL = changeURL
+	}
+
+	if revertURL, ok := config["email_change_revert_url"].(string); ok {
+		p.config.EmailChangeRevertURL = revertURL
+	}
+
+	if changeTTL, ok := config["email_change_token_ttl"].(int); ok {
+		p.config.EmailChangeTokenTTL = changeTTL
+	}
+
+	if revertTTL, ok := config["email_change_revert_ttl"].(int); ok {
+		p.config.EmailChangeRevertTTL = revertTTL
+	}
+
+	switch p.config.EmailVerification {
+	case "":
+	case EmailVerificationOptional, EmailVerificationRestrict, EmailVerificationBlock:
+		if p.config.Mailer == nil {
+			return fmt.Errorf("email_verification requires a mailer")
+		}
+	default:
+		return fmt.Errorf("unknown email_verification mode: %s", p.config.EmailVerification)
+	}
+
+	if err := p.checkLinkURLs(); err != nil {
+		return err
+	}
+
+	hasher, err := p.passwordHasher(config)
+	if err != nil {
+		return err
+	}
+
+	peppers, err := p.passwordPeppers(config)
+	if err != nil {
+		return err
+	}
+	if peppers != nil {
+		hasher = password.NewPepperedHasher(hasher, peppers)
+	}
+
+	p.config.PasswordHasher = hasher
+
+	if issuer, ok := config["mfa_issuer"].(string); ok {
+		p.config.MFAIssuer = issuer
+	}
+
+	if challengeTTL, ok := config["mfa_challenge_ttl"].(int); ok {
+		p.config.MFAChallengeTTL = challengeTTL
+	}
+
+	if key, ok := config["mfa_encryption_key"].(string); ok {
+		p.config.MFAEncryptionKey = key
+	}
+	if _, err := newSecretSealer(p.config.MFAEncryptionKey); err != nil {
+		return err
+	}
+
+	if roles, ok := config["mfa_required_roles"].([]interface{}); ok {
+		known := rbacRoles()
+		for _, role := range roles {
+			value, ok := role.(string)
+			if !ok {
+				continue
+			}
+			if !slices.Contains(known, value) {
+				return fmt.Errorf("mfa_required_roles: unknown role %q", value)
+			}
+			p.config.MFARequiredRoles = append(p.config.MFARequiredRoles, value)
+		}
+	}
+
+	if maxAge, ok := config["admin_step_up_max_age"].(int); ok {
+		if maxAge < 0 {
+			return fmt.Errorf("admin_step_up_max_age must not be negative")
+		}
+		p.config.AdminStepUpMaxAge = maxAge
+	}
+
+	if rpID, ok := config["webauthn_rp_id"].(string); ok {
+		p.config.WebAuthnRPID = rpID
+	}
+
+	if rpName, ok := config["webauthn_rp_name"].(string); ok {
+		p.config.WebAuthnRPName = rpName
+	}
+
+	if origins, ok := config["webauthn_origins"].([]interface{}); ok {
+		for _, origin := range origins {
+			if value, ok := origin.(string); ok {
+				p.config.WebAuthnOrigins = append(p.config.WebAuthnOrigins, value)
+			}
+		}
+	}
+
+	if timeout, ok := config["webauthn_timeout"].(int); ok {
+		p.config.WebAuthnTimeout = timeout
+	}
+
+	if p.config.WebAuthnRPID != "" {
+		if _, err := p.config.RelyingParty(); err != nil {
+			return err
+		}
+	}
+
+	if magicLink, ok := config["magic_link"].(bool); ok {
+		p.config.MagicLink = magicLink
+	}
+
+	if magicLinkURL, ok := config["magic_link_url"].(string); ok {
+		p.config.MagicLinkURL = magicLinkURL
+	}
+
+	if magicLinkTTL, ok := config["magic_link_ttl"].(int); ok {
+		p.config.MagicLinkTTL = magicLinkTTL
+	}
+
+	if autoRegister, ok := config["magic_link_auto_register"].(bool); ok {
+		p.config.MagicLinkAutoRegister = autoRegister
+	}
+
+	if cooldown, ok := config["magic_link_cooldown"].(int); ok {
+		if cooldown < 0 {
+			return fmt.Errorf("magic_link_cooldown must not be negative")
+		}
+		p.config.MagicLinkCooldown = cooldown
+	}
+
+	if ipLimit, ok := config["magic_link_ip_limit"].(int); ok {
+		if ipLimit < 0 {
+			return fmt.Errorf("magic_link_ip_limit must not be negative")
+		}
+		p.config.MagicLinkIPLimit = ipLimit
+	}
+
+	if p.config.MagicLink && p.config.Mailer == nil {
+		return fmt.Errorf("magic_link requires a mailer")
+	}
+
+	// The link is optional, logins can use the code alone.
+	if p.config.MagicLinkURL != "" {
+		if err := checkLinkURL("magic_link_url", p.config.MagicLinkURL); err != nil {
+			return err
+		}
+	}
+
+	if expiryDays, ok := config["password_expiry_days"].(int); ok {
+		if expiryDays < 0 {
+			return fmt.Errorf("password_expiry_days must not be negative")
+		}
+		p.config.PasswordExpiryDays = expiryDays
+	}
+
+	if depth, ok := config["password_history"].(int); ok {
+		if depth < 0 {
+			return fmt.Errorf("password_history must not be negative")
+		}
+		p.config.PasswordHistory = depth
+	}
+
+	if policy, ok := config["password_policy"].(map[string]interface{}); ok {
+		if err := parsePasswordPolicy(p.config.PasswordPolicy, policy); err != nil {
+			return err
+		}
+	}
+
+	store, err := p.revocationStore(config)
+	if err != nil {
+		return err
+	}
+	p.config.RevocationStore = store
+
+	tokenService, err := NewTokenServiceFromConfig(p.config)
+	if err != nil {
+		return fmt.Errorf("failed to configure token signing key: %w", err)
+	}
+	p.tokens = tokenService
 
 	return nil
 }
 
+// SetClaimsProvider adds custom claims to every token issued from now on.
+func (p *AuthPlugin) SetClaimsProvider(provider ClaimsProvider) {
+	p.config.ClaimsProvider = provider
+	if service, ok := p.tokens.(interface{ SetClaimsProvider(ClaimsProvider) }); ok {
+		service.SetClaimsProvider(provider)
+	}
+}
+
+// Mailer returns the configured mailer, e.g. the MemoryMailer in tests.
+func (p *AuthPlugin) Mailer() mailer.Mailer {
+	return p.config.Mailer
+}
+
+// MailTemplates returns the email templates so applications can override
+// them or add locales.
+func (p *AuthPlugin) MailTemplates() *mailer.Templates {
+	return p.config.MailTemplates
+}
+
+// TokenService returns the service issuing and validating access tokens.
+func (p *AuthPlugin) TokenService() TokenService {
+	return p.tokens
+}
+
+// RotateSigningKey makes key the signing key while keeping the previous one
+// available to validate the tokens it already issued.
+func (p *AuthPlugin) RotateSigningKey(key *SigningKey) error {
+	keys, err := p.keyring()
+	if err != nil {
+		return err
+	}
+	return keys.Rotate(key)
+}
+
+// RetireSigningKey stops accepting tokens signed by the key with the given kid.
+func (p *AuthPlugin) RetireSigningKey(kid string) error {
+	keys, err := p.keyring()
+	if err != nil {
+		return err
+	}
+	return keys.Retire(kid)
+}
+
+func (p *AuthPlugin) keyring() (*Keyring, error) {
+	if service, ok := p.tokens.(interface{ Keyring() *Keyring }); ok && service.Keyring() != nil {
+		return service.Keyring(), nil
+	}
+	return nil, fmt.Errorf("token format %s does not use signing keys", p.config.TokenFormat)
+}
+
+// InvalidateRoles drops the cached roles of the user. Call it whenever the
+// role of a user is changed outside of the plugin endpoints.
+func (p *AuthPlugin) InvalidateRoles(userID string) {
+	if p.config.RoleCache != nil {
+		p.config.RoleCache.Invalidate(userID)
+	}
+}
+
 func (p *AuthPlugin) Handler() fiber.Handler {
-	return middleware.AuthMiddleware(p.jwt, p.db)
+	return middleware.AuthMiddleware(p.tokens, p.db, p.config.MiddlewareOptions()...)
 }
 
 func (p *AuthPlugin) SetupEndpoints(router fiber.Router) error {
+	// JWKS only describes JWT signing keys.
+	if jwtService, ok := p.tokens.(*JWTService); ok {
+		RegisterDiscoveryRoutes(router, jwtService, p.config)
+	}
+
 	if p.db == nil {
 		return nil
 	}
 
-	RegisterAuthRoutes(router, p.db, p.jwt)
-	RegisterUserRoutes(router, p.db, p.jwt)
+	if err := RegisterAuthRoutes(router, p.db, p.tokens, p.config); err != nil {
+		return err
+	}
+	RegisterUserRoutes(router, p.db, p.tokens, p.config)
 	return nil
 }
 
@@ -78,3 +503,288 @@ func (p *AuthPlugin) GetOpenAPIResources() []plugin.OpenAPIResource {
 		Description:   "User management and authentication",
 	}}
 }
+
+// checkLinkURLs requires the frontend pages of the enabled email flows.
+// Password resets and email changes are enabled by a mailer, email
+// verification by its mode.
+func (p *AuthPlugin) checkLinkURLs() error {
+	links := []struct {
+		name    string
+		value   string
+		enabled bool
+	}{
+		{"password_reset_url", p.config.PasswordResetURL, p.config.Mailer != nil},
+		{"email_verification_url", p.config.EmailVerificationURL, p.config.EmailVerification != ""},
+		{"email_change_url", p.config.EmailChangeURL, p.config.Mailer != nil},
+		{"email_change_revert_url", p.config.EmailChangeRevertURL, p.config.Mailer != nil},
+	}
+
+	for _, link := range links {
+		if !link.enabled {
+			continue
+		}
+		if link.value == "" {
+			return fmt.Errorf("%s is required when a mailer is configured", link.name)
+		}
+		if err := checkLinkURL(link.name, link.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkLinkURL rejects relative links, which mail clients cannot follow.
+func checkLinkURL(name, value string) error {
+	link, err := url.Parse(value)
+	if err != nil || !link.IsAbs() || link.Host == "" {
+		return fmt.Errorf("%s must be an absolute URL", name)
+	}
+	return nil
+}
+
+func (p *AuthPlugin) revocationStore(config map[string]interface{}) (revocation.Store, error) {
+	backend, _ := config["revocation_store"].(string)
+	if backend == "" {
+		backend = "database"
+		if p.db == nil {
+			backend = "memory"
+		}
+	}
+
+	switch backend {
+	case "memory":
+		return revocation.NewMemoryStore(), nil
+	case "database":
+		if p.db == nil {
+			return nil, fmt.Errorf("revocation_store %q requires a database", backend)
+		}
+		return revocation.NewSQLStore(p.db), nil
+	default:
+		return nil, fmt.Errorf("unknown revocation_store: %s", backend)
+	}
+}
+
+func (p *AuthPlugin) passwordHasher(config map[string]interface{}) (password.Hasher, error) {
+	if h, ok := config["password_hasher"].(password.Hasher); ok {
+		return h, nil
+	}
+
+	algorithm, _ := config["password_hasher"].(string)
+	switch algorithm {
+	case "":
+		return p.config.PasswordHasher, nil
+	case password.AlgorithmArgon2id:
+		params, err := parseArgon2idParams(config)
+		if err != nil {
+			return nil, err
+		}
+		return password.NewArgon2idHasher(params), nil
+	case password.AlgorithmBcrypt:
+		cost, err := intOption(config, "bcrypt_cost", int64(password.DefaultBcryptCost), int64(bcrypt.MinCost), int64(bcrypt.MaxCost))
+		if err != nil {
+			return nil, err
+		}
+		return password.NewBcryptHasher(int(cost)), nil
+	default:
+		return nil, fmt.Errorf("unknown password_hasher: %s", algorithm)
+	}
+}
+
+// parseArgon2idParams overrides the default argon2id parameters with the
+// configured ones.
+func parseArgon2idParams(config map[string]interface{}) (password.Argon2idParams, error) {
+	params := password.DefaultArgon2idParams
+
+	memory, err := intOption(config, "argon2_memory", int64(params.Memory), 1, math.MaxUint32)
+	if err != nil {
+		return params, err
+	}
+	iterations, err := intOption(config, "argon2_iterations", int64(params.Iterations), 1, math.MaxUint32)
+	if err != nil {
+		return params, err
+	}
+	parallelism, err := intOption(config, "argon2_parallelism", int64(params.Parallelism), 1, math.MaxUint8)
+	if err != nil {
+		return params, err
+	}
+
+	params.Memory = uint32(memory)
+	params.Iterations = uint32(iterations)
+	params.Parallelism = uint8(parallelism)
+	return params, nil
+}
+
+// intOption returns the configured integer, or fallback when it is not set.
+// Values outside of [low, high] are rejected.
+func intOption(config map[string]interface{}, name string, fallback, low, high int64) (int64, error) {
+	value, ok := config[name].(int)
+	if !ok {
+		return fallback, nil
+	}
+	if int64(value) < low || int64(value) > high {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, low, high)
+	}
+	return int64(value), nil
+}
+
+// passwordPeppers returns nil when no pepper is configured.
+func (p *AuthPlugin) passwordPeppers(config map[string]interface{}) (*password.Peppers, error) {
+	secret, _ := config["password_pepper"].(string)
+
+	if pepperFile, ok := config["password_pepper_file"].(string); ok && pepperFile != "" {
+		content, err := os.ReadFile(pepperFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password_pepper_file: %w", err)
+		}
+		secret = strings.TrimSpace(string(content))
+	}
+
+	if secret == "" {
+		return nil, nil
+	}
+
+	version := "1"
+	if pepperVersion, ok := config["password_pepper_version"].(string); ok && pepperVersion != "" {
+		version = pepperVersion
+	}
+
+	previous := make(map[string][]byte)
+	if previousPeppers, ok := config["password_previous_peppers"].(map[string]interface{}); ok {
+		for previousVersion, value := range previousPeppers {
+			previousSecret, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("password_previous_peppers.%s must be a string", previousVersion)
+			}
+			previous[previousVersion] = []byte(previousSecret)
+		}
+	}
+
+	peppers, err := password.NewPeppers(version, []byte(secret), previous)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password pepper: %w", err)
+	}
+
+	return peppers, nil
+}
+
+func (p *AuthPlugin) mailer(config map[string]interface{}) (mailer.Mailer, error) {
+	if m, ok := config["mailer"].(mailer.Mailer); ok {
+		return m, nil
+	}
+
+	backend, _ := config["mailer"].(string)
+	switch backend {
+	case "":
+		return nil, nil
+	case "smtp":
+		smtpConfig := mailer.SMTPConfig{}
+		smtpConfig.Host, _ = config["smtp_host"].(string)
+		smtpConfig.Port, _ = config["smtp_port"].(int)
+		smtpConfig.Username, _ = config["smtp_username"].(string)
+		smtpConfig.Password, _ = config["smtp_password"].(string)
+		smtpConfig.Security, _ = config["smtp_security"].(string)
+		smtpConfig.From, _ = config["mail_from"].(string)
+		return mailer.NewSMTPMailer(smtpConfig)
+	case "file":
+		dir, _ := config["mail_dir"].(string)
+		if dir == "" {
+			return nil, fmt.Errorf("mailer %q requires mail_dir", backend)
+		}
+		return mailer.NewFileMailer(dir)
+	case "log":
+		return mailer.NewLogMailer(nil), nil
+	case "memory":
+		return mailer.NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer: %s", backend)
+	}
+}
+
+func parsePasswordPolicy(policy *password.Policy, config map[string]interface{}) error {
+	if minLength, ok := config["min_length"].(int); ok {
+		policy.MinLength = minLength
+	}
+
+	if maxLength, ok := config["max_length"].(int); ok {
+		policy.MaxLength = maxLength
+	}
+
+	if requireUppercase, ok := config["require_uppercase"].(bool); ok {
+		policy.RequireUppercase = requireUppercase
+	}
+
+	if requireLowercase, ok := config["require_lowercase"].(bool); ok {
+		policy.RequireLowercase = requireLowercase
+	}
+
+	if requireDigit, ok := config["require_digit"].(bool); ok {
+		policy.RequireDigit = requireDigit
+	}
+
+	if requireSymbol, ok := config["require_symbol"].(bool); ok {
+		policy.RequireSymbol = requireSymbol
+	}
+
+	if minClasses, ok := config["min_character_classes"].(int); ok {
+		policy.MinCharacterClasses = minClasses
+	}
+
+	if disallowUserInfo, ok := config["disallow_user_info"].(bool); ok {
+		policy.DisallowUserInfo = disallowUserInfo
+	}
+
+	switch minEntropy := config["min_entropy"].(type) {
+	case int:
+		policy.MinEntropy = float64(minEntropy)
+	case float64:
+		policy.MinEntropy = minEntropy
+	}
+
+	if breachedFile, ok := config["breached_passwords_file"].(string); ok && breachedFile != "" {
+		checker, err := password.OpenBreachChecker(breachedFile)
+		if err != nil {
+			return fmt.Errorf("failed to open breached_passwords_file: %w", err)
+		}
+		policy.Breached = checker
+	}
+
+	return nil
+}
+
+func parseKeyConfig(config map[string]interface{}) KeyConfig {
+	var keyConfig KeyConfig
+
+	if kid, ok := config["kid"].(string); ok {
+		keyConfig.ID = kid
+	}
+
+	if algorithm, ok := config["algorithm"].(string); ok {
+		keyConfig.Algorithm = algorithm
+	}
+
+	if secret, ok := config["secret"].(string); ok {
+		keyConfig.Secret = secret
+		// Short secrets are accepted, as for jwt_secret, so they can be
+		// rotated out without invalidating the tokens they signed.
+		keyConfig.legacySecret = true
+	}
+
+	if privateKey, ok := config["private_key"].(string); ok {
+		keyConfig.PrivateKey = privateKey
+	}
+
+	if privateKeyFile, ok := config["private_key_file"].(string); ok {
+		keyConfig.PrivateKeyFile = privateKeyFile
+	}
+
+	if publicKey, ok := config["public_key"].(string); ok {
+		keyConfig.PublicKey = publicKey
+	}
+
+	if publicKeyFile, ok := config["public_key_file"].(string); ok {
+		keyConfig.PublicKeyFile = publicKeyFile
+	}
+
+	return keyConfig
+}
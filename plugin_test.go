@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/mailer"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest/database"
+)
+
+func TestInitializeMailer(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{"mailer": "carrier-pigeon"},
+		{"mailer": "file"},
+		{"mailer": "smtp"},
+		{"mailer": "smtp", "smtp_host": "smtp.example.com", "smtp_security": "ssl"},
+	}
+
+	for _, config := range invalid {
+		config["jwt_secret"] = testSecret
+		if err := NewPlugin().Initialize(withLinkURLs(config)); err == nil {
+			t.Fatalf("expected %v to be rejected", config)
+		}
+	}
+
+	tests := []struct {
+		config   map[string]interface{}
+		expected mailer.Mailer
+	}{
+		{map[string]interface{}{}, nil},
+		{map[string]interface{}{"mailer": "memory"}, &mailer.MemoryMailer{}},
+		{map[string]interface{}{"mailer": "log"}, &mailer.LogMailer{}},
+		{map[string]interface{}{"mailer": "file", "mail_dir": t.TempDir()}, &mailer.FileMailer{}},
+		{map[string]interface{}{"mailer": "smtp", "smtp_host": "smtp.example.com"}, &mailer.SMTPMailer{}},
+	}
+
+	for _, tt := range tests {
+		tt.config["jwt_secret"] = testSecret
+		plugin := NewPlugin().(*AuthPlugin)
+		if err := plugin.Initialize(withLinkURLs(tt.config)); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%T", plugin.Mailer()) != fmt.Sprintf("%T", tt.expected) {
+			t.Fatalf("%v: unexpected mailer %T", tt.config, plugin.Mailer())
+		}
+	}
+}
+
+func TestInitializeLinkURLs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"no reset link", map[string]interface{}{"mailer": "memory", "password_reset_url": ""}},
+		{"no email change link", map[string]interface{}{"mailer": "memory", "email_change_url": ""}},
+		{"no email revert link", map[string]interface{}{"mailer": "memory", "email_change_revert_url": ""}},
+		{"no verification link", map[string]interface{}{"mailer": "memory", "email_verification": EmailVerificationOptional, "email_verification_url": ""}},
+		{"relative reset link", map[string]interface{}{"mailer": "memory", "password_reset_url": "/reset-password"}},
+		{"relative email change link", map[string]interface{}{"mailer": "memory", "email_change_url": "app.example.com/email/confirm"}},
+		{"relative verification link", map[string]interface{}{"mailer": "memory", "email_verification": EmailVerificationOptional, "email_verification_url": "verify-email"}},
+		{"relative magic link", map[string]interface{}{"mailer": "memory", "magic_link": true, "magic_link_url": "/login"}},
+	}
+
+	for _, tt := range tests {
+		tt.config["jwt_secret"] = testSecret
+		if err := NewPlugin().Initialize(withLinkURLs(tt.config)); err == nil {
+			t.Fatalf("%s: expected Initialize to fail", tt.name)
+		}
+	}
+
+	// Without a mailer, the email flows and their links are disabled.
+	if err := NewPlugin().Initialize(map[string]interface{}{"jwt_secret": testSecret}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMailTemplatesConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "fr"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"fr/password_reset.subject.txt": "Réinitialisez votre mot de passe",
+		"fr/password_reset.txt":         "Bonjour {{.User.Firstname}}, {{.Link}}",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mail := mailer.NewMemoryMailer()
+	app, plugin, _ := newTestApp(t, map[string]interface{}{
+		"mailer":              mail,
+		"mail_templates_dir":  dir,
+		"mail_default_locale": "fr",
+		"password_reset_url":  "https://app.example.com/reset-password",
+	})
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+
+	if status, _ := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d", status)
+	}
+	message := waitForMail(t, mail, 0)
+	if message.Subject != "Réinitialisez votre mot de passe" || !strings.HasPrefix(message.Text, "Bonjour Jane") {
+		t.Fatalf("expected the configured template, got %+v", message)
+	}
+
+	// Without a mailer, the email flows are not registered.
+	t.Run("no mailer", func(t *testing.T) {
+		app, _, _ := newTestApp(t, nil)
+		if status, _ := request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"}); status != fiber.StatusNotFound {
+			t.Fatalf("expected 404 without a mailer, got %d", status)
+		}
+	})
+}
+
+func TestInitializeValidatesPasswordHasher(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"zero memory", map[string]interface{}{"password_hasher": "argon2id", "argon2_memory": 0}},
+		{"negative memory", map[string]interface{}{"password_hasher": "argon2id", "argon2_memory": -1}},
+		{"zero iterations", map[string]interface{}{"password_hasher": "argon2id", "argon2_iterations": 0}},
+		{"negative iterations", map[string]interface{}{"password_hasher": "argon2id", "argon2_iterations": -2}},
+		{"zero parallelism", map[string]interface{}{"password_hasher": "argon2id", "argon2_parallelism": 0}},
+		{"wrapping parallelism", map[string]interface{}{"password_hasher": "argon2id", "argon2_parallelism": 256}},
+		{"low bcrypt cost", map[string]interface{}{"password_hasher": "bcrypt", "bcrypt_cost": 3}},
+		{"high bcrypt cost", map[string]interface{}{"password_hasher": "bcrypt", "bcrypt_cost": 32}},
+		{"unknown hasher", map[string]interface{}{"password_hasher": "md5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config["jwt_secret"] = testSecret
+			if err := NewPlugin().Initialize(tt.config); err == nil {
+				t.Fatal("expected Initialize to fail")
+			}
+		})
+	}
+}
+
+func TestInitializeConfiguresPasswordHasher(t *testing.T) {
+	plugin := NewPlugin().(*AuthPlugin)
+	err := plugin.Initialize(map[string]interface{}{
+		"jwt_secret":         testSecret,
+		"password_hasher":    "argon2id",
+		"argon2_memory":      1024,
+		"argon2_iterations":  1,
+		"argon2_parallelism": 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := plugin.config.PasswordHasher.Hash("correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=2$") {
+		t.Fatalf("unexpected hash parameters: %s", encoded)
+	}
+}
+
+func TestPasswordHasherPerPlugin(t *testing.T) {
+	assertHash := func(t *testing.T, app *fiber.App, db database.Database, prefix string) {
+		t.Helper()
+
+		register(t, app, "jane@example.com")
+
+		var stored string
+		if err := db.QueryRow(context.Background(), "SELECT password FROM users WHERE email = ?", "jane@example.com").Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(stored, prefix) {
+			t.Fatalf("expected a %s hash, got %s", prefix, stored)
+		}
+	}
+
+	bcryptApp, _, bcryptDB := newTestApp(t, map[string]interface{}{"password_hasher": "bcrypt"})
+
+	// Initializing another plugin does not change the hasher of the first.
+	t.Run("argon2id", func(t *testing.T) {
+		argonApp, _, argonDB := newTestApp(t, map[string]interface{}{"password_hasher": "argon2id"})
+		assertHash(t, bcryptApp, bcryptDB, "$2a$")
+		assertHash(t, argonApp, argonDB, "$argon2id$")
+	})
+}
+
+func TestPasswordPepper(t *testing.T) {
+	pepperFile := filepath.Join(t.TempDir(), "pepper")
+	if err := os.WriteFile(pepperFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	previous := "fedcba9876543210fedcba9876543210"
+
+	app, _, db := newTestApp(t, map[string]interface{}{
+		"password_pepper_file":      pepperFile,
+		"password_pepper_version":   "2",
+		"password_previous_peppers": map[string]interface{}{"1": previous},
+	})
+
+	peppers, err := password.NewPeppers("1", []byte(previous), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := password.NewPepperedHasher(password.NewArgon2idHasher(password.DefaultArgon2idParams), peppers).Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := password.NewArgon2idHasher(password.DefaultArgon2idParams).Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users are re-peppered with the current version when they log in.
+	for _, encoded := range []string{old, legacy} {
+		email := uuid.NewString() + "@example.com"
+		userID := createTestUser(t, db, email, "user")
+		if _, err := db.Exec(context.Background(), "UPDATE users SET password = ? WHERE id = ?", encoded, userID.String()); err != nil {
+			t.Fatal(err)
+		}
+		login(t, app, email, testPassword)
+
+		var stored string
+		if err := db.QueryRow(context.Background(), "SELECT password FROM users WHERE id = ?", userID.String()).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(stored, "$pepper$2$argon2id$") {
+			t.Fatalf("expected the hash to be re-peppered, got %s", stored)
+		}
+	}
+}
+
+func TestInitializeValidatesPasswordPepper(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"short pepper", map[string]interface{}{"password_pepper": "short"}},
+		{"missing file", map[string]interface{}{"password_pepper_file": filepath.Join(t.TempDir(), "missing")}},
+		{"invalid version", map[string]interface{}{"password_pepper": strings.Repeat("a", 32), "password_pepper_version": "1$"}},
+		{"invalid previous pepper", map[string]interface{}{"password_pepper": strings.Repeat("a", 32), "password_previous_peppers": map[string]interface{}{"1": 42}}},
+	}
+
+	for _, tt := range tests {
+		tt.config["jwt_secret"] = testSecret
+		if err := NewPlugin().Initialize(tt.config); err == nil {
+			t.Fatalf("%s: expected Initialize to fail", tt.name)
+		}
+	}
+}
+
+func TestPasswordPolicyConfig(t *testing.T) {
+	mail := mailer.NewMemoryMailer()
+	app, _, db := newTestApp(t, map[string]interface{}{
+		"mailer":             mail,
+		"password_reset_url": "https://app.example.com/reset-password",
+		"password_policy": map[string]interface{}{
+			"min_length":         12,
+			"require_digit":      true,
+			"disallow_user_info": true,
+			"min_entropy":        40,
+		},
+	})
+	createTestUser(t, db, "jane@example.com", "user")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+
+	request(t, app, "POST", "/auth/password/forgot", "", map[string]string{"email": "jane@example.com"})
+	resetToken := mailToken(t, waitForMail(t, mail, 0))
+
+	// Passwords breaking the policy are rejected the same way everywhere.
+	weak := "jane-secret"
+	requests := []struct {
+		name  string
+		path  string
+		token string
+		body  map[string]string
+	}{
+		{"register", "/auth/register", "", map[string]string{"email": "jane.doe@example.com", "password": weak, "firstname": "Jane", "lastname": "Doe"}},
+		{"change", "/auth/password/change", token, map[string]string{"current_password": testPassword, "new_password": weak}},
+		{"reset", "/auth/password/reset", "", map[string]string{"token": resetToken, "password": weak}},
+	}
+
+	for _, tt := range requests {
+		status, result := request(t, app, "POST", tt.path, tt.token, tt.body)
+		if status != fiber.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d %v", tt.name, status, result)
+		}
+
+		violations, _ := result["violations"].([]interface{})
+		var rules []string
+		for _, violation := range violations {
+			rule, _ := violation.(map[string]interface{})["rule"].(string)
+			rules = append(rules, rule)
+		}
+		expected := []string{password.RuleMinLength, password.RuleDigit, password.RuleUserInfo}
+		if strings.Join(rules, ",") != strings.Join(expected, ",") {
+			t.Fatalf("%s: expected violations %v, got %v", tt.name, expected, result)
+		}
+	}
+
+	if status, result := request(t, app, "POST", "/auth/password/reset", "", map[string]string{"token": resetToken, "password": "correct-horse-battery-42"}); status != fiber.StatusNoContent {
+		t.Fatalf("expected a valid password to be accepted, got %d %v", status, result)
+	}
+}
+
+func TestBreachedPasswordsConfig(t *testing.T) {
+	dir := t.TempDir()
+	// A range file of the Pwned Passwords downloader, named after the first
+	// five characters of the SHA-1 of "sunshine-forever".
+	sum := sha1.Sum([]byte("sunshine-forever"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(hash[5:]+":42\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	app, _, db := newTestApp(t, map[string]interface{}{
+		"password_policy": map[string]interface{}{"breached_passwords_file": dir},
+	})
+	createTestUser(t, db, "jane@example.com", "user")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+
+	status, result := request(t, app, "POST", "/auth/register", "", map[string]string{"email": "john@example.com", "password": "sunshine-forever", "firstname": "John", "lastname": "Doe"})
+	if status != fiber.StatusBadRequest || !strings.Contains(fmt.Sprint(result["violations"]), password.RuleBreached) {
+		t.Fatalf("expected a breached password to be rejected at registration, got %d %v", status, result)
+	}
+	status, result = request(t, app, "POST", "/auth/password/change", token, map[string]string{"current_password": testPassword, "new_password": "sunshine-forever"})
+	if status != fiber.StatusBadRequest || !strings.Contains(fmt.Sprint(result["violations"]), password.RuleBreached) {
+		t.Fatalf("expected a breached password to be rejected at change, got %d %v", status, result)
+	}
+
+	if err := NewPlugin().Initialize(map[string]interface{}{
+		"jwt_secret":      testSecret,
+		"password_policy": map[string]interface{}{"breached_passwords_file": filepath.Join(dir, "missing")},
+	}); err == nil {
+		t.Fatal("expected a missing breached_passwords_file to be rejected")
+	}
+}
+
+func TestInitializeValidatesSecondFactors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"short mfa key", map[string]interface{}{"mfa_encryption_key": strings.Repeat("ab", 16)}},
+		{"non hex mfa key", map[string]interface{}{"mfa_encryption_key": strings.Repeat("zz", 32)}},
+		{"webauthn without origins", map[string]interface{}{"webauthn_rp_id": "example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config["jwt_secret"] = testSecret
+			if err := NewPlugin().Initialize(tt.config); err == nil {
+				t.Fatal("expected Initialize to fail")
+			}
+		})
+	}
+}
+
+func TestRegisterAuthRoutesRejectsInvalidConfig(t *testing.T) {
+	tokenService := newTestJWTService(t)
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+	}{
+		{"mfa key", func(config *Config) { config.MFAEncryptionKey = "not-hex" }},
+		{"webauthn", func(config *Config) { config.WebAuthnRPID = "example.com" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.configure(&config)
+
+			app := fiber.New()
+			if err := RegisterAuthRoutes(app, newTestDatabase(t), tokenService, config); err == nil {
+				t.Fatal("expected RegisterAuthRoutes to fail")
+			}
+			if routes := app.GetRoutes(); len(routes) != 0 {
+				t.Fatalf("expected no route to be registered, got %d", len(routes))
+			}
+		})
+	}
+}
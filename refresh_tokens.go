@@ -0,0 +1,297 @@
+package auth
+
+import (
+	stdcontext "context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// tokenPurgeInterval is how many tokens a store issues between two purges of
+// its stale rows.
+const tokenPurgeInterval = 128
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenStore persists opaque refresh tokens. Only a SHA-256 digest of
+// each token is stored. Every token belongs to a family started at login;
+// using a token rotates it, and presenting an already rotated token revokes
+// the whole family.
+type RefreshTokenStore struct {
+	db     database.Database
+	ttl    time.Duration
+	purges purgeSchedule
+}
+
+func NewRefreshTokenStore(db database.Database, ttl int) *RefreshTokenStore {
+	return &RefreshTokenStore{
+		db:  db,
+		ttl: time.Duration(ttl) * time.Second,
+	}
+}
+
+// Issue starts a new token family for the user and returns its first token.
+// auth describes the login, and is restored by every rotation.
+func (s *RefreshTokenStore) Issue(ctx stdcontext.Context, userID uuid.UUID, auth tokens.Authentication) (string, error) {
+	token, record, err := s.newToken(userID, uuid.New())
+	if err != nil {
+		return "", err
+	}
+
+	record.AMR = strings.Join(auth.Methods, ",")
+	record.ACR = auth.Level
+	if !auth.Time.IsZero() {
+		record.AuthTime = &auth.Time
+	}
+
+	if err := s.insert(ctx, s.db, record); err != nil {
+		return "", err
+	}
+
+	if s.purges.due() {
+		if err := s.purge(ctx); err != nil {
+			log.Printf("[gorest-auth] refresh token purge: %v", err)
+		}
+	}
+
+	return token, nil
+}
+
+// Rotate consumes a refresh token and returns its replacement along with the
+// user it belongs to and how they logged in.
+func (s *RefreshTokenStore) Rotate(ctx stdcontext.Context, token string) (string, uuid.UUID, tokens.Authentication, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := s.findByHash(ctx, tx, hashOpaqueToken(token))
+	if err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, err
+	}
+
+	if current.RevokedAt != nil {
+		return "", uuid.Nil, tokens.Authentication{}, s.revokeReusedFamily(ctx, tx, current.FamilyID)
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return "", uuid.Nil, tokens.Authentication{}, ErrRefreshTokenExpired
+	}
+
+	next, record, err := s.newToken(current.UserID, current.FamilyID)
+	if err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, err
+	}
+	record.AMR, record.ACR, record.AuthTime = current.AMR, current.ACR, current.AuthTime
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("refresh_tokens").
+		Set("revoked_at", time.Now()).
+		Set("replaced_by", record.ID).
+		Where(query.Eq("id", current.ID)).
+		Where(query.IsNull("revoked_at")).
+		Build()
+	if err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := tx.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	// Another request consumed the token between our read and write.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return "", uuid.Nil, tokens.Authentication{}, s.revokeReusedFamily(ctx, tx, current.FamilyID)
+	}
+
+	if err := s.insert(ctx, tx, record); err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", uuid.Nil, tokens.Authentication{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	auth := tokens.Authentication{Level: current.ACR}
+	if current.AMR != "" {
+		auth.Methods = strings.Split(current.AMR, ",")
+	}
+	if current.AuthTime != nil {
+		auth.Time = *current.AuthTime
+	}
+
+	return next, current.UserID, auth, nil
+}
+
+// RevokeFamily revokes every active token of the family the token belongs to.
+func (s *RefreshTokenStore) RevokeFamily(ctx stdcontext.Context, token string) error {
+	current, err := s.findByHash(ctx, s.db, hashOpaqueToken(token))
+	if err != nil {
+		return err
+	}
+
+	return s.revoke(ctx, s.db, query.Eq("family_id", current.FamilyID))
+}
+
+// RevokeUser revokes every active token of the user.
+func (s *RefreshTokenStore) RevokeUser(ctx stdcontext.Context, userID uuid.UUID) error {
+	return s.revoke(ctx, s.db, query.Eq("user_id", userID))
+}
+
+type queryExecutor interface {
+	QueryRow(ctx stdcontext.Context, query string, args ...interface{}) database.Row
+	Exec(ctx stdcontext.Context, query string, args ...interface{}) (database.Result, error)
+}
+
+func (s *RefreshTokenStore) revokeReusedFamily(ctx stdcontext.Context, tx database.Tx, familyID uuid.UUID) error {
+	if err := s.revoke(ctx, tx, query.Eq("family_id", familyID)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return ErrRefreshTokenReused
+}
+
+func (s *RefreshTokenStore) revoke(ctx stdcontext.Context, exec queryExecutor, condition query.Condition) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("refresh_tokens").
+		Set("revoked_at", time.Now()).
+		Where(condition).
+		Where(query.IsNull("revoked_at")).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+// purge deletes the tokens that can no longer be used. Issue runs it every
+// tokenPurgeInterval families. Rotated tokens are kept until they expire to
+// detect their reuse.
+func (s *RefreshTokenStore) purge(ctx stdcontext.Context) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("refresh_tokens").
+		Where(query.Or(
+			query.Lt("expires_at", time.Now()),
+			query.And(query.IsNotNull("revoked_at"), query.IsNull("replaced_by")),
+		)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to delete stale refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RefreshTokenStore) findByHash(ctx stdcontext.Context, exec queryExecutor, tokenHash string) (*models.RefreshToken, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("id", "user_id", "family_id", "expires_at", "revoked_at", "amr", "acr", "auth_time").
+		From("refresh_tokens").
+		Where(query.Eq("token_hash", tokenHash)).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var token models.RefreshToken
+	err = exec.QueryRow(ctx, queryStr, args...).
+		Scan(&token.ID, &token.UserID, &token.FamilyID, &token.ExpiresAt, &token.RevokedAt, &token.AMR, &token.ACR, &token.AuthTime)
+	if crud.IsNotFoundError(err) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (s *RefreshTokenStore) insert(ctx stdcontext.Context, exec queryExecutor, token *models.RefreshToken) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Insert("refresh_tokens").
+		Columns("id", "user_id", "family_id", "token_hash", "expires_at", "created_at", "amr", "acr", "auth_time").
+		Values(token.ID, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt, token.CreatedAt, token.AMR, token.ACR, token.AuthTime).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := exec.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RefreshTokenStore) newToken(userID, familyID uuid.UUID) (string, *models.RefreshToken, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	return token, &models.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashOpaqueToken(token),
+		ExpiresAt: now.Add(s.ttl),
+		CreatedAt: now,
+	}, nil
+}
+
+// purgeSchedule spreads the purges of a token store over its issued tokens,
+// so the table-wide delete stays off most logins.
+type purgeSchedule struct {
+	issued atomic.Uint64
+}
+
+// due counts an issued token and reports whether the store should purge.
+func (p *purgeSchedule) due() bool {
+	return p.issued.Add(1)%tokenPurgeInterval == 0
+}
+
+func generateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
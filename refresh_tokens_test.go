@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db := newTestDatabase(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	store := NewRefreshTokenStore(db, 3600)
+	ctx := context.Background()
+
+	auth := tokens.Authentication{Methods: []string{"pwd", "otp"}, Level: "aal2", Time: time.Now().Truncate(time.Second)}
+	first, err := store.Issue(ctx, userID, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM refresh_tokens WHERE token_hash = ?", first).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatal("expected only a digest of the token to be stored")
+	}
+
+	second, owner, restored, err := store.Rotate(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || owner != userID {
+		t.Fatalf("unexpected rotation: %s %s", second, owner)
+	}
+	if restored.Level != auth.Level || len(restored.Methods) != 2 || !restored.Time.Equal(auth.Time) {
+		t.Fatalf("expected the authentication to be restored, got %+v", restored)
+	}
+
+	third, _, _, err := store.Rotate(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Presenting a rotated token revokes the whole family.
+	if _, _, _, err := store.Rotate(ctx, first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, _, _, err := store.Rotate(ctx, third); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected the family to be revoked, got %v", err)
+	}
+
+	// Other families are not affected.
+	other, err := store.Issue(ctx, userID, tokens.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := store.Rotate(ctx, other); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, _, err := store.Rotate(ctx, "unknown"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("expected ErrRefreshTokenInvalid, got %v", err)
+	}
+}
+
+func TestRefreshTokenExpiry(t *testing.T) {
+	db := newTestDatabase(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	ctx := context.Background()
+
+	token, err := NewRefreshTokenStore(db, -1).Issue(ctx, userID, tokens.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := NewRefreshTokenStore(db, 3600).Rotate(ctx, token); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Fatalf("expected ErrRefreshTokenExpired, got %v", err)
+	}
+}
+
+func TestRefreshTokenPurge(t *testing.T) {
+	db := newTestDatabase(t)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	store := NewRefreshTokenStore(db, 3600)
+	ctx := context.Background()
+
+	rotated, err := store.Issue(ctx, userID, tokens.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := store.Rotate(ctx, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRefreshTokenStore(db, -1).Issue(ctx, userID, tokens.Authentication{}); err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := store.Issue(ctx, userID, tokens.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeFamily(ctx, revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	// Purging deletes the expired and revoked tokens.
+	if err := store.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM refresh_tokens").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Fatalf("expected 2 tokens to be kept, got %d", stored)
+	}
+
+	// Rotated tokens are kept to detect their reuse.
+	if _, _, _, err := store.Rotate(ctx, rotated); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+}
+
+func TestPurgeSchedule(t *testing.T) {
+	var schedule purgeSchedule
+	for i := 1; i <= 3*tokenPurgeInterval; i++ {
+		if due := schedule.due(); due != (i%tokenPurgeInterval == 0) {
+			t.Fatalf("issue %d: expected due to be %v", i, !due)
+		}
+	}
+}
+
+func TestRefreshEndpoint(t *testing.T) {
+	app, _, db := newTestApp(t, nil)
+	createTestUser(t, db, "jane@example.com", "user")
+	_, refreshToken := login(t, app, "jane@example.com", testPassword)
+
+	status, result := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken})
+	if status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+	token, _ := result["token"].(string)
+	rotated, _ := result["refresh_token"].(string)
+	if token == "" || rotated == "" || rotated == refreshToken {
+		t.Fatalf("unexpected response: %v", result)
+	}
+	if status, _ := request(t, app, "POST", "/auth/logout", token, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected the new access token to be accepted, got %d", status)
+	}
+
+	tests := []struct {
+		name     string
+		body     map[string]string
+		expected int
+	}{
+		{"missing token", map[string]string{}, fiber.StatusBadRequest},
+		{"reused token", map[string]string{"refresh_token": refreshToken}, fiber.StatusUnauthorized},
+		{"revoked family", map[string]string{"refresh_token": rotated}, fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		if status, result := request(t, app, "POST", "/auth/refresh", "", tt.body); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps revocations in process memory. Revocations are lost on
+// restart and are not shared between instances.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired(time.Now())
+	s.tokens[jti] = expiresAt
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeUser(ctx context.Context, userID string, issuedBefore time.Time) error {
+	issuedBefore = issuedBefore.Truncate(Precision)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.users[userID]; !ok || issuedBefore.After(current) {
+		s.users[userID] = issuedBefore
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if expiresAt, ok := s.tokens[jti]; ok && time.Now().Before(expiresAt) {
+		return true, nil
+	}
+
+	if revokedBefore, ok := s.users[userID]; ok && issuedAt.Before(revokedBefore) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStore) purgeExpired(now time.Time) {
+	for jti, expiresAt := range s.tokens {
+		if now.After(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+}
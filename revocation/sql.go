@@ -0,0 +1,126 @@
+package revocation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+)
+
+// SQLStore persists revocations in the revoked_tokens and
+// user_token_revocations tables so they are shared by every instance.
+type SQLStore struct {
+	db database.Database
+}
+
+func NewSQLStore(db database.Database) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// Revoke also purges the expired revocations, as the memory store does.
+// Revoking a token twice, even concurrently, is not an error.
+func (s *SQLStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := s.PurgeExpired(ctx); err != nil {
+		return err
+	}
+
+	dialect := s.db.Dialect()
+	queryStr, args, err := query.New(dialect).
+		Insert("revoked_tokens").
+		Columns("jti", "expires_at").
+		Values(jti, expiresAt).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	queryStr += " " + dialect.OnConflictClause([]string{"jti"}, "DO UPDATE SET jti = excluded.jti")
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) RevokeUser(ctx context.Context, userID string, issuedBefore time.Time) error {
+	// Truncated so that databases storing milliseconds do not round it up.
+	issuedBefore = issuedBefore.Truncate(Precision)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	deleteStr, deleteArgs, err := query.New(s.db.Dialect()).
+		Delete("user_token_revocations").
+		Where(query.Eq("user_id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, deleteStr, deleteArgs...); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+
+	insertStr, insertArgs, err := query.New(s.db.Dialect()).
+		Insert("user_token_revocations").
+		Columns("user_id", "revoked_before").
+		Values(userID, issuedBefore).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, insertStr, insertArgs...); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// IsRevoked looks up the revocation of the token and the cutoff of the user
+// in a single query, as it runs on every authenticated request.
+func (s *SQLStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	dialect := s.db.Dialect()
+	queryStr := "SELECT t.jti, u.revoked_before FROM (SELECT 1 AS one) q" +
+		" LEFT JOIN revoked_tokens t ON t.jti = " + dialect.Placeholder(1) +
+		" LEFT JOIN user_token_revocations u ON u.user_id = " + dialect.Placeholder(2)
+
+	var revokedJTI sql.NullString
+	var revokedBefore sql.NullTime
+	if err := s.db.QueryRow(ctx, queryStr, jti, userID).Scan(&revokedJTI, &revokedBefore); err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	if revokedJTI.Valid {
+		return true, nil
+	}
+
+	return revokedBefore.Valid && issuedAt.Before(revokedBefore.Time), nil
+}
+
+// PurgeExpired deletes revocations of tokens that have expired anyway.
+func (s *SQLStore) PurgeExpired(ctx context.Context) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("revoked_tokens").
+		Where(query.Lt("expires_at", time.Now())).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to purge revoked tokens: %w", err)
+	}
+
+	return nil
+}
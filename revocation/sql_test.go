@@ -0,0 +1,108 @@
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	authmigrations "github.com/nicolasbonnici/gorest-auth/migrations"
+	"github.com/nicolasbonnici/gorest/database"
+	_ "github.com/nicolasbonnici/gorest/database/sqlite"
+	"github.com/nicolasbonnici/gorest/migrations"
+)
+
+func newTestDatabase(t *testing.T) database.Database {
+	t.Helper()
+
+	db, err := database.Open("sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(1)", t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrations.NewMigrator(db, authmigrations.GetMigrations()).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSQLStore(t *testing.T) {
+	db := newTestDatabase(t)
+
+	userID := "3f1c7a52-8a0e-4f7e-9d55-2b8f4f0f7a10"
+	if _, err := db.Exec(context.Background(),
+		"INSERT INTO users (id, firstname, lastname, email) VALUES (?, 'Jane', 'Doe', 'jane@example.com')", userID); err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, NewSQLStore(db), userID)
+}
+
+func TestSQLStorePurgeExpired(t *testing.T) {
+	db := newTestDatabase(t)
+	store := NewSQLStore(db)
+	ctx := context.Background()
+
+	if err := store.Revoke(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, "live", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PurgeExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	assertRevoked(t, store, "expired", "user", time.Now(), false)
+	assertRevoked(t, store, "live", "user", time.Now(), true)
+}
+
+func TestSQLStoreRevokeTwice(t *testing.T) {
+	db := newTestDatabase(t)
+	store := NewSQLStore(db)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Minute)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.Revoke(ctx, "jti", expiresAt)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected revoking a token twice to succeed, got %v", err)
+		}
+	}
+	assertRevoked(t, store, "jti", "user", time.Now(), true)
+}
+
+func TestSQLStoreRevokePurgesExpired(t *testing.T) {
+	db := newTestDatabase(t)
+	store := NewSQLStore(db)
+	ctx := context.Background()
+
+	if err := store.Revoke(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, "live", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM revoked_tokens").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 1 {
+		t.Fatalf("expected the expired revocation to be purged, got %d rows", stored)
+	}
+}
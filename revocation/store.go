@@ -0,0 +1,26 @@
+package revocation
+
+import (
+	"context"
+	"time"
+)
+
+// Precision is the precision of token issue times and of the cutoffs of
+// RevokeUser. Tokens issued right after a revocation, such as the new pair
+// of the session revoking every other one, must not be caught by it.
+const Precision = time.Millisecond
+
+// Store records revoked access tokens so they can be rejected before they
+// expire.
+type Store interface {
+	// Revoke rejects the token with the given jti until expiresAt.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+
+	// RevokeUser rejects every token of the user issued before issuedBefore,
+	// truncated to Precision.
+	RevokeUser(ctx context.Context, userID string, issuedBefore time.Time) error
+
+	// IsRevoked reports whether the token identified by jti, issued to userID
+	// at issuedAt, has been revoked.
+	IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
+}
@@ -0,0 +1,91 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testStore runs the behaviour shared by every Store against a store of
+// which userID is a known user.
+func testStore(t *testing.T, store Store, userID string) {
+	ctx := context.Background()
+
+	t.Run("revoke", func(t *testing.T) {
+		now := time.Now()
+		if err := store.Revoke(ctx, "revoked-jti", now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		// Revoking twice is not an error.
+		if err := store.Revoke(ctx, "revoked-jti", now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		assertRevoked(t, store, "revoked-jti", userID, now, true)
+		assertRevoked(t, store, "other-jti", userID, now, false)
+	})
+
+	t.Run("revoke user", func(t *testing.T) {
+		// A cutoff in the middle of a second, with sub-millisecond digits.
+		second := time.Now().Add(time.Hour).Truncate(time.Second)
+		cutoff := second.Add(500*time.Millisecond + 400*time.Microsecond)
+		if err := store.RevokeUser(ctx, userID, cutoff); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name     string
+			issuedAt time.Time
+			revoked  bool
+		}{
+			{"previous second", second.Add(-time.Second), true},
+			{"earlier in the same second", second.Add(200 * time.Millisecond), true},
+			{"previous millisecond", second.Add(499 * time.Millisecond), true},
+			{"same millisecond", second.Add(500 * time.Millisecond), false},
+			{"later in the same second", second.Add(800 * time.Millisecond), false},
+			{"next second", second.Add(time.Second), false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assertRevoked(t, store, "jti", userID, tt.issuedAt, tt.revoked)
+			})
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		assertRevoked(t, store, "jti", "00000000-0000-0000-0000-000000000000", time.Now(), false)
+	})
+}
+
+func assertRevoked(t *testing.T, store Store, jti, userID string, issuedAt time.Time, expected bool) {
+	t.Helper()
+
+	revoked, err := store.IsRevoked(context.Background(), jti, userID, issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != expected {
+		t.Fatalf("expected revoked=%v for a token issued at %s", expected, issuedAt.Format(time.RFC3339Nano))
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), "3f1c7a52-8a0e-4f7e-9d55-2b8f4f0f7a10")
+}
+
+func TestMemoryStorePurgesExpiredTokens(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if err := store.Revoke(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, "live", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.tokens["expired"]; ok {
+		t.Fatal("expired revocation not purged")
+	}
+	assertRevoked(t, store, "expired", "user", time.Now(), false)
+}
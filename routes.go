@@ -2,18 +2,32 @@ package auth
 
 import (
 	stdcontext "context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/middleware"
 	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/password/history"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
 	"github.com/nicolasbonnici/gorest/crud"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/query"
 	"github.com/nicolasbonnici/gorest/response"
 )
 
+var (
+	ErrEmailAlreadyExists = errors.New("user with this email already exists")
+	ErrEmailInUse         = errors.New("email already in use")
+)
+
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
@@ -21,33 +35,161 @@ type LoginRequest struct {
 
 type RegisterRequest struct {
 	Email     string `json:"email" validate:"required,email"`
-	Password  string `json:"password" validate:"required,min=8"`
+	Password  string `json:"password" validate:"required"`
 	Firstname string `json:"firstname" validate:"required"`
 	Lastname  string `json:"lastname" validate:"required"`
 }
 
 type UpdateUserRequest struct {
-	Email     *string `json:"email,omitempty" validate:"omitempty,email"`
-	Password  *string `json:"password,omitempty" validate:"omitempty,min=8"`
+	Email *string `json:"email,omitempty" validate:"omitempty,email"`
+	// Password may only be set by admins on other users.
+	Password  *string `json:"password,omitempty"`
 	Firstname *string `json:"firstname,omitempty"`
 	Lastname  *string `json:"lastname,omitempty"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token        string       `json:"token,omitempty"`
+	RefreshToken string       `json:"refresh_token,omitempty"`
+	User         *models.User `json:"user"`
+
+	// PasswordChangeRequired tells that the token only allows to change the
+	// password.
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
+
+	// MFAEnrollmentRequired tells that the token only allows to enroll the
+	// second factor the role of the user requires.
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
-func RegisterAuthRoutes(router fiber.Router, db database.Database, jwt *JWTService) {
+// RegisterAuthRoutes registers the /auth routes. It fails before registering
+// any route when the WebAuthn relying party or the MFA encryption key of the
+// configuration is invalid, which the plugin already rejects when it is
+// initialized.
+func RegisterAuthRoutes(router fiber.Router, db database.Database, tokenService TokenService, config Config) error {
+	var passkeys *webAuthnStore
+	if config.WebAuthnRPID != "" {
+		rp, err := config.RelyingParty()
+		if err != nil {
+			return err
+		}
+		passkeys = newWebAuthnStore(db, rp, config.WebAuthnTimeout)
+	}
+
+	mfa, err := newMFAStore(db, config.MFAEncryptionKey, passkeys)
+	if err != nil {
+		return err
+	}
+
 	authGroup := router.Group("/auth")
-	userCRUD := crud.New[models.User](db)
+	refreshTokens := NewRefreshTokenStore(db, config.RefreshTokenTTL)
+	passwordHistory := history.NewStore(db, config.PasswordHistory, config.PasswordHasher)
+
+	var verifications *actionTokenStore
+	if config.EmailVerification != "" {
+		verifications = newEmailVerificationStore(db, config.EmailVerificationTokenTTL)
+		authGroup.Post("/verify-email", handleVerifyEmail(db, verifications))
+		authGroup.Post("/verify-email/resend", append(ipLimiter(config.EmailVerificationIPLimit, "too many verification emails requested, try again later"), handleResendVerification(db, verifications, config))...)
+	}
+
+	authGroup.Post("/register", handleRegister(db, tokenService, refreshTokens, verifications, config))
+	authGroup.Post("/login", handleLogin(db, tokenService, refreshTokens, mfa, config))
+	authGroup.Post("/refresh", handleRefresh(db, tokenService, refreshTokens, config))
+
+	var resets *actionTokenStore
+	if config.Mailer != nil {
+		resets = newPasswordResetStore(db, config.PasswordResetTokenTTL)
+		authGroup.Post("/password/forgot", append(ipLimiter(config.PasswordResetIPLimit, "too many password reset emails requested, try again later"), handleForgotPassword(db, resets, config))...)
+		authGroup.Post("/password/reset", handleResetPassword(db, tokenService, refreshTokens, resets, passwordHistory, config))
+	}
+
+	if config.MagicLink {
+		magicLinks := newMagicLinkStore(db, config.MagicLinkTTL, config.MagicLinkCooldown)
+		authGroup.Post("/magic-link", append(ipLimiter(config.MagicLinkIPLimit, "too many login emails requested, try again later"), handleMagicLink(db, magicLinks, config))...)
+		authGroup.Post("/magic-link/verify", handleVerifyMagicLink(db, tokenService, refreshTokens, magicLinks, mfa, config))
+	}
+
+	if len(config.IntrospectionClients) > 0 {
+		authGroup.Post("/introspect", handleIntrospect(tokenService, config.IntrospectionClients))
+	}
+
+	// Restricted tokens may still end their session, fix a mistyped email
+	// address, change an expired password or enroll a required second factor.
+	sessionMiddleware := middleware.AuthMiddleware(tokenService, db, append(config.MiddlewareOptions(),
+		middleware.AllowRestrictions(tokens.RestrictionEmailUnverified, tokens.RestrictionPasswordChange, tokens.RestrictionMFAEnrollment))...)
+	authGroup.Post("/logout", sessionMiddleware, handleLogout(tokenService, refreshTokens))
+	authGroup.Post("/logout-all", sessionMiddleware, handleLogoutAll(tokenService, refreshTokens))
+
+	if config.Mailer != nil {
+		changes := newEmailChangeStore(db, config.EmailChangeTokenTTL, config.EmailChangeRevertTTL)
+		authGroup.Post("/email/change", middleware.AuthMiddleware(tokenService, db, append(config.MiddlewareOptions(),
+			middleware.AllowRestrictions(tokens.RestrictionEmailUnverified))...),
+			handleChangeEmail(db, changes, config))
+		authGroup.Post("/email/change/confirm", handleConfirmEmailChange(db, resets, changes))
+		authGroup.Post("/email/change/revert", handleRevertEmailChange(db, tokenService, refreshTokens, resets, changes))
+	}
+
+	authGroup.Post("/mfa/verify", middleware.AuthMiddleware(tokenService, db, append(config.MiddlewareOptions(),
+		middleware.AllowRestrictions(tokens.RestrictionMFA))...),
+		handleVerifyMFA(db, tokenService, refreshTokens, mfa, config))
+
+	mfaMiddleware := middleware.AuthMiddleware(tokenService, db, append(config.MiddlewareOptions(),
+		middleware.AllowRestrictions(tokens.RestrictionMFAEnrollment))...)
+	authGroup.Get("/mfa", mfaMiddleware, handleMFAStatus(mfa))
+	authGroup.Post("/mfa/totp/enroll", mfaMiddleware, handleEnrollTOTP(db, mfa, config))
+	authGroup.Post("/mfa/totp/confirm", mfaMiddleware, handleConfirmTOTP(mfa))
+	authGroup.Post("/mfa/totp/disable", mfaMiddleware, handleDisableTOTP(db, mfa, config))
+	authGroup.Post("/mfa/recovery-codes", mfaMiddleware, handleRegenerateRecoveryCodes(mfa))
+	authGroup.Post("/step-up", middleware.AuthMiddleware(tokenService, db, config.MiddlewareOptions()...),
+		handleStepUp(db, tokenService, mfa, config))
+
+	if passkeys != nil {
+		authGroup.Post("/webauthn/register/begin", mfaMiddleware, handleWebAuthnRegisterBegin(db, passkeys, config))
+		authGroup.Post("/webauthn/register/finish", mfaMiddleware, handleWebAuthnRegisterFinish(passkeys))
+		authGroup.Get("/webauthn/credentials", mfaMiddleware, handleListWebAuthnCredentials(passkeys))
+		authGroup.Delete("/webauthn/credentials/:id", mfaMiddleware, handleDeleteWebAuthnCredential(db, passkeys, config))
+
+		// Logging in with a passkey needs no token; an mfa_token makes it
+		// the second factor of a password login.
+		loginMiddleware := middleware.OptionalAuthMiddleware(tokenService, db, append(config.MiddlewareOptions(),
+			middleware.AllowRestrictions(tokens.RestrictionMFA))...)
+		authGroup.Post("/webauthn/login/begin", loginMiddleware, handleWebAuthnLoginBegin(passkeys))
+		authGroup.Post("/webauthn/login/finish", loginMiddleware, handleWebAuthnLoginFinish(db, tokenService, refreshTokens, passkeys, config))
+	}
+
+	authGroup.Post("/password/change", middleware.AuthMiddleware(tokenService, db, append(config.MiddlewareOptions(),
+		middleware.AllowRestrictions(tokens.RestrictionPasswordChange))...),
+		handleChangePassword(db, tokenService, refreshTokens, resets, passwordHistory, config))
 
-	authGroup.Post("/register", handleRegister(db, userCRUD, jwt))
-	authGroup.Post("/login", handleLogin(db, jwt))
-	authGroup.Post("/refresh", handleRefresh(jwt))
+	return nil
+}
+
+// ipLimiter limits the requests each client IP address can make per minute
+// to an endpoint sending emails. A max of zero disables the limit.
+func ipLimiter(max int, message string) []fiber.Handler {
+	if max <= 0 {
+		return nil
+	}
+
+	return []fiber.Handler{limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: time.Minute,
+		LimitReached: func(c *fiber.Ctx) error {
+			return response.SendError(c, fiber.StatusTooManyRequests, message)
+		},
+	})}
 }
 
-func handleRegister(db database.Database, userCRUD *crud.CRUD[models.User], jwt *JWTService) fiber.Handler {
+func handleRegister(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, verifications *actionTokenStore, config Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req RegisterRequest
 		if err := c.BodyParser(&req); err != nil {
@@ -60,6 +202,10 @@ func handleRegister(db database.Database, userCRUD *crud.CRUD[models.User], jwt
 			return err
 		}
 
+		if err := config.PasswordPolicy.Check(req.Password, req.Email, req.Firstname, req.Lastname); err != nil {
+			return sendPasswordPolicyError(c, err)
+		}
+
 		password := req.Password
 		user := models.User{
 			ID:        uuid.New(),
@@ -69,29 +215,44 @@ func handleRegister(db database.Database, userCRUD *crud.CRUD[models.User], jwt
 			Lastname:  req.Lastname,
 			Role:      "user",
 			CreatedAt: time.Now(),
+
+			MustChangePassword: new(bool),
 		}
 
-		if err := user.HashPassword(); err != nil {
+		if err := user.HashPassword(config.PasswordHasher); err != nil {
 			return response.SendError(c, fiber.StatusInternalServerError, "failed to hash password")
 		}
 
-		if err := userCRUD.Create(ctx, user); err != nil {
+		if err := createUser(ctx, db, &user); err != nil {
 			return response.SendError(c, fiber.StatusInternalServerError, "failed to create user")
 		}
 
-		token, err := jwt.GenerateToken(user.ID.String())
+		if verifications != nil {
+			sendEmailVerificationAsync(verifications, config, user, requestLocale(c))
+		}
+
+		if loginBlocked(&user, config) {
+			return response.SendCreated(c, AuthResponse{User: &user})
+		}
+
+		auth := tokens.NewAuthentication(tokens.AMRPassword)
+		issued, err := issueTokens(ctx, tokenService, refreshTokens, &user, auth, config)
 		if err != nil {
 			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
 		}
 
 		return response.SendCreated(c, AuthResponse{
-			Token: token,
-			User:  &user,
+			Token:                 issued.Token,
+			RefreshToken:          issued.RefreshToken,
+			User:                  &user,
+			MFAEnrollmentRequired: mfaEnrollmentRequired(&user, auth, config),
 		})
 	}
 }
 
-func handleLogin(db database.Database, jwt *JWTService) fiber.Handler {
+// handleLogin checks the password, then asks for the second factor of users
+// who enrolled one.
+func handleLogin(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, mfa *mfaStore, config Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req LoginRequest
 		if err := c.BodyParser(&req); err != nil {
@@ -105,44 +266,217 @@ func handleLogin(db database.Database, jwt *JWTService) fiber.Handler {
 			return err
 		}
 
-		if !user.CheckPassword(req.Password) {
+		if !user.CheckPassword(config.PasswordHasher, req.Password) {
 			return response.SendError(c, fiber.StatusUnauthorized, "invalid email or password")
 		}
 
-		token, err := jwt.GenerateToken(user.ID.String())
+		if user.NeedsRehash(config.PasswordHasher) {
+			upgradePasswordHash(ctx, db, config.PasswordHasher, user, req.Password)
+		}
+
+		if loginBlocked(user, config) {
+			return response.SendError(c, fiber.StatusForbidden, "email address not verified")
+		}
+
+		methods, err := mfa.methods(ctx, user.ID)
 		if err != nil {
-			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read second factors")
 		}
 
-		return response.SendFormatted(c, fiber.StatusOK, AuthResponse{
-			Token: token,
-			User:  user,
-		})
+		auth := tokens.NewAuthentication(tokens.AMRPassword)
+		if len(methods) > 0 {
+			return sendMFAChallenge(c, tokenService, user, methods, auth, config)
+		}
+
+		return sendAuthResponse(c, tokenService, refreshTokens, user, auth, config)
 	}
 }
 
-func handleRefresh(jwt *JWTService) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		type RefreshRequest struct {
-			Token string `json:"token" validate:"required"`
-		}
+// sendAuthResponse issues the tokens of a user who completed login.
+func sendAuthResponse(c *fiber.Ctx, tokenService TokenService, refreshTokens *RefreshTokenStore, user *models.User, auth tokens.Authentication, config Config) error {
+	issued, err := issueTokens(c.Context(), tokenService, refreshTokens, user, auth, config)
+	if err != nil {
+		return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+	}
 
+	changeRequired := passwordChangeRequired(user, config)
+	return response.SendFormatted(c, fiber.StatusOK, AuthResponse{
+		Token:                  issued.Token,
+		RefreshToken:           issued.RefreshToken,
+		User:                   user,
+		PasswordChangeRequired: changeRequired,
+		MFAEnrollmentRequired:  !changeRequired && mfaEnrollmentRequired(user, auth, config),
+	})
+}
+
+// accessTokenOptions records how the user logged in and returns the
+// restrictions applying to their access tokens. A required password change
+// comes first, then a required second factor.
+func accessTokenOptions(user *models.User, auth tokens.Authentication, config Config) []TokenOption {
+	opts := []TokenOption{WithAuthentication(auth)}
+	switch {
+	case passwordChangeRequired(user, config):
+		opts = append(opts, WithRestriction(tokens.RestrictionPasswordChange))
+	case mfaEnrollmentRequired(user, auth, config):
+		opts = append(opts, WithRestriction(tokens.RestrictionMFAEnrollment))
+	case config.EmailVerification == EmailVerificationRestrict && !user.IsEmailVerified():
+		opts = append(opts, WithRestriction(tokens.RestrictionEmailUnverified))
+	}
+	return opts
+}
+
+func handleRefresh(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
 		var req RefreshRequest
 		if err := c.BodyParser(&req); err != nil {
 			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
 		}
 
-		newToken, err := jwt.RefreshToken(req.Token)
+		if req.RefreshToken == "" {
+			return response.SendError(c, fiber.StatusBadRequest, "refresh_token is required")
+		}
+
+		ctx := c.Context()
+
+		refreshToken, userID, auth, err := refreshTokens.Rotate(ctx, req.RefreshToken)
+		if errors.Is(err, ErrRefreshTokenInvalid) || errors.Is(err, ErrRefreshTokenExpired) || errors.Is(err, ErrRefreshTokenReused) {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid or expired refresh token")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to refresh token")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid or expired refresh token")
+		}
 		if err != nil {
-			return response.SendError(c, fiber.StatusUnauthorized, "invalid or expired token")
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to refresh token")
 		}
 
-		return response.SendFormatted(c, fiber.StatusOK, fiber.Map{
-			"token": newToken,
+		if loginBlocked(user, config) {
+			return response.SendError(c, fiber.StatusForbidden, "email address not verified")
+		}
+
+		token, err := tokenService.GenerateToken(ctx, user, accessTokenOptions(user, auth, config)...)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, TokenResponse{
+			Token:        token,
+			RefreshToken: refreshToken,
 		})
 	}
 }
 
+func handleLogout(tokenService TokenService, refreshTokens *RefreshTokenStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type LogoutRequest struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		var req LogoutRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+			}
+		}
+
+		ctx := c.Context()
+		claims, _ := authcontext.GetClaims(c)
+
+		if err := tokenService.Revoke(ctx, claims); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke token")
+		}
+
+		if req.RefreshToken != "" {
+			err := refreshTokens.RevokeFamily(ctx, req.RefreshToken)
+			if err != nil && !errors.Is(err, ErrRefreshTokenInvalid) {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke refresh token")
+			}
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func handleLogoutAll(tokenService TokenService, refreshTokens *RefreshTokenStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+		userID := authcontext.MustGetUserID(c)
+
+		if err := revokeSessions(ctx, tokenService, refreshTokens, userID); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke sessions")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// revokeSessions invalidates every access and refresh token of the user.
+func revokeSessions(ctx stdcontext.Context, tokenService TokenService, refreshTokens *RefreshTokenStore, userID string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	if err := refreshTokens.RevokeUser(ctx, id); err != nil {
+		return err
+	}
+
+	return tokenService.RevokeUser(ctx, userID)
+}
+
+// issueTokens creates an access token and starts a new refresh token family.
+func issueTokens(ctx stdcontext.Context, tokenService TokenService, refreshTokens *RefreshTokenStore, user *models.User, auth tokens.Authentication, config Config) (*TokenResponse, error) {
+	token, err := tokenService.GenerateToken(ctx, user, accessTokenOptions(user, auth, config)...)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := refreshTokens.Issue(ctx, user.ID, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// upgradePasswordHash rehashes the password with the current hasher. Login
+// goes on with the old hash when it fails.
+func upgradePasswordHash(ctx stdcontext.Context, db database.Database, hasher password.Hasher, user *models.User, plain string) {
+	previous := *user.Password
+
+	upgraded := models.User{Password: &plain}
+	if err := upgraded.HashPassword(hasher); err != nil {
+		log.Printf("[gorest-auth] password rehash: %v", err)
+		return
+	}
+
+	// Skip the update if the password changed since it was read.
+	queryStr, args, err := query.New(db.Dialect()).
+		Update("users").
+		Set("password", *upgraded.Password).
+		Where(query.Eq("id", user.ID)).
+		Where(query.Eq("password", previous)).
+		Build()
+	if err != nil {
+		log.Printf("[gorest-auth] password rehash: %v", err)
+		return
+	}
+
+	if _, err := db.Exec(ctx, queryStr, args...); err != nil {
+		log.Printf("[gorest-auth] password rehash: %v", err)
+		return
+	}
+
+	user.Password = upgraded.Password
+}
+
 func checkEmailExists(ctx stdcontext.Context, db database.Database, email string, excludeUserID uuid.UUID) error {
 	qb := query.New(db.Dialect()).
 		Select("email").
@@ -162,9 +496,9 @@ func checkEmailExists(ctx stdcontext.Context, db database.Database, email string
 	err = db.QueryRow(ctx, queryStr, args...).Scan(&existingEmail)
 	if err == nil {
 		if excludeUserID == uuid.Nil {
-			return fmt.Errorf("user with this email already exists")
+			return ErrEmailAlreadyExists
 		}
-		return fmt.Errorf("email already in use")
+		return ErrEmailInUse
 	}
 	if !crud.IsNotFoundError(err) {
 		return fmt.Errorf("failed to check existing email: %w", err)
@@ -173,11 +507,60 @@ func checkEmailExists(ctx stdcontext.Context, db database.Database, email string
 	return nil
 }
 
+// isUniqueViolation reports whether err is a unique constraint violation of
+// PostgreSQL, MySQL or SQLite.
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	errMsg := err.Error()
+	return strings.Contains(errMsg, "SQLSTATE 23505") ||
+		strings.Contains(errMsg, "duplicate key value violates unique constraint") ||
+		strings.Contains(errMsg, "Error 1062") ||
+		strings.Contains(errMsg, "UNIQUE constraint failed")
+}
+
 func getUserByEmail(ctx stdcontext.Context, db database.Database, email string) (*models.User, error) {
+	user, err := getUser(ctx, db, query.Eq("email", email))
+	if crud.IsNotFoundError(err) {
+		return nil, fmt.Errorf("invalid email or password")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return user, nil
+}
+
+// createUser inserts a registered user. The columns the user may not write
+// through the users resource, such as the role, are set by the server, so
+// the RBAC checks of the resource do not apply.
+func createUser(ctx stdcontext.Context, db database.Database, user *models.User) error {
+	queryStr, args, err := query.New(db.Dialect()).
+		Insert("users").
+		Columns("id", "firstname", "lastname", "email", "password", "role", "must_change_password", "created_at").
+		Values(user.ID, user.Firstname, user.Lastname, user.Email, user.Password, user.Role, user.PasswordChangeRequired(), user.CreatedAt).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
+func getUserByID(ctx stdcontext.Context, db database.Database, id uuid.UUID) (*models.User, error) {
+	return getUser(ctx, db, query.Eq("id", id))
+}
+
+func getUser(ctx stdcontext.Context, db database.Database, condition query.Condition) (*models.User, error) {
 	qb := query.New(db.Dialect()).
-		Select("id", "firstname", "lastname", "email", "password", "role", "created_at", "updated_at").
+		Select("id", "firstname", "lastname", "email", "password", "role", "created_at", "updated_at", "email_verified_at", "pending_email", "password_changed_at", "must_change_password").
 		From("users").
-		Where(query.Eq("email", email))
+		Where(condition)
 
 	queryStr, args, err := qb.Build()
 	if err != nil {
@@ -188,12 +571,9 @@ func getUserByEmail(ctx stdcontext.Context, db database.Database, email string)
 	var password *string
 	var updatedAt *time.Time
 	err = db.QueryRow(ctx, queryStr, args...).
-		Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email, &password, &user.Role, &user.CreatedAt, &updatedAt)
-	if crud.IsNotFoundError(err) {
-		return nil, fmt.Errorf("invalid email or password")
-	}
+		Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email, &password, &user.Role, &user.CreatedAt, &updatedAt, &user.EmailVerifiedAt, &user.PendingEmail, &user.PasswordChangedAt, &user.MustChangePassword)
 	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
+		return nil, err
 	}
 
 	user.Password = password
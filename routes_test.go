@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLogout(t *testing.T) {
+	for _, backend := range []string{"database", "memory"} {
+		t.Run(backend, func(t *testing.T) {
+			app, _, db := newTestApp(t, map[string]interface{}{"revocation_store": backend})
+			createTestUser(t, db, "jane@example.com", "user")
+			token, refreshToken := login(t, app, "jane@example.com", testPassword)
+			otherToken, otherRefreshToken := login(t, app, "jane@example.com", testPassword)
+
+			if status, _ := request(t, app, "POST", "/auth/logout", "", nil); status != fiber.StatusUnauthorized {
+				t.Fatalf("expected 401 without a token, got %d", status)
+			}
+
+			status, result := request(t, app, "POST", "/auth/logout", token, map[string]string{"refresh_token": refreshToken})
+			if status != fiber.StatusNoContent {
+				t.Fatalf("expected 204, got %d %v", status, result)
+			}
+
+			if status, _ := request(t, app, "POST", "/auth/logout", token, nil); status != fiber.StatusUnauthorized {
+				t.Fatalf("expected the revoked token to be rejected, got %d", status)
+			}
+			if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}); status != fiber.StatusUnauthorized {
+				t.Fatalf("expected the revoked refresh token to be rejected, got %d", status)
+			}
+
+			// Other sessions are kept.
+			if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": otherRefreshToken}); status != fiber.StatusOK {
+				t.Fatalf("expected another session to be kept, got %d", status)
+			}
+			if status, _ := request(t, app, "POST", "/auth/logout", otherToken, nil); status != fiber.StatusNoContent {
+				t.Fatalf("expected another session to be kept, got %d", status)
+			}
+		})
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	app, _, db := newTestApp(t, nil)
+	createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "john@example.com", "user")
+	token, refreshToken := login(t, app, "jane@example.com", testPassword)
+	otherToken, otherRefreshToken := login(t, app, "jane@example.com", testPassword)
+	johnToken, _ := login(t, app, "john@example.com", testPassword)
+
+	if status, result := request(t, app, "POST", "/auth/logout-all", token, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d %v", status, result)
+	}
+
+	for _, revoked := range []string{token, otherToken} {
+		if status, _ := request(t, app, "POST", "/auth/logout", revoked, nil); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected a revoked token to be rejected, got %d", status)
+		}
+	}
+	for _, revoked := range []string{refreshToken, otherRefreshToken} {
+		if status, _ := request(t, app, "POST", "/auth/refresh", "", map[string]string{"refresh_token": revoked}); status != fiber.StatusUnauthorized {
+			t.Fatalf("expected a revoked refresh token to be rejected, got %d", status)
+		}
+	}
+
+	// Sessions of other users are kept, and new logins are valid.
+	if status, _ := request(t, app, "POST", "/auth/logout", johnToken, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected the session of another user to be kept, got %d", status)
+	}
+	newToken, _ := login(t, app, "jane@example.com", testPassword)
+	if status, _ := request(t, app, "POST", "/auth/logout", newToken, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected a new login to be accepted, got %d", status)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	app, plugin, _ := newTestApp(t, nil)
+
+	result := register(t, app, "jane@example.com")
+	token, _ := result["token"].(string)
+	claims, err := plugin.TokenService().ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := result["user"].(map[string]interface{})
+	if claims.Subject != user["id"] || user["role"] != "user" || user["must_change_password"] != false {
+		t.Fatalf("unexpected user: %v", user)
+	}
+
+	login(t, app, "jane@example.com", testPassword)
+
+	status, _ := request(t, app, "POST", "/auth/register", "", map[string]string{
+		"email":     "john@example.com",
+		"password":  "short",
+		"firstname": "John",
+		"lastname":  "Doe",
+	})
+	if status != fiber.StatusBadRequest {
+		t.Fatalf("expected a weak password to be rejected, got %d", status)
+	}
+}
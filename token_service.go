@@ -0,0 +1,291 @@
+package auth
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/revocation"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+const (
+	TokenFormatJWT          = "jwt"
+	TokenFormatPasetoPublic = "paseto.v4.public"
+	TokenFormatPasetoLocal  = "paseto.v4.local"
+)
+
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenNotYetValid      = errors.New("token is not valid yet")
+	ErrTokenInvalidIssuer    = errors.New("token has invalid issuer")
+	ErrTokenInvalidAudience  = errors.New("token has invalid audience")
+	ErrTokenInvalid          = errors.New("token is invalid")
+)
+
+// TokenService issues and validates access tokens. JWTService and
+// PasetoService implement it with the same claims and revocation semantics.
+type TokenService interface {
+	GenerateToken(ctx stdcontext.Context, user *models.User, opts ...TokenOption) (string, error)
+	ValidateToken(token string) (*tokens.Claims, error)
+	IsRevoked(ctx stdcontext.Context, claims *tokens.Claims) (bool, error)
+	Revoke(ctx stdcontext.Context, claims *tokens.Claims) error
+	RevokeUser(ctx stdcontext.Context, userID string) error
+}
+
+// TokenOption customizes the claims of a single token.
+type TokenOption func(*tokens.Claims)
+
+// WithRestriction issues a token only accepted by routes allowing the
+// restriction.
+func WithRestriction(restriction string) TokenOption {
+	return func(claims *tokens.Claims) {
+		claims.Restriction = restriction
+	}
+}
+
+// WithAuthentication records how the user logged in into the amr, acr and
+// auth_time claims.
+func WithAuthentication(auth tokens.Authentication) TokenOption {
+	return func(claims *tokens.Claims) {
+		claims.AMR = auth.Methods
+		claims.ACR = auth.Level
+		claims.AuthTime = auth.Time
+	}
+}
+
+// WithTTL overrides the lifetime of the token.
+func WithTTL(ttl time.Duration) TokenOption {
+	return func(claims *tokens.Claims) {
+		claims.ExpiresAt = claims.IssuedAt.Add(ttl)
+	}
+}
+
+// NewTokenServiceFromConfig creates the token service matching the
+// configured token format.
+func NewTokenServiceFromConfig(config Config) (TokenService, error) {
+	switch config.TokenFormat {
+	case "", TokenFormatJWT:
+		service, err := NewJWTServiceFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return service, nil
+	case TokenFormatPasetoPublic, TokenFormatPasetoLocal:
+		service, err := NewPasetoServiceFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return service, nil
+	default:
+		return nil, fmt.Errorf("unknown token format: %s", config.TokenFormat)
+	}
+}
+
+// tokenPolicy holds the settings shared by every token format: lifetime,
+// issuer and audience, clock skew, custom claims and revocation.
+type tokenPolicy struct {
+	ttl         int
+	revocations revocation.Store
+
+	// revokedUntil is the end, in unix milliseconds, of the millisecond of
+	// the last user revocation. Tokens are issued after it.
+	revokedUntil *atomic.Int64
+
+	issuer    string
+	audiences []string
+	leeway    time.Duration
+
+	claimsProvider ClaimsProvider
+	embedRoles     bool
+}
+
+func newTokenPolicy(ttl int) tokenPolicy {
+	return tokenPolicy{
+		ttl:          ttl,
+		revocations:  revocation.NewMemoryStore(),
+		revokedUntil: new(atomic.Int64),
+	}
+}
+
+func (p *tokenPolicy) configure(config Config) {
+	p.SetIssuer(config.Issuer)
+	p.SetAudiences(config.Audiences...)
+	p.SetClockSkew(time.Duration(config.ClockSkew) * time.Second)
+	if config.RevocationStore != nil {
+		p.SetRevocationStore(config.RevocationStore)
+	}
+	if config.ClaimsProvider != nil {
+		p.SetClaimsProvider(config.ClaimsProvider)
+	}
+	p.SetEmbedRoles(config.EmbedRoles)
+}
+
+func (p *tokenPolicy) SetRevocationStore(store revocation.Store) {
+	p.revocations = store
+}
+
+func (p *tokenPolicy) SetClaimsProvider(provider ClaimsProvider) {
+	p.claimsProvider = provider
+}
+
+// SetEmbedRoles signs the role of the user into the "roles" claim of issued
+// tokens.
+func (p *tokenPolicy) SetEmbedRoles(embed bool) {
+	p.embedRoles = embed
+}
+
+// SetIssuer sets the "iss" claim of issued tokens and requires it on
+// validated tokens. An empty issuer disables the check.
+func (p *tokenPolicy) SetIssuer(issuer string) {
+	p.issuer = issuer
+}
+
+// SetAudiences sets the "aud" claim of issued tokens and requires validated
+// tokens to target at least one of them. No audience disables the check.
+func (p *tokenPolicy) SetAudiences(audiences ...string) {
+	p.audiences = audiences
+}
+
+// SetClockSkew sets the tolerance applied to the exp, nbf and iat claims.
+func (p *tokenPolicy) SetClockSkew(leeway time.Duration) {
+	p.leeway = leeway
+}
+
+// IsRevoked reports whether validated claims belong to a token revoked
+// through Revoke or RevokeUser.
+func (p *tokenPolicy) IsRevoked(ctx stdcontext.Context, claims *tokens.Claims) (bool, error) {
+	return p.revocations.IsRevoked(ctx, claims.ID, claims.Subject, claims.IssuedAt)
+}
+
+// Revoke rejects the token for the rest of its lifetime.
+func (p *tokenPolicy) Revoke(ctx stdcontext.Context, claims *tokens.Claims) error {
+	if claims.ID == "" {
+		return fmt.Errorf("jti not found in token")
+	}
+
+	return p.revocations.Revoke(ctx, claims.ID, claims.ExpiresAt)
+}
+
+// RevokeUser rejects every token issued to the user so far. Tokens issued
+// afterwards, even within the same second, stay valid.
+func (p *tokenPolicy) RevokeUser(ctx stdcontext.Context, userID string) error {
+	// Issue times have a millisecond precision: the cutoff is the end of the
+	// current millisecond, and tokens are only issued once it is over.
+	cutoff := time.Now().Truncate(revocation.Precision).Add(revocation.Precision)
+	p.revokedUntil.Store(cutoff.UnixMilli())
+
+	return p.revocations.RevokeUser(ctx, userID, cutoff)
+}
+
+// issueTime returns the current time truncated to the precision of
+// revocations, waiting for the end of the millisecond of a revocation.
+func (p *tokenPolicy) issueTime() time.Time {
+	if wait := time.Until(time.UnixMilli(p.revokedUntil.Load())); wait > 0 {
+		time.Sleep(wait)
+	}
+	return time.Now().Truncate(revocation.Precision)
+}
+
+// newClaims builds the claims of a token issued to the user now.
+func (p *tokenPolicy) newClaims(ctx stdcontext.Context, user *models.User, opts []TokenOption) (*tokens.Claims, error) {
+	claims := &tokens.Claims{
+		Custom: make(map[string]any),
+	}
+
+	if p.claimsProvider != nil {
+		custom, err := p.claimsProvider.Claims(ctx, user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build custom claims: %w", err)
+		}
+		for name, value := range custom {
+			if tokens.IsReserved(name) {
+				return nil, fmt.Errorf("custom claims cannot override reserved claim %q", name)
+			}
+			claims.Custom[name] = value
+		}
+	}
+
+	now := p.issueTime()
+	claims.ID = uuid.NewString()
+	claims.Subject = user.ID.String()
+	claims.Issuer = p.issuer
+	claims.Audience = p.audiences
+	claims.IssuedAt = now
+	claims.NotBefore = now
+	claims.ExpiresAt = now.Add(time.Duration(p.ttl) * time.Second)
+	if p.embedRoles && user.Role != "" {
+		claims.Roles = []string{user.Role}
+	}
+
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	if claims.Restriction != "" {
+		claims.Audience = []string{tokens.RestrictedAudience}
+		for _, audience := range p.audiences {
+			claims.Audience = append(claims.Audience, tokens.RestrictedAudienceFor(audience))
+		}
+	}
+
+	return claims, nil
+}
+
+// checkClaims enforces the registered claims for token formats whose parser
+// does not do it itself.
+func (p *tokenPolicy) checkClaims(claims *tokens.Claims) error {
+	now := time.Now()
+
+	if claims.ExpiresAt.IsZero() {
+		return fmt.Errorf("%w: exp claim is required", ErrTokenInvalid)
+	}
+	if now.After(claims.ExpiresAt.Add(p.leeway)) {
+		return ErrTokenExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(p.leeway).Before(claims.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(p.leeway).Before(claims.IssuedAt) {
+		return ErrTokenNotYetValid
+	}
+	if p.issuer != "" && claims.Issuer != p.issuer {
+		return ErrTokenInvalidIssuer
+	}
+	if err := p.checkAudience(claims); err != nil {
+		return err
+	}
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: subject not found in token", ErrTokenInvalid)
+	}
+
+	return nil
+}
+
+// checkAudience requires restricted tokens to target RestrictedAudience and
+// tokens to target at least one of the configured audiences, in its
+// RestrictedAudienceFor form for restricted tokens.
+func (p *tokenPolicy) checkAudience(claims *tokens.Claims) error {
+	restricted := slices.Contains(claims.Audience, tokens.RestrictedAudience)
+	if restricted != (claims.Restriction != "") {
+		return ErrTokenInvalidAudience
+	}
+
+	if len(p.audiences) > 0 && !slices.ContainsFunc(p.audiences, func(audience string) bool {
+		if restricted {
+			audience = tokens.RestrictedAudienceFor(audience)
+		}
+		return slices.Contains(claims.Audience, audience)
+	}) {
+		return ErrTokenInvalidAudience
+	}
+
+	return nil
+}
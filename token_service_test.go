@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func TestRevokeUserKeepsTokensIssuedAfterwards(t *testing.T) {
+	paseto, err := NewPasetoLocalService(make([]byte, 32), 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, service := range map[string]TokenService{
+		"jwt":    newTestJWTService(t),
+		"paseto": paseto,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := testUser()
+
+			before, err := service.GenerateToken(ctx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := service.RevokeUser(ctx, user.ID.String()); err != nil {
+				t.Fatal(err)
+			}
+			after, err := service.GenerateToken(ctx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for token, expected := range map[string]bool{before: true, after: false} {
+				claims, err := service.ValidateToken(token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.IssuedAt.Nanosecond()%int(time.Millisecond) != 0 {
+					t.Fatalf("issue time not truncated to the millisecond: %s", claims.IssuedAt)
+				}
+				revoked, err := service.IsRevoked(ctx, claims)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if revoked != expected {
+					t.Fatalf("expected revoked=%v for a token issued at %s", expected, claims.IssuedAt.Format(time.RFC3339Nano))
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedRoles(t *testing.T) {
+	service := newTestJWTService(t)
+	user := testUser()
+
+	tests := []struct {
+		embed    bool
+		expected []string
+	}{
+		{false, nil},
+		{true, []string{user.Role}},
+	}
+
+	for _, tt := range tests {
+		service.SetEmbedRoles(tt.embed)
+		token, err := service.GenerateToken(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := service.ValidateToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(claims.Roles, tt.expected) {
+			t.Fatalf("embed %t: expected roles %v, got %v", tt.embed, tt.expected, claims.Roles)
+		}
+	}
+}
+
+func TestRestrictedTokensTargetRestrictedAudience(t *testing.T) {
+	service := newTestJWTService(t)
+	service.SetAudiences("api")
+
+	ctx := context.Background()
+	restricted, err := service.GenerateToken(ctx, testUser(), WithRestriction(tokens.RestrictionMFA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrestricted, err := service.GenerateToken(ctx, testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := service.ValidateToken(restricted)
+	if err != nil {
+		t.Fatalf("restricted token rejected: %v", err)
+	}
+	if claims.Restriction != tokens.RestrictionMFA || !slices.Equal(claims.Audience, []string{tokens.RestrictedAudience, tokens.RestrictedAudienceFor("api")}) {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	claims, err = service.ValidateToken(unrestricted)
+	if err != nil {
+		t.Fatalf("unrestricted token rejected: %v", err)
+	}
+	if !slices.Equal(claims.Audience, []string{"api"}) {
+		t.Fatalf("unexpected audience: %v", claims.Audience)
+	}
+
+	// A downstream service verifying the signature and its audience.
+	keyFunc := func(*jwt.Token) (interface{}, error) { return []byte(testSecret), nil }
+	if _, err := jwt.Parse(restricted, keyFunc, jwt.WithAudience("api")); err == nil {
+		t.Fatal("downstream verifier accepted a restricted token")
+	}
+	if _, err := jwt.Parse(unrestricted, keyFunc, jwt.WithAudience("api")); err != nil {
+		t.Fatalf("downstream verifier rejected an access token: %v", err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(restricted, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["typ"] != tokens.TypeRestricted {
+		t.Fatalf("expected typ %s, got %v", tokens.TypeRestricted, parsed.Header["typ"])
+	}
+}
+
+func TestRestrictedTokensBoundToAudience(t *testing.T) {
+	service := newTestJWTService(t)
+	service.SetAudiences("api")
+	token, err := service.GenerateToken(context.Background(), testUser(), WithRestriction(tokens.RestrictionMFA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another application sharing the keys rejects it.
+	other := NewJWTServiceWithKeyring(service.Keyring(), 900)
+	other.SetAudiences("admin")
+	if _, err := other.ValidateToken(token); !errors.Is(err, ErrTokenInvalidAudience) {
+		t.Fatalf("expected ErrTokenInvalidAudience, got %v", err)
+	}
+
+	other.SetAudiences("admin", "api")
+	if _, err := other.ValidateToken(token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRestrictionMustMatchAudienceAndType(t *testing.T) {
+	service := newTestJWTService(t)
+	key := service.Keyring().Current()
+
+	sign := func(claims jwt.MapClaims, typ string) string {
+		t.Helper()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = key.ID
+		if typ != "" {
+			token.Header["typ"] = typ
+		}
+		signed, err := token.SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	base := func() jwt.MapClaims {
+		user := testUser()
+		return jwt.MapClaims{"sub": user.ID.String(), "exp": 4102444800, "iat": 1700000000}
+	}
+
+	withoutAudience := base()
+	withoutAudience["restriction"] = tokens.RestrictionMFA
+	if _, err := service.ValidateToken(sign(withoutAudience, tokens.TypeRestricted)); !errors.Is(err, ErrTokenInvalidAudience) {
+		t.Fatalf("expected ErrTokenInvalidAudience, got %v", err)
+	}
+
+	withoutRestriction := base()
+	withoutRestriction["aud"] = tokens.RestrictedAudience
+	if _, err := service.ValidateToken(sign(withoutRestriction, tokens.TypeRestricted)); !errors.Is(err, ErrTokenInvalidAudience) {
+		t.Fatalf("expected ErrTokenInvalidAudience, got %v", err)
+	}
+
+	withoutType := base()
+	withoutType["restriction"] = tokens.RestrictionMFA
+	withoutType["aud"] = tokens.RestrictedAudience
+	if _, err := service.ValidateToken(sign(withoutType, "")); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("expected ErrTokenInvalid, got %v", err)
+	}
+}
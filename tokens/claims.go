@@ -0,0 +1,168 @@
+package tokens
+
+import (
+	"slices"
+	"time"
+)
+
+// Claims are the validated claims of an access token, independent of the
+// token format.
+type Claims struct {
+	ID        string
+	Subject   string
+	Issuer    string
+	Audience  []string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+
+	// Roles are only set when roles are embedded in the token.
+	Roles []string
+
+	// Restriction limits the token to the routes that explicitly accept it,
+	// e.g. while the email address of the user is not verified.
+	Restriction string
+
+	// AMR lists the authentication methods used at login (RFC 8176), ACR is
+	// the resulting assurance level and AuthTime when the user last
+	// authenticated. Refreshed tokens keep the values of the login.
+	AMR      []string
+	ACR      string
+	AuthTime time.Time
+
+	// Custom holds application specific claims, such as the ones added by a
+	// claims provider.
+	Custom map[string]any
+}
+
+var reservedClaims = map[string]bool{
+	"jti":         true,
+	"sub":         true,
+	"iss":         true,
+	"aud":         true,
+	"iat":         true,
+	"nbf":         true,
+	"exp":         true,
+	"user_id":     true,
+	"roles":       true,
+	"restriction": true,
+	"amr":         true,
+	"acr":         true,
+	"auth_time":   true,
+}
+
+const (
+	// RestrictionEmailUnverified is set on tokens issued to users who have
+	// not verified their email address yet.
+	RestrictionEmailUnverified = "email_unverified"
+
+	// RestrictionPasswordChange is set on tokens issued to users whose
+	// password expired or was flagged by an admin, until they change it.
+	RestrictionPasswordChange = "password_change"
+
+	// RestrictionMFA is set on the challenge tokens issued after the
+	// password of a user with a second factor was checked. They are only
+	// accepted to complete the login with the second factor.
+	RestrictionMFA = "mfa"
+
+	// RestrictionMFAEnrollment is set on tokens issued to users whose role
+	// requires a second factor they have not enrolled yet. They are only
+	// accepted to enroll one.
+	RestrictionMFAEnrollment = "mfa_enrollment"
+)
+
+// Restricted tokens target RestrictedAudience and the RestrictedAudienceFor
+// form of the configured audiences instead of the audiences themselves, and
+// JWTs carry the TypeRestricted "typ" header, so that services verifying the
+// access tokens of the plugin with its public keys reject them.
+const (
+	RestrictedAudience = "urn:gorest-auth:restricted"
+	TypeRestricted     = "restricted+jwt"
+)
+
+// RestrictedAudienceFor binds restricted tokens to the audience, so that
+// another application sharing the signing keys rejects them.
+func RestrictedAudienceFor(audience string) string {
+	return RestrictedAudience + ":" + audience
+}
+
+// Authentication methods recorded in the "amr" claim. AMREmail, for codes
+// and links sent by email, is not registered by RFC 8176.
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMREmail       = "email"
+	AMRMultiFactor = "mfa"
+)
+
+// Assurance levels recorded in the "acr" claim, after NIST SP 800-63B.
+const (
+	ACRSingleFactor = "aal1"
+	ACRMultiFactor  = "aal2"
+)
+
+// Authentication describes how and when a user logged in.
+type Authentication struct {
+	Methods []string
+	Level   string
+	Time    time.Time
+}
+
+// NewAuthentication records a login completed now with the given methods.
+// Two different methods, or AMRMultiFactor, make it a multi-factor login.
+func NewAuthentication(methods ...string) Authentication {
+	var factors []string
+	multiFactor := false
+	for _, method := range methods {
+		switch {
+		case method == AMRMultiFactor:
+			multiFactor = true
+		case method != "" && !slices.Contains(factors, method):
+			factors = append(factors, method)
+		}
+	}
+
+	auth := Authentication{
+		Methods: factors,
+		Level:   ACRSingleFactor,
+		Time:    time.Now().Truncate(time.Second),
+	}
+	if multiFactor || len(factors) > 1 {
+		auth.Methods = append(auth.Methods, AMRMultiFactor)
+		auth.Level = ACRMultiFactor
+	}
+
+	return auth
+}
+
+// IsReserved reports whether name is a claim managed by the token service
+// that custom claims cannot override.
+func IsReserved(name string) bool {
+	return reservedClaims[name]
+}
+
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+// Authentication returns how the user logged in to get the token.
+func (c *Claims) Authentication() Authentication {
+	return Authentication{
+		Methods: c.AMR,
+		Level:   c.ACR,
+		Time:    c.AuthTime,
+	}
+}
+
+func (c *Claims) Get(name string) (any, bool) {
+	value, ok := c.Custom[name]
+	return value, ok
+}
+
+// GetString returns the custom claim as a string, or an empty string when it
+// is missing or not a string.
+func (c *Claims) GetString(name string) string {
+	value, _ := c.Custom[name].(string)
+	return value
+}
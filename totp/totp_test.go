@@ -0,0 +1,108 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Fatalf("T=%d: expected %s, got %s", tt.unix, tt.code, code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for delta := int64(-2); delta <= 2; delta++ {
+		code, err := Code(rfcSecret, current+delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now, 1)
+		expected := delta >= -1 && delta <= 1
+		if ok != expected {
+			t.Fatalf("step %+d: expected ok=%v", delta, expected)
+		}
+		if ok && step != current+delta {
+			t.Fatalf("step %+d: expected matched step %d, got %d", delta, current+delta, step)
+		}
+
+		if _, ok := Validate(rfcSecret, code, now, 0); ok != (delta == 0) {
+			t.Fatalf("step %+d: unexpected result without skew", delta)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	if _, ok := Validate(rfcSecret, "287 082", now, 0); !ok {
+		t.Fatal("expected spaces to be ignored")
+	}
+	for _, code := range []string{"", "28708", "2870820", "abcdef", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Fatalf("expected %q to be rejected", code)
+		}
+	}
+	if _, ok := Validate("not base32!", "287082", now, 1); ok {
+		t.Fatal("expected an invalid secret to be rejected")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != SecretSize {
+		t.Fatalf("expected a %d byte secret, got %d", SecretSize, len(key))
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(ProvisioningURI("Example App", "jane@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Example App:jane@example.com" {
+		t.Fatalf("unexpected URI: %s", uri)
+	}
+
+	values := uri.Query()
+	if values.Get("secret") != rfcSecret || values.Get("issuer") != "Example App" || values.Get("digits") != "6" || values.Get("period") != "30" {
+		t.Fatalf("unexpected parameters: %v", values)
+	}
+}
@@ -1,20 +1,29 @@
 package auth
 
 import (
+	stdcontext "context"
+	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/context"
 	"github.com/nicolasbonnici/gorest-auth/converters"
 	"github.com/nicolasbonnici/gorest-auth/dtos"
 	"github.com/nicolasbonnici/gorest-auth/hooks"
 	"github.com/nicolasbonnici/gorest-auth/middleware"
 	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/password"
+	"github.com/nicolasbonnici/gorest-auth/password/history"
 	"github.com/nicolasbonnici/gorest/crud"
 	"github.com/nicolasbonnici/gorest/database"
 	"github.com/nicolasbonnici/gorest/filter"
 	"github.com/nicolasbonnici/gorest/pagination"
 	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/rbac"
 	"github.com/nicolasbonnici/gorest/response"
 )
 
@@ -22,26 +31,48 @@ type UserResource struct {
 	db        database.Database
 	crud      *crud.CRUD[models.User]
 	hooks     *hooks.UserHooks
+	history   *history.Store
 	converter *converters.UserConverter
+	roleCache *middleware.RoleCache
+	tokens    TokenService
+
+	// stepUpMaxAge is how recent the second factor of an admin changing roles
+	// or deleting users must be. Zero disables the check.
+	stepUpMaxAge time.Duration
+
+	// confirmEmailChanges sends email changes through POST /auth/email/change.
+	confirmEmailChanges bool
 }
 
-func RegisterUserRoutes(router fiber.Router, db database.Database, jwt *JWTService) {
-	authMiddleware := middleware.AuthMiddleware(jwt, db)
-	optionalAuth := middleware.OptionalAuthMiddleware(jwt, db)
+func RegisterUserRoutes(router fiber.Router, db database.Database, tokenService TokenService, config Config) {
+	authMiddleware := middleware.AuthMiddleware(tokenService, db, config.MiddlewareOptions()...)
+	optionalAuth := middleware.OptionalAuthMiddleware(tokenService, db, config.MiddlewareOptions()...)
 
 	rbacConfig := GetRBACConfig()
 	userHooks := hooks.NewUserHooks(db, rbacConfig)
+	userHooks.SetPasswordPolicy(config.PasswordPolicy)
+	userHooks.SetPasswordHasher(config.PasswordHasher)
+	passwordHistory := history.NewStore(db, config.PasswordHistory, config.PasswordHasher)
+	userHooks.SetPasswordHistory(passwordHistory)
 
 	resource := &UserResource{
 		db:        db,
 		crud:      crud.NewWithHooks[models.User](db, userHooks),
 		hooks:     userHooks,
+		history:   passwordHistory,
 		converter: &converters.UserConverter{},
+		roleCache: config.RoleCache,
+		tokens:    tokenService,
+
+		stepUpMaxAge: time.Duration(config.AdminStepUpMaxAge) * time.Second,
+
+		confirmEmailChanges: config.Mailer != nil,
 	}
 
 	router.Get("/users", optionalAuth, resource.GetAll)
 	router.Get("/users/:id", optionalAuth, resource.GetByID)
 	router.Put("/users/:id", authMiddleware, resource.Update)
+	router.Delete("/users/:id", authMiddleware, resource.Delete)
 }
 
 func (r *UserResource) GetByID(c *fiber.Ctx) error {
@@ -128,15 +159,216 @@ func (r *UserResource) Update(c *fiber.Ctx) error {
 		return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
 	}
 
+	if dto.Email != nil {
+		if err := r.checkEmailUpdate(c.Context(), id, *dto.Email); err != nil {
+			return response.SendError(c, err.Code, err.Message)
+		}
+	}
+
+	if dto.Password != nil {
+		if err := r.checkPasswordUpdate(c.UserContext(), id); err != nil {
+			return response.SendError(c, err.Code, err.Message)
+		}
+	}
+
 	model := r.converter.UpdateDTOToModel(dto)
 
-	if err := r.crud.Update(c.Context(), id, model); err != nil {
+	// Users who may not change the role are denied before being asked for
+	// a second factor.
+	if dto.Role != nil && r.stepUpMaxAge > 0 {
+		if err := r.authorizeUpdate(c.UserContext(), id, &model); err != nil {
+			return response.SendError(c, fiber.StatusForbidden, "permission denied")
+		}
+		if !r.stepUpSatisfied(c) {
+			return middleware.SendStepUpRequired(c, r.stepUpMaxAge)
+		}
+	}
+
+	var err error
+	if dto.Password != nil {
+		err = r.updatePassword(c.UserContext(), id, model)
+	} else {
+		err = r.crud.Update(c.UserContext(), id, model)
+	}
+	if err != nil {
 		if crud.IsNotFoundError(err) {
 			return response.SendError(c, fiber.StatusNotFound, "user not found")
 		}
+		var fieldErr *rbac.ValidationError
+		if errors.Is(err, rbac.ErrPermissionDenied) || errors.As(err, &fieldErr) {
+			return response.SendError(c, fiber.StatusForbidden, "permission denied")
+		}
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			return sendPasswordPolicyError(c, err)
+		}
 		return response.SendError(c, fiber.StatusInternalServerError, "database error")
 	}
 
+	if r.roleCache != nil {
+		r.roleCache.Invalidate(id)
+	}
+
 	dto2 := r.converter.ModelToResponseDTO(model)
 	return response.SendFormatted(c, fiber.StatusOK, dto2)
 }
+
+// Delete removes a user, their sessions and every token issued to them. Only
+// admins may delete users.
+func (r *UserResource) Delete(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.SendError(c, fiber.StatusBadRequest, "invalid user ID")
+	}
+
+	ctx := c.UserContext()
+
+	// Only admins learn whether the user exists, and only they are asked
+	// for a second factor.
+	if err := r.hooks.CheckDelete(ctx, id); err != nil {
+		return response.SendError(c, fiber.StatusForbidden, "permission denied")
+	}
+
+	if r.stepUpMaxAge > 0 && !r.stepUpSatisfied(c) {
+		return middleware.SendStepUpRequired(c, r.stepUpMaxAge)
+	}
+
+	if _, err := getUserByID(ctx, r.db, id); err != nil {
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusNotFound, "user not found")
+		}
+		return response.SendError(c, fiber.StatusInternalServerError, "database error")
+	}
+
+	// The revocation outlives the user, as tokens embedding roles are
+	// accepted without a lookup; refresh tokens are deleted with the user.
+	if err := r.tokens.RevokeUser(ctx, id.String()); err != nil {
+		return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke tokens")
+	}
+
+	if err := r.crud.Delete(ctx, id); err != nil {
+		if errors.Is(err, rbac.ErrPermissionDenied) {
+			return response.SendError(c, fiber.StatusForbidden, "permission denied")
+		}
+		return response.SendError(c, fiber.StatusInternalServerError, "database error")
+	}
+
+	if r.roleCache != nil {
+		r.roleCache.Invalidate(id.String())
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// authorizeUpdate runs the field and record permission checks of the update
+// ahead of crud.Update, which runs them again.
+func (r *UserResource) authorizeUpdate(ctx stdcontext.Context, id string, model *models.User) error {
+	if err := r.hooks.ValidateWrite(ctx, model); err != nil {
+		return err
+	}
+	return r.hooks.CheckUpdate(ctx, id, model)
+}
+
+// stepUpSatisfied reports whether the current user used a second factor
+// within stepUpMaxAge.
+func (r *UserResource) stepUpSatisfied(c *fiber.Ctx) bool {
+	claims, _ := context.GetClaims(c)
+	return middleware.StepUpSatisfied(claims, r.stepUpMaxAge)
+}
+
+// checkEmailUpdate rejects email updates that must be confirmed, or that
+// would take the address of another user.
+func (r *UserResource) checkEmailUpdate(ctx stdcontext.Context, id string, email string) *fiber.Error {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid user ID")
+	}
+
+	if r.confirmEmailChanges {
+		user, err := getUserByID(ctx, r.db, userID)
+		if crud.IsNotFoundError(err) {
+			return fiber.NewError(fiber.StatusNotFound, "user not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "database error")
+		}
+
+		if !strings.EqualFold(user.Email, email) {
+			return fiber.NewError(fiber.StatusBadRequest, "email changes must be confirmed through POST /auth/email/change")
+		}
+		return nil
+	}
+
+	err = checkEmailExists(ctx, r.db, email, userID)
+	if errors.Is(err, ErrEmailInUse) {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "database error")
+	}
+
+	return nil
+}
+
+// checkPasswordUpdate only lets admins set the password of other users, e.g.
+// a temporary one. Users change their own password through
+// POST /auth/password/change, which requires the current one.
+func (r *UserResource) checkPasswordUpdate(ctx stdcontext.Context, id string) *fiber.Error {
+	roles, _ := rbac.GetRoles(ctx)
+	userID, _ := rbac.GetUserID(ctx)
+	if !r.hooks.GetVoter().IsSuperuser(roles) || userID == id {
+		return fiber.NewError(fiber.StatusForbidden, "password changes must be made through POST /auth/password/change")
+	}
+
+	return nil
+}
+
+// updatePassword runs an update setting a new password in a transaction, and
+// keeps the replaced password in the history once the update succeeded.
+func (r *UserResource) updatePassword(ctx stdcontext.Context, id string, model models.User) error {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	previous, err := r.history.Current(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	users := crud.NewWithHooks[models.User](txDatabase{Database: r.db, tx: tx}, r.hooks)
+	if err := users.Update(ctx, id, model); err != nil {
+		return err
+	}
+
+	if err := r.history.Record(ctx, tx, userID, previous); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// txDatabase runs the queries of a database in a transaction, e.g. to run a
+// generic CRUD operation along with other writes.
+type txDatabase struct {
+	database.Database
+	tx database.Tx
+}
+
+func (d txDatabase) Query(ctx stdcontext.Context, query string, args ...interface{}) (database.Rows, error) {
+	return d.tx.Query(ctx, query, args...)
+}
+
+func (d txDatabase) QueryRow(ctx stdcontext.Context, query string, args ...interface{}) database.Row {
+	return d.tx.QueryRow(ctx, query, args...)
+}
+
+func (d txDatabase) Exec(ctx stdcontext.Context, query string, args ...interface{}) (database.Result, error) {
+	return d.tx.Exec(ctx, query, args...)
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest/database"
+)
+
+func TestUpdateUserRoleInvalidatesRoleCache(t *testing.T) {
+	app, plugin, db := newTestApp(t, map[string]interface{}{"embed_roles": true, "role_cache_ttl": 60})
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "admin@example.com", "admin")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	adminToken, _ := login(t, app, "admin@example.com", testPassword)
+	path := "/users/" + userID.String()
+
+	claims, err := plugin.TokenService().ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "user" {
+		t.Fatalf("expected the role to be embedded, got %v", claims.Roles)
+	}
+
+	plugin.config.RoleCache.Set(userID.String(), []string{"user"})
+	if status, result := request(t, app, "PUT", path, adminToken, map[string]interface{}{"role": "admin"}); status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+	if _, ok := plugin.config.RoleCache.Get(userID.String()); ok {
+		t.Fatal("expected the cached roles to be invalidated")
+	}
+}
+
+func TestDeleteUserRevokesTokens(t *testing.T) {
+	app, _, db := newTestApp(t, map[string]interface{}{
+		"embed_roles":           true,
+		"admin_step_up_max_age": 0,
+		"introspection_clients": []interface{}{
+			map[string]interface{}{"client_id": "gateway", "client_secret": "gateway-secret"},
+		},
+	})
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "admin@example.com", "admin")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	adminToken, _ := login(t, app, "admin@example.com", testPassword)
+
+	if status, result := request(t, app, "DELETE", "/users/"+userID.String(), adminToken, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d %v", status, result)
+	}
+
+	// The embedded roles spare the middleware a lookup of the deleted user,
+	// so only the revocation rejects the token.
+	if status, result := request(t, app, "POST", "/auth/logout-all", token, nil); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected the token of a deleted user to be rejected, got %d %v", status, result)
+	}
+
+	status, result := request(t, app, "POST", "/auth/introspect", "", map[string]string{
+		"token":         token,
+		"client_id":     "gateway",
+		"client_secret": "gateway-secret",
+	})
+	if status != fiber.StatusOK || result["active"] != false {
+		t.Fatalf("expected the token of a deleted user to be inactive, got %d %v", status, result)
+	}
+}
+
+func countPasswordHistory(t *testing.T, db database.Database, userID uuid.UUID) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM password_history WHERE user_id = ?", userID.String()).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUpdateUserPasswordHistory(t *testing.T) {
+	app, _, db := newTestApp(t, map[string]interface{}{"password_history": 3})
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "admin@example.com", "admin")
+	adminToken, _ := login(t, app, "admin@example.com", testPassword)
+	path := "/users/" + userID.String()
+
+	// A failed update keeps no history.
+	status, result := request(t, app, "PUT", path, adminToken, map[string]string{"password": "temporary-password", "email": "not-an-email"})
+	if status == fiber.StatusOK {
+		t.Fatalf("expected the update to fail, got %d %v", status, result)
+	}
+	if count := countPasswordHistory(t, db, userID); count != 0 {
+		t.Fatalf("expected no history after a failed update, got %d", count)
+	}
+	login(t, app, "jane@example.com", testPassword)
+
+	if status, result := request(t, app, "PUT", path, adminToken, map[string]string{"password": "temporary-password"}); status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d %v", status, result)
+	}
+	if count := countPasswordHistory(t, db, userID); count != 1 {
+		t.Fatalf("expected the replaced password to be kept, got %d entries", count)
+	}
+
+	for _, reused := range []string{"temporary-password", testPassword} {
+		if status, result := request(t, app, "PUT", path, adminToken, map[string]string{"password": reused}); status != fiber.StatusBadRequest {
+			t.Fatalf("expected %q to be rejected, got %d %v", reused, status, result)
+		}
+	}
+	login(t, app, "jane@example.com", "temporary-password")
+}
+
+func TestUpdateUserPasswordPolicy(t *testing.T) {
+	app, _, db := newTestApp(t, map[string]interface{}{
+		"password_policy": map[string]interface{}{"disallow_user_info": true},
+	})
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "admin@example.com", "admin")
+	adminToken, _ := login(t, app, "admin@example.com", testPassword)
+	path := "/users/" + userID.String()
+
+	tests := []struct {
+		name     string
+		body     map[string]string
+		expected int
+	}{
+		{"stored email", map[string]string{"password": "jane-temporary"}, fiber.StatusBadRequest},
+		{"stored last name", map[string]string{"password": "temporary-doe"}, fiber.StatusBadRequest},
+		{"updated first name", map[string]string{"password": "temporary-maxwell", "firstname": "Maxwell"}, fiber.StatusBadRequest},
+		{"unrelated", map[string]string{"password": "temporary-password"}, fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		status, result := request(t, app, "PUT", path, adminToken, tt.body)
+		if status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+
+	if status, _ := request(t, app, "PUT", "/users/"+uuid.New().String(), adminToken, map[string]string{"password": "temporary-password"}); status != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown user, got %d", status)
+	}
+}
+
+func TestUpdateUserMustChangePassword(t *testing.T) {
+	app, _, db := newTestApp(t, nil)
+	userID := createTestUser(t, db, "jane@example.com", "user")
+	createTestUser(t, db, "admin@example.com", "admin")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	adminToken, _ := login(t, app, "admin@example.com", testPassword)
+	path := "/users/" + userID.String()
+
+	mustChangePassword := func() bool {
+		t.Helper()
+
+		var required bool
+		if err := db.QueryRow(context.Background(), "SELECT must_change_password FROM users WHERE id = ?", userID.String()).Scan(&required); err != nil {
+			t.Fatal(err)
+		}
+		return required
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		body     map[string]interface{}
+		expected int
+		required bool
+	}{
+		{"required by an admin", adminToken, map[string]interface{}{"must_change_password": true}, fiber.StatusOK, true},
+		{"kept by other updates", adminToken, map[string]interface{}{"firstname": "Janet"}, fiber.StatusOK, true},
+		{"lifted by the user", token, map[string]interface{}{"must_change_password": false}, fiber.StatusForbidden, true},
+		{"lifted by an admin", adminToken, map[string]interface{}{"must_change_password": false}, fiber.StatusOK, false},
+		{"temporary password", adminToken, map[string]interface{}{"password": "temporary-password", "must_change_password": true}, fiber.StatusOK, true},
+		{"new password", adminToken, map[string]interface{}{"password": "another-password"}, fiber.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		status, result := request(t, app, "PUT", path, tt.token, tt.body)
+		if status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+		if required := mustChangePassword(); required != tt.required {
+			t.Fatalf("%s: expected must_change_password to be %t", tt.name, tt.required)
+		}
+	}
+}
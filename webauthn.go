@@ -0,0 +1,648 @@
+package auth
+
+import (
+	stdcontext "context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	authcontext "github.com/nicolasbonnici/gorest-auth/context"
+	"github.com/nicolasbonnici/gorest-auth/models"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+	"github.com/nicolasbonnici/gorest-auth/webauthn"
+	"github.com/nicolasbonnici/gorest/crud"
+	"github.com/nicolasbonnici/gorest/database"
+	"github.com/nicolasbonnici/gorest/query"
+	"github.com/nicolasbonnici/gorest/response"
+)
+
+const (
+	MFAMethodWebAuthn = "webauthn"
+
+	webAuthnRegister = "register"
+	webAuthnLogin    = "login"
+	webAuthnMFA      = "mfa"
+)
+
+var (
+	ErrWebAuthnChallengeInvalid   = errors.New("invalid or expired challenge")
+	ErrWebAuthnCredentialExists   = errors.New("credential already registered")
+	ErrWebAuthnCredentialNotFound = errors.New("unknown credential")
+)
+
+// webAuthnVerificationErrors are the ways a ceremony response can fail.
+var webAuthnVerificationErrors = []error{
+	webauthn.ErrMalformed,
+	webauthn.ErrChallengeMismatch,
+	webauthn.ErrOriginMismatch,
+	webauthn.ErrRPIDMismatch,
+	webauthn.ErrUserNotPresent,
+	webauthn.ErrUserNotVerified,
+	webauthn.ErrSignatureInvalid,
+	webauthn.ErrSignCount,
+	webauthn.ErrUnsupportedKey,
+	webauthn.ErrCredentialMismatch,
+}
+
+type WebAuthnRegisterBeginRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// DeleteWebAuthnCredentialRequest carries the current password, which users
+// without a password leave empty.
+type DeleteWebAuthnCredentialRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+type WebAuthnRegisterFinishRequest struct {
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// webAuthnStore keeps the WebAuthn credentials of users and the challenges
+// of ongoing ceremonies. A challenge is found back from the client data of
+// the response answering it; only its SHA-256 digest is stored.
+type webAuthnStore struct {
+	db  database.Database
+	rp  *webauthn.RelyingParty
+	ttl time.Duration
+}
+
+func newWebAuthnStore(db database.Database, rp *webauthn.RelyingParty, ttl int) *webAuthnStore {
+	return &webAuthnStore{
+		db:  db,
+		rp:  rp,
+		ttl: time.Duration(ttl) * time.Second,
+	}
+}
+
+// createChallenge starts a ceremony. userID is nil for passwordless logins,
+// where the user is only known from the credential.
+func (s *webAuthnStore) createChallenge(ctx stdcontext.Context, userID *uuid.UUID, purpose string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// Abandoned ceremonies are cleaned up as new ones start.
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("webauthn_challenges").
+		Where(query.Lt("expires_at", now)).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return nil, fmt.Errorf("failed to delete expired challenges: %w", err)
+	}
+
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Insert("webauthn_challenges").
+		Columns("id", "challenge_hash", "user_id", "purpose", "expires_at", "created_at").
+		Values(uuid.New(), hashOpaqueToken(string(challenge)), userID, purpose, now.Add(s.ttl), now).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return nil, fmt.Errorf("failed to store challenge: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// consumeChallenge ends the ceremony the challenge belongs to and returns
+// the user it was started for.
+func (s *webAuthnStore) consumeChallenge(ctx stdcontext.Context, challenge []byte, purpose string) (*uuid.UUID, error) {
+	challengeHash := hashOpaqueToken(string(challenge))
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select("user_id", "purpose", "expires_at").
+		From("webauthn_challenges").
+		Where(query.Eq("challenge_hash", challengeHash)).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var userID *uuid.UUID
+	var storedPurpose string
+	var expiresAt time.Time
+	err = s.db.QueryRow(ctx, queryStr, args...).Scan(&userID, &storedPurpose, &expiresAt)
+	if crud.IsNotFoundError(err) {
+		return nil, ErrWebAuthnChallengeInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	queryStr, args, err = query.New(s.db.Dialect()).
+		Delete("webauthn_challenges").
+		Where(query.Eq("challenge_hash", challengeHash)).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := s.db.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume challenge: %w", err)
+	}
+
+	// Another request answered the challenge between our read and write.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil, ErrWebAuthnChallengeInvalid
+	}
+
+	if storedPurpose != purpose || time.Now().After(expiresAt) {
+		return nil, ErrWebAuthnChallengeInvalid
+	}
+
+	return userID, nil
+}
+
+func (s *webAuthnStore) credentials(ctx stdcontext.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select(webAuthnCredentialColumns...).
+		From("webauthn_credentials").
+		Where(query.Eq("user_id", userID)).
+		OrderBy("created_at", query.ASC).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, queryStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredential{}
+	for rows.Next() {
+		var credential models.WebAuthnCredential
+		if err := rows.Scan(credentialFields(&credential)...); err != nil {
+			return nil, fmt.Errorf("failed to read credentials: %w", err)
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (s *webAuthnStore) findCredential(ctx stdcontext.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Select(webAuthnCredentialColumns...).
+		From("webauthn_credentials").
+		Where(query.Eq("credential_id_hash", hashOpaqueToken(string(credentialID)))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var credential models.WebAuthnCredential
+	err = s.db.QueryRow(ctx, queryStr, args...).Scan(credentialFields(&credential)...)
+	if crud.IsNotFoundError(err) {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return &credential, nil
+}
+
+func (s *webAuthnStore) addCredential(ctx stdcontext.Context, userID uuid.UUID, name string, credential *webauthn.Credential) (*models.WebAuthnCredential, error) {
+	if _, err := s.findCredential(ctx, credential.ID); err == nil {
+		return nil, ErrWebAuthnCredentialExists
+	} else if !errors.Is(err, ErrWebAuthnCredentialNotFound) {
+		return nil, err
+	}
+
+	stored := models.WebAuthnCredential{
+		ID:               uuid.New(),
+		UserID:           userID,
+		CredentialID:     base64.RawURLEncoding.EncodeToString(credential.ID),
+		CredentialIDHash: hashOpaqueToken(string(credential.ID)),
+		PublicKey:        base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		SignCount:        int64(credential.SignCount),
+		Transports:       strings.Join(credential.Transports, ","),
+		Name:             name,
+		CreatedAt:        time.Now(),
+	}
+
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Insert("webauthn_credentials").
+		Columns("id", "user_id", "credential_id", "credential_id_hash", "public_key", "sign_count", "transports", "name", "created_at").
+		Values(stored.ID, stored.UserID, stored.CredentialID, stored.CredentialIDHash, stored.PublicKey, stored.SignCount, stored.Transports, stored.Name, stored.CreatedAt).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return nil, fmt.Errorf("failed to store credential: %w", err)
+	}
+
+	return &stored, nil
+}
+
+// recordUse stores the signature counter of the last authentication.
+func (s *webAuthnStore) recordUse(ctx stdcontext.Context, id uuid.UUID, signCount uint32) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Update("webauthn_credentials").
+		Set("sign_count", int64(signCount)).
+		Set("last_used_at", time.Now()).
+		Where(query.Eq("id", id)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := s.db.Exec(ctx, queryStr, args...); err != nil {
+		return fmt.Errorf("failed to update credential: %w", err)
+	}
+
+	return nil
+}
+
+func (s *webAuthnStore) deleteCredential(ctx stdcontext.Context, userID, id uuid.UUID) error {
+	queryStr, args, err := query.New(s.db.Dialect()).
+		Delete("webauthn_credentials").
+		Where(query.Eq("id", id)).
+		Where(query.Eq("user_id", userID)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := s.db.Exec(ctx, queryStr, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete credential: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+
+	return nil
+}
+
+var webAuthnCredentialColumns = []string{"id", "user_id", "credential_id", "credential_id_hash", "public_key", "sign_count", "transports", "name", "created_at", "last_used_at"}
+
+func credentialFields(credential *models.WebAuthnCredential) []any {
+	return []any{&credential.ID, &credential.UserID, &credential.CredentialID, &credential.CredentialIDHash, &credential.PublicKey,
+		&credential.SignCount, &credential.Transports, &credential.Name, &credential.CreatedAt, &credential.LastUsedAt}
+}
+
+// toWebAuthn decodes a stored credential for verification.
+func toWebAuthn(stored *models.WebAuthnCredential) (*webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(stored.CredentialID)
+	if err != nil {
+		return nil, fmt.Errorf("malformed stored credential ID: %w", err)
+	}
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(stored.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("malformed stored public key: %w", err)
+	}
+
+	return &webauthn.Credential{
+		ID:        id,
+		PublicKey: publicKey,
+		SignCount: uint32(stored.SignCount),
+	}, nil
+}
+
+func credentialDescriptors(credentials []models.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+		if err != nil {
+			continue
+		}
+
+		descriptor := webauthn.CredentialDescriptor{Type: "public-key", ID: id}
+		if credential.Transports != "" {
+			descriptor.Transports = strings.Split(credential.Transports, ",")
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors
+}
+
+// sendWebAuthnError answers a failed ceremony. failedStatus is the status of
+// a response that does not verify.
+func sendWebAuthnError(c *fiber.Ctx, err error, failedStatus int) error {
+	switch {
+	case errors.Is(err, ErrWebAuthnCredentialExists):
+		return response.SendError(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, ErrWebAuthnChallengeInvalid), errors.Is(err, ErrWebAuthnCredentialNotFound):
+		return response.SendError(c, failedStatus, err.Error())
+	}
+
+	for _, verificationErr := range webAuthnVerificationErrors {
+		if errors.Is(err, verificationErr) {
+			return response.SendError(c, failedStatus, err.Error())
+		}
+	}
+
+	return response.SendError(c, fiber.StatusInternalServerError, "failed to verify credential")
+}
+
+// handleWebAuthnRegisterBegin returns the options of
+// navigator.credentials.create(). Users with a password must confirm it so
+// that a stolen access token cannot add a credential; users without one need
+// a recent login.
+func handleWebAuthnRegisterBegin(db database.Database, passkeys *webAuthnStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req WebAuthnRegisterBeginRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+			}
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "database error")
+		}
+
+		if fiberErr := confirmIdentity(c, config.PasswordHasher, user, req.CurrentPassword); fiberErr != nil {
+			return response.SendError(c, fiberErr.Code, fiberErr.Message)
+		}
+
+		existing, err := passkeys.credentials(ctx, user.ID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read credentials")
+		}
+
+		challenge, err := passkeys.createChallenge(ctx, &user.ID, webAuthnRegister)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to start registration")
+		}
+
+		options := passkeys.rp.CreationOptions(webauthn.User{
+			ID:          user.ID[:],
+			Name:        user.Email,
+			DisplayName: strings.TrimSpace(user.Firstname + " " + user.Lastname),
+		}, challenge, credentialDescriptors(existing), webauthn.UserVerificationPreferred)
+
+		return response.SendFormatted(c, fiber.StatusOK, fiber.Map{"publicKey": options})
+	}
+}
+
+func handleWebAuthnRegisterFinish(passkeys *webAuthnStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req WebAuthnRegisterFinishRequest
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		challenge, err := req.Credential.Challenge()
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusBadRequest)
+		}
+
+		challengeUserID, err := passkeys.consumeChallenge(ctx, challenge, webAuthnRegister)
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusBadRequest)
+		}
+		if challengeUserID == nil || *challengeUserID != userID {
+			return sendWebAuthnError(c, ErrWebAuthnChallengeInvalid, fiber.StatusBadRequest)
+		}
+
+		credential, err := passkeys.rp.VerifyRegistration(&req.Credential, challenge, false)
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusBadRequest)
+		}
+
+		stored, err := passkeys.addCredential(ctx, userID, req.Name, credential)
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusBadRequest)
+		}
+
+		return response.SendCreated(c, stored)
+	}
+}
+
+// handleWebAuthnLoginBegin returns the options of navigator.credentials.get().
+// With an mfa_token the credentials of the user are requested as a second
+// factor; without, any discoverable credential can log in.
+func handleWebAuthnLoginBegin(passkeys *webAuthnStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		claims, _ := authcontext.GetClaims(c)
+		if claims == nil {
+			challenge, err := passkeys.createChallenge(ctx, nil, webAuthnLogin)
+			if err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to start login")
+			}
+
+			options := passkeys.rp.RequestOptions(challenge, nil, webauthn.UserVerificationRequired)
+			return response.SendFormatted(c, fiber.StatusOK, fiber.Map{"publicKey": options})
+		}
+
+		if claims.Restriction != tokens.RestrictionMFA {
+			return response.SendError(c, fiber.StatusForbidden, "only an mfa_token is accepted")
+		}
+
+		userID, err := uuid.Parse(claims.UserID())
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		credentials, err := passkeys.credentials(ctx, userID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read credentials")
+		}
+		if len(credentials) == 0 {
+			return response.SendError(c, fiber.StatusBadRequest, ErrMFANotEnrolled.Error())
+		}
+
+		challenge, err := passkeys.createChallenge(ctx, &userID, webAuthnMFA)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to start login")
+		}
+
+		options := passkeys.rp.RequestOptions(challenge, credentialDescriptors(credentials), webauthn.UserVerificationPreferred)
+		return response.SendFormatted(c, fiber.StatusOK, fiber.Map{"publicKey": options})
+	}
+}
+
+// handleWebAuthnLoginFinish verifies the assertion and logs the user in. A
+// passwordless login requires user verification, which makes the passkey
+// a multi-factor credential on its own.
+func handleWebAuthnLoginFinish(db database.Database, tokenService TokenService, refreshTokens *RefreshTokenStore, passkeys *webAuthnStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req webauthn.AuthenticationResponse
+		if err := c.BodyParser(&req); err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+		}
+
+		ctx := c.Context()
+
+		claims, _ := authcontext.GetClaims(c)
+		if claims != nil && claims.Restriction != tokens.RestrictionMFA {
+			return response.SendError(c, fiber.StatusForbidden, "only an mfa_token is accepted")
+		}
+		secondFactor := claims != nil
+
+		purpose := webAuthnLogin
+		if secondFactor {
+			purpose = webAuthnMFA
+		}
+
+		challenge, err := req.Challenge()
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusUnauthorized)
+		}
+
+		challengeUserID, err := passkeys.consumeChallenge(ctx, challenge, purpose)
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusUnauthorized)
+		}
+
+		stored, err := passkeys.findCredential(ctx, req.RawID)
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusUnauthorized)
+		}
+
+		if secondFactor {
+			if challengeUserID == nil || *challengeUserID != stored.UserID || claims.UserID() != stored.UserID.String() {
+				return sendWebAuthnError(c, webauthn.ErrCredentialMismatch, fiber.StatusUnauthorized)
+			}
+		} else if len(req.Response.UserHandle) > 0 && string(req.Response.UserHandle) != string(stored.UserID[:]) {
+			return sendWebAuthnError(c, webauthn.ErrCredentialMismatch, fiber.StatusUnauthorized)
+		}
+
+		credential, err := toWebAuthn(stored)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to verify credential")
+		}
+
+		assertion, err := passkeys.rp.VerifyAuthentication(&req, challenge, credential, !secondFactor)
+		if err != nil {
+			return sendWebAuthnError(c, err, fiber.StatusUnauthorized)
+		}
+
+		if err := passkeys.recordUse(ctx, stored.ID, assertion.SignCount); err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to verify credential")
+		}
+
+		if secondFactor {
+			if err := tokenService.Revoke(ctx, claims); err != nil {
+				return response.SendError(c, fiber.StatusInternalServerError, "failed to revoke token")
+			}
+		}
+
+		user, err := getUserByID(ctx, db, stored.UserID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to generate token")
+		}
+
+		if loginBlocked(user, config) {
+			return response.SendError(c, fiber.StatusForbidden, "email address not verified")
+		}
+
+		auth := tokens.NewAuthentication(tokens.AMRHardwareKey, tokens.AMRMultiFactor)
+		if secondFactor {
+			auth = tokens.NewAuthentication(append(claims.AMR, tokens.AMRHardwareKey)...)
+		}
+
+		return sendAuthResponse(c, tokenService, refreshTokens, user, auth, config)
+	}
+}
+
+func handleListWebAuthnCredentials(passkeys *webAuthnStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		credentials, err := passkeys.credentials(c.Context(), userID)
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to read credentials")
+		}
+
+		return response.SendFormatted(c, fiber.StatusOK, credentials)
+	}
+}
+
+// handleDeleteWebAuthnCredential asks for the same confirmation as adding a
+// credential, so that a stolen access token cannot remove the passkeys of
+// the user.
+func handleDeleteWebAuthnCredential(db database.Database, passkeys *webAuthnStore, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req DeleteWebAuthnCredentialRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return response.SendError(c, fiber.StatusBadRequest, "invalid request body")
+			}
+		}
+
+		ctx := c.Context()
+
+		userID, err := uuid.Parse(authcontext.MustGetUserID(c))
+		if err != nil {
+			return response.SendError(c, fiber.StatusUnauthorized, "invalid user ID")
+		}
+
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return response.SendError(c, fiber.StatusBadRequest, "invalid credential ID")
+		}
+
+		user, err := getUserByID(ctx, db, userID)
+		if crud.IsNotFoundError(err) {
+			return response.SendError(c, fiber.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "database error")
+		}
+
+		if fiberErr := confirmIdentity(c, config.PasswordHasher, user, req.CurrentPassword); fiberErr != nil {
+			return response.SendError(c, fiberErr.Code, fiberErr.Message)
+		}
+
+		err = passkeys.deleteCredential(ctx, user.ID, id)
+		if errors.Is(err, ErrWebAuthnCredentialNotFound) {
+			return response.SendError(c, fiber.StatusNotFound, "credential not found")
+		}
+		if err != nil {
+			return response.SendError(c, fiber.StatusInternalServerError, "failed to delete credential")
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
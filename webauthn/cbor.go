@@ -0,0 +1,187 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The decoder supports the subset of CBOR (RFC 8949) authenticators emit:
+// definite length items, integers, byte and text strings, arrays, maps, tags
+// and simple values.
+
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first item of data and returns it with the number
+// of bytes it spans. Integers decode to int64, maps to map[any]any.
+func decodeCBOR(data []byte) (any, int, error) {
+	d := cborDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.offset, nil
+}
+
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (d *cborDecoder) decode(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("cbor: nesting too deep")
+	}
+
+	major, info, argument, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return int64(argument), nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -1 - int64(argument), nil
+	case 2, 3:
+		raw, err := d.take(argument)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(raw), nil
+		}
+		return append([]byte(nil), raw...), nil
+	case 4:
+		if argument > uint64(len(d.data)-d.offset) {
+			return nil, errCBORTruncated
+		}
+		items := make([]any, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if argument > uint64(len(d.data)-d.offset)/2 {
+			return nil, errCBORTruncated
+		}
+		entries := make(map[any]any, argument)
+		for i := uint64(0); i < argument; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, duplicate := entries[key]; duplicate {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", key)
+			}
+			entries[key] = value
+		}
+		return entries, nil
+	case 6:
+		// Tags only annotate the item that follows.
+		return d.decode(depth + 1)
+	default:
+		return simpleValue(info, argument)
+	}
+}
+
+// head reads the initial byte of an item, split into its major type and
+// additional information, and the argument that follows.
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	if d.offset >= len(d.data) {
+		return 0, 0, 0, errCBORTruncated
+	}
+
+	initial := d.data[d.offset]
+	d.offset++
+	major, info := initial>>5, initial&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: indefinite lengths are not supported")
+	}
+
+	raw, err := d.take(uint64(size))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var argument uint64
+	for _, b := range raw {
+		argument = argument<<8 | uint64(b)
+	}
+
+	return major, info, argument, nil
+}
+
+func simpleValue(info byte, argument uint64) (any, error) {
+	switch info {
+	case 25:
+		return nil, fmt.Errorf("cbor: half precision floats are not supported")
+	case 26:
+		return float64(math.Float32frombits(uint32(argument))), nil
+	case 27:
+		return math.Float64frombits(argument), nil
+	default:
+		switch argument {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", argument)
+	}
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.offset) {
+		return nil, errCBORTruncated
+	}
+	raw := d.data[d.offset : d.offset+int(n)]
+	d.offset += int(n)
+	return raw, nil
+}
+
+// uint16At and uint32At read big endian integers of authenticator data.
+func uint16At(data []byte, offset int) uint16 {
+	return binary.BigEndian.Uint16(data[offset:])
+}
+
+func uint32At(data []byte, offset int) uint32 {
+	return binary.BigEndian.Uint32(data[offset:])
+}
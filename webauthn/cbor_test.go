@@ -0,0 +1,88 @@
+package webauthn
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	value, size, err := decodeCBOR([]byte{0xa2, 0x01, 0x02, 0x63, 'f', 'm', 't', 0x42, 0xca, 0xfe, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 10 {
+		t.Fatalf("expected the item to span 10 bytes, got %d", size)
+	}
+
+	entries := value.(map[any]any)
+	if entries[int64(1)] != int64(2) || !bytes.Equal(entries["fmt"].([]byte), []byte{0xca, 0xfe}) {
+		t.Fatalf("unexpected value: %v", entries)
+	}
+}
+
+func TestDecodeCBORRejectsMaliciousInput(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated head", []byte{0x19, 0x01}},
+		{"truncated byte string", []byte{0x45, 0x01, 0x02}},
+		{"huge byte string", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array", []byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge map", []byte{0xbb, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x02}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x01, 0xff}},
+		{"deep nesting", bytes.Repeat([]byte{0x81}, maxCBORDepth+2)},
+		{"deep tags", bytes.Repeat([]byte{0xc6}, maxCBORDepth+2)},
+		{"duplicate keys", []byte{0xa2, 0x01, 0x02, 0x01, 0x03}},
+		{"array key", []byte{0xa1, 0x80, 0x01}},
+		{"integer overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"half float", []byte{0xf9, 0x3c, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); err == nil {
+				t.Fatalf("expected %x to be rejected", tt.data)
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorDataRejectsInvalidLengths(t *testing.T) {
+	header := append(make([]byte, 32), FlagUserPresent|FlagAttestedCredentialData, 0, 0, 0, 1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", header[:36]},
+		{"missing attested data", header},
+		{"credential ID past the end", append(append(append([]byte(nil), header...), make([]byte, 16)...), 0xff, 0xff, 0x01)},
+		{"missing public key", append(append(append([]byte(nil), header...), make([]byte, 16)...), 0x00, 0x01, 0x01)},
+		{"trailing data", append(append(make([]byte, 32), FlagUserPresent, 0, 0, 0, 1), 0x00)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseAuthenticatorData(tt.data); !errors.Is(err, ErrMalformed) {
+				t.Fatalf("expected ErrMalformed, got %v", err)
+			}
+		})
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	f.Add([]byte{0xa2, 0x01, 0x02, 0x63, 'f', 'm', 't', 0x42, 0xca, 0xfe})
+	f.Add([]byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, size, err := decodeCBOR(data)
+		if err == nil && (size <= 0 || size > len(data)) {
+			t.Fatalf("invalid size %d for %d bytes", size, len(data))
+		}
+		_, _ = ParseAuthenticatorData(data)
+		_, _ = ParsePublicKey(data)
+	})
+}
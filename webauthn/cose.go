@@ -0,0 +1,122 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) accepted for credentials, in order of
+// preference.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE_Key labels and values.
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseCurve        = -1
+	coseX            = -2
+	coseY            = -3
+	coseRSAModulus   = -1
+	coseRSAExponent  = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// PublicKey is a credential public key decoded from its COSE encoding.
+type PublicKey struct {
+	Algorithm int64
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key.
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	value, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return publicKeyFromCOSE(value)
+}
+
+func publicKeyFromCOSE(value any) (*PublicKey, error) {
+	entries, ok := value.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a COSE key", ErrUnsupportedKey)
+	}
+
+	keyType, _ := entries[int64(coseKeyType)].(int64)
+	algorithm, _ := entries[int64(coseKeyAlgorithm)].(int64)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgES256:
+		curve, _ := entries[int64(coseCurve)].(int64)
+		x, _ := entries[int64(coseX)].([]byte)
+		y, _ := entries[int64(coseY)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid P-256 key", ErrUnsupportedKey)
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("%w: point is not on P-256", ErrUnsupportedKey)
+		}
+		return &PublicKey{Algorithm: algorithm, Key: key}, nil
+
+	case keyType == coseKeyTypeOKP && algorithm == AlgEdDSA:
+		curve, _ := entries[int64(coseCurve)].(int64)
+		x, _ := entries[int64(coseX)].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 key", ErrUnsupportedKey)
+		}
+		return &PublicKey{Algorithm: algorithm, Key: ed25519.PublicKey(x)}, nil
+
+	case keyType == coseKeyTypeRSA && algorithm == AlgRS256:
+		n, _ := entries[int64(coseRSAModulus)].([]byte)
+		e, _ := entries[int64(coseRSAExponent)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA key", ErrUnsupportedKey)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		return &PublicKey{Algorithm: algorithm, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: key type %d with algorithm %d", ErrUnsupportedKey, keyType, algorithm)
+	}
+}
+
+// Verify checks the signature of data.
+func (k *PublicKey) Verify(data, signature []byte) error {
+	var valid bool
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	if !valid {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,538 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies (W3C Web Authentication Level 2)
+// used by passkeys and security keys.
+//
+// Attestation is not requested, so attestation statements are not checked
+// against trust anchors; only packed self attestation is verified.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+
+	// ChallengeSize is the size, in bytes, of generated challenges.
+	ChallengeSize = 32
+
+	// MaxCredentialIDSize is the largest credential ID authenticators may
+	// return.
+	MaxCredentialIDSize = 1023
+)
+
+// Authenticator data flags.
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagBackupEligible         = 0x08
+	FlagBackedUp               = 0x10
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionData          = 0x80
+)
+
+var (
+	ErrMalformed          = errors.New("webauthn: malformed response")
+	ErrChallengeMismatch  = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch     = errors.New("webauthn: origin not allowed")
+	ErrRPIDMismatch       = errors.New("webauthn: relying party ID mismatch")
+	ErrUserNotPresent     = errors.New("webauthn: user not present")
+	ErrUserNotVerified    = errors.New("webauthn: user not verified")
+	ErrSignatureInvalid   = errors.New("webauthn: invalid signature")
+	ErrSignCount          = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+	ErrUnsupportedKey     = errors.New("webauthn: unsupported public key")
+	ErrCredentialMismatch = errors.New("webauthn: response is not for this credential")
+)
+
+// Base64URL is binary data encoded as unpadded base64url in JSON, as in the
+// JSON serialization of WebAuthn. Padded and standard base64 are accepted.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	encoded = strings.TrimRight(encoded, "=")
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid base64url data: %w", err)
+	}
+
+	*b = decoded
+	return nil
+}
+
+// Config describes the relying party.
+type Config struct {
+	// RPID is the domain credentials are scoped to, e.g. "example.com".
+	RPID string
+	// RPName is shown by authenticators.
+	RPName string
+	// Origins are the exact origins ceremonies may run from, e.g.
+	// "https://app.example.com".
+	Origins []string
+	// Timeout is the hint given to clients for the duration of a ceremony.
+	Timeout time.Duration
+}
+
+type RelyingParty struct {
+	config   Config
+	rpIDHash [32]byte
+}
+
+func New(config Config) (*RelyingParty, error) {
+	if config.RPID == "" {
+		return nil, fmt.Errorf("webauthn: relying party ID is required")
+	}
+	if len(config.Origins) == 0 {
+		return nil, fmt.Errorf("webauthn: at least one origin is required")
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+
+	return &RelyingParty{
+		config:   config,
+		rpIDHash: sha256.Sum256([]byte(config.RPID)),
+	}, nil
+}
+
+// User is the account a credential is registered for. ID is the user handle
+// returned by discoverable credentials.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey,omitempty"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions passed to
+// navigator.credentials.create().
+type CreationOptions struct {
+	Challenge              Base64URL              `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions passed to
+// navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// RegistrationResponse is the PublicKeyCredential returned by
+// navigator.credentials.create(), serialized to JSON.
+type RegistrationResponse struct {
+	ID       string                           `json:"id"`
+	RawID    Base64URL                        `json:"rawId"`
+	Type     string                           `json:"type"`
+	Response AuthenticatorAttestationResponse `json:"response"`
+}
+
+type AuthenticatorAttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports,omitempty"`
+}
+
+// AuthenticationResponse is the PublicKeyCredential returned by
+// navigator.credentials.get(), serialized to JSON.
+type AuthenticationResponse struct {
+	ID       string                         `json:"id"`
+	RawID    Base64URL                      `json:"rawId"`
+	Type     string                         `json:"type"`
+	Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+type AuthenticatorAssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle,omitempty"`
+}
+
+// ClientData is the data the client signed along with the authenticator.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// Challenge returns the challenge the response answers, e.g. to look up
+// the ceremony it belongs to.
+func (r *RegistrationResponse) Challenge() ([]byte, error) {
+	return clientDataChallenge(r.Response.ClientDataJSON)
+}
+
+// Challenge returns the challenge the response answers, e.g. to look up
+// the ceremony it belongs to.
+func (r *AuthenticationResponse) Challenge() ([]byte, error) {
+	return clientDataChallenge(r.Response.ClientDataJSON)
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	UserVerified   bool
+	BackupEligible bool
+	BackedUp       bool
+}
+
+// Assertion is the outcome of a successful authentication.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackedUp     bool
+}
+
+// AuthenticatorData is the binary structure signed by authenticators.
+type AuthenticatorData struct {
+	RPIDHash            []byte
+	Flags               byte
+	SignCount           uint32
+	AAGUID              []byte
+	CredentialID        []byte
+	CredentialPublicKey []byte
+}
+
+// NewChallenge returns a random challenge.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// CreationOptions builds the options registering a credential for the user.
+// Discoverable credentials (passkeys) are preferred.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude []CredentialDescriptor, userVerification string) *CreationOptions {
+	params := make([]CredentialParameter, len(SupportedAlgorithms))
+	for i, alg := range SupportedAlgorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+
+	return &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User: UserEntity{
+			ID:          user.ID,
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: userVerification,
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds the options of an authentication. Without allowed
+// credentials the client offers the discoverable credentials of the RP.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor, userVerification string) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// VerifyRegistration checks a registration response against the challenge
+// it was created with and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(response *RegistrationResponse, challenge []byte, requireUserVerification bool) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", ErrMalformed, response.Type)
+	}
+
+	clientDataJSON := response.Response.ClientDataJSON
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	attestation, _, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	fields, ok := attestation.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrMalformed)
+	}
+
+	format, _ := fields["fmt"].(string)
+	statement, _ := fields["attStmt"].(map[any]any)
+	rawAuthData, _ := fields["authData"].([]byte)
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+
+	if authData.Flags&FlagAttestedCredentialData == 0 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrMalformed)
+	}
+
+	if !bytes.Equal(authData.CredentialID, response.RawID) {
+		return nil, ErrCredentialMismatch
+	}
+
+	publicKey, err := ParsePublicKey(authData.CredentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// A packed statement without certificates is signed by the credential
+	// itself. Other statements would need trust anchors and are not checked.
+	if _, hasCertificates := statement["x5c"]; format == "packed" && !hasCertificates {
+		alg, _ := statement["alg"].(int64)
+		signature, _ := statement["sig"].([]byte)
+		if alg != publicKey.Algorithm {
+			return nil, fmt.Errorf("%w: self attestation algorithm mismatch", ErrMalformed)
+		}
+
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+		if err := publicKey.Verify(signed, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Credential{
+		ID:             authData.CredentialID,
+		PublicKey:      authData.CredentialPublicKey,
+		SignCount:      authData.SignCount,
+		AAGUID:         authData.AAGUID,
+		Transports:     response.Response.Transports,
+		UserVerified:   authData.Flags&FlagUserVerified != 0,
+		BackupEligible: authData.Flags&FlagBackupEligible != 0,
+		BackedUp:       authData.Flags&FlagBackedUp != 0,
+	}, nil
+}
+
+// VerifyAuthentication checks an authentication response against the
+// challenge and the stored credential. Callers store the returned counter.
+func (rp *RelyingParty) VerifyAuthentication(response *AuthenticationResponse, challenge []byte, credential *Credential, requireUserVerification bool) (*Assertion, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", ErrMalformed, response.Type)
+	}
+
+	if !bytes.Equal(response.RawID, credential.ID) {
+		return nil, ErrCredentialMismatch
+	}
+
+	clientDataJSON := response.Response.ClientDataJSON
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAuthData := response.Response.AuthenticatorData
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ParsePublicKey(credential.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := publicKey.Verify(signed, response.Response.Signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators without a counter, such as synced passkeys, always
+	// report zero.
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return nil, ErrSignCount
+	}
+
+	return &Assertion{
+		SignCount:    authData.SignCount,
+		UserVerified: authData.Flags&FlagUserVerified != 0,
+		BackedUp:     authData.Flags&FlagBackedUp != 0,
+	}, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var clientData ClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return fmt.Errorf("%w: invalid client data: %v", ErrMalformed, err)
+	}
+
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrMalformed, clientData.Type)
+	}
+
+	received, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if !slices.Contains(rp.config.Origins, clientData.Origin) {
+		return ErrOriginMismatch
+	}
+
+	return nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *AuthenticatorData, requireUserVerification bool) error {
+	if subtle.ConstantTimeCompare(authData.RPIDHash, rp.rpIDHash[:]) != 1 {
+		return ErrRPIDMismatch
+	}
+
+	if authData.Flags&FlagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+
+	if requireUserVerification && authData.Flags&FlagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+
+	return nil
+}
+
+// ParseAuthenticatorData decodes authenticator data, including the attested
+// credential data of registrations.
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	const headerSize = 32 + 1 + 4
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrMalformed)
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: uint32At(data, 33),
+	}
+
+	rest := data[headerSize:]
+	if authData.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrMalformed)
+		}
+
+		authData.AAGUID = rest[:16]
+		idLength := int(uint16At(rest, 16))
+		rest = rest[18:]
+		if idLength > MaxCredentialIDSize || len(rest) < idLength {
+			return nil, fmt.Errorf("%w: invalid credential ID length", ErrMalformed)
+		}
+
+		authData.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, keySize, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid credential public key: %v", ErrMalformed, err)
+		}
+
+		authData.CredentialPublicKey = rest[:keySize]
+		rest = rest[keySize:]
+	}
+
+	if authData.Flags&FlagExtensionData != 0 {
+		_, size, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid extensions: %v", ErrMalformed, err)
+		}
+		rest = rest[size:]
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", ErrMalformed)
+	}
+
+	return authData, nil
+}
+
+func clientDataChallenge(raw []byte) ([]byte, error) {
+	var clientData ClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, fmt.Errorf("%w: invalid client data: %v", ErrMalformed, err)
+	}
+
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("%w: invalid challenge", ErrMalformed)
+	}
+
+	return challenge, nil
+}
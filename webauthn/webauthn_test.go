@@ -0,0 +1,268 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nicolasbonnici/gorest-auth/webauthn"
+	"github.com/nicolasbonnici/gorest-auth/webauthn/webauthntest"
+)
+
+const testOrigin = "https://app.example.com"
+
+func newTestRelyingParty(t *testing.T) *webauthn.RelyingParty {
+	t.Helper()
+
+	rp, err := webauthn.New(webauthn.Config{RPID: "example.com", Origins: []string{testOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func newChallenge(t *testing.T) []byte {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func creationOptions(t *testing.T, rp *webauthn.RelyingParty) (*webauthn.CreationOptions, []byte) {
+	t.Helper()
+
+	challenge := newChallenge(t)
+	user := webauthn.User{ID: []byte("user-handle"), Name: "jane@example.com", DisplayName: "Jane Doe"}
+	return rp.CreationOptions(user, challenge, nil, webauthn.UserVerificationPreferred), challenge
+}
+
+// register runs a registration ceremony and returns the new credential.
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+
+	options, challenge := creationOptions(t, rp)
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := rp.VerifyRegistration(response, challenge, true)
+	if err != nil {
+		t.Fatalf("registration rejected: %v", err)
+	}
+	return credential
+}
+
+func assertion(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator, credential *webauthn.Credential) (*webauthn.AuthenticationResponse, []byte) {
+	t.Helper()
+
+	challenge := newChallenge(t)
+	allow := []webauthn.CredentialDescriptor{{Type: "public-key", ID: credential.ID}}
+	response, err := authenticator.Get(rp.RequestOptions(challenge, allow, webauthn.UserVerificationRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, challenge
+}
+
+func TestRegistrationAndAuthentication(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := webauthntest.New(testOrigin)
+
+	credential := register(t, rp, authenticator)
+	if !credential.UserVerified || len(credential.ID) == 0 || credential.SignCount != 0 {
+		t.Fatalf("unexpected credential: %+v", credential)
+	}
+
+	for i := uint32(1); i <= 2; i++ {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		if got, err := response.Challenge(); err != nil || string(got) != string(challenge) {
+			t.Fatalf("unexpected response challenge: %v", err)
+		}
+
+		result, err := rp.VerifyAuthentication(response, challenge, credential, true)
+		if err != nil {
+			t.Fatalf("authentication rejected: %v", err)
+		}
+		if result.SignCount != i || !result.UserVerified {
+			t.Fatalf("unexpected assertion: %+v", result)
+		}
+		credential.SignCount = result.SignCount
+	}
+}
+
+func TestRegistrationRejections(t *testing.T) {
+	rp := newTestRelyingParty(t)
+
+	t.Run("wrong origin", func(t *testing.T) {
+		options, challenge := creationOptions(t, rp)
+		response, err := webauthntest.New("https://evil.example.com").Create(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyRegistration(response, challenge, true); !errors.Is(err, webauthn.ErrOriginMismatch) {
+			t.Fatalf("expected ErrOriginMismatch, got %v", err)
+		}
+	})
+
+	t.Run("wrong rpId hash", func(t *testing.T) {
+		options, challenge := creationOptions(t, rp)
+		options.RP.ID = "evil.com"
+		response, err := webauthntest.New(testOrigin).Create(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyRegistration(response, challenge, true); !errors.Is(err, webauthn.ErrRPIDMismatch) {
+			t.Fatalf("expected ErrRPIDMismatch, got %v", err)
+		}
+	})
+
+	t.Run("wrong challenge", func(t *testing.T) {
+		options, _ := creationOptions(t, rp)
+		response, err := webauthntest.New(testOrigin).Create(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyRegistration(response, newChallenge(t), true); !errors.Is(err, webauthn.ErrChallengeMismatch) {
+			t.Fatalf("expected ErrChallengeMismatch, got %v", err)
+		}
+	})
+
+	t.Run("user not verified", func(t *testing.T) {
+		options, challenge := creationOptions(t, rp)
+		authenticator := webauthntest.New(testOrigin)
+		authenticator.UserVerified = false
+		response, err := authenticator.Create(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyRegistration(response, challenge, true); !errors.Is(err, webauthn.ErrUserNotVerified) {
+			t.Fatalf("expected ErrUserNotVerified, got %v", err)
+		}
+	})
+
+	t.Run("truncated attestation object", func(t *testing.T) {
+		options, challenge := creationOptions(t, rp)
+		response, err := webauthntest.New(testOrigin).Create(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		attestationObject := response.Response.AttestationObject
+		for size := 0; size < len(attestationObject); size++ {
+			response.Response.AttestationObject = attestationObject[:size]
+			if _, err := rp.VerifyRegistration(response, challenge, true); err == nil {
+				t.Fatalf("attestation object truncated to %d bytes accepted", size)
+			}
+		}
+	})
+
+	t.Run("forged self attestation", func(t *testing.T) {
+		options, challenge := creationOptions(t, rp)
+		response, err := webauthntest.New(testOrigin).Create(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Equivalent client data, but not the bytes the attestation signed.
+		clientDataJSON := response.Response.ClientDataJSON
+		response.Response.ClientDataJSON = append(append(webauthn.Base64URL(nil), clientDataJSON[:len(clientDataJSON)-1]...), ' ', '}')
+		if _, err := rp.VerifyRegistration(response, challenge, true); !errors.Is(err, webauthn.ErrSignatureInvalid) {
+			t.Fatalf("expected ErrSignatureInvalid, got %v", err)
+		}
+	})
+}
+
+func TestAuthenticationRejections(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := webauthntest.New(testOrigin)
+	credential := register(t, rp, authenticator)
+
+	t.Run("wrong origin", func(t *testing.T) {
+		authenticator.Origin = "https://evil.example.com"
+		defer func() { authenticator.Origin = testOrigin }()
+
+		response, challenge := assertion(t, rp, authenticator, credential)
+		if _, err := rp.VerifyAuthentication(response, challenge, credential, true); !errors.Is(err, webauthn.ErrOriginMismatch) {
+			t.Fatalf("expected ErrOriginMismatch, got %v", err)
+		}
+	})
+
+	t.Run("wrong rpId hash", func(t *testing.T) {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		response.Response.AuthenticatorData[0] ^= 0xff
+		if _, err := rp.VerifyAuthentication(response, challenge, credential, true); !errors.Is(err, webauthn.ErrRPIDMismatch) {
+			t.Fatalf("expected ErrRPIDMismatch, got %v", err)
+		}
+	})
+
+	t.Run("tampered authenticator data", func(t *testing.T) {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		response.Response.AuthenticatorData[32] |= webauthn.FlagBackedUp
+		if _, err := rp.VerifyAuthentication(response, challenge, credential, true); !errors.Is(err, webauthn.ErrSignatureInvalid) {
+			t.Fatalf("expected ErrSignatureInvalid, got %v", err)
+		}
+	})
+
+	t.Run("sign count regression", func(t *testing.T) {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		result, err := rp.VerifyAuthentication(response, challenge, credential, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credential.SignCount = result.SignCount
+
+		// A replayed response does not increase the counter.
+		if _, err := rp.VerifyAuthentication(response, challenge, credential, true); !errors.Is(err, webauthn.ErrSignCount) {
+			t.Fatalf("expected ErrSignCount for a replay, got %v", err)
+		}
+
+		// A cloned authenticator lags behind the stored counter.
+		response, challenge = assertion(t, rp, authenticator, credential)
+		cloned := *credential
+		cloned.SignCount += 10
+		if _, err := rp.VerifyAuthentication(response, challenge, &cloned, true); !errors.Is(err, webauthn.ErrSignCount) {
+			t.Fatalf("expected ErrSignCount for a lagging counter, got %v", err)
+		}
+	})
+
+	t.Run("other credential", func(t *testing.T) {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		other := *credential
+		other.ID = []byte("other-credential")
+		if _, err := rp.VerifyAuthentication(response, challenge, &other, true); !errors.Is(err, webauthn.ErrCredentialMismatch) {
+			t.Fatalf("expected ErrCredentialMismatch, got %v", err)
+		}
+	})
+
+	t.Run("truncated authenticator data", func(t *testing.T) {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		authData := response.Response.AuthenticatorData
+		for size := 0; size < len(authData); size++ {
+			response.Response.AuthenticatorData = authData[:size]
+			if _, err := rp.VerifyAuthentication(response, challenge, credential, true); !errors.Is(err, webauthn.ErrMalformed) {
+				t.Fatalf("authenticator data truncated to %d bytes: expected ErrMalformed, got %v", size, err)
+			}
+		}
+	})
+}
+
+func TestAuthenticatorWithoutCounter(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := webauthntest.New(testOrigin)
+	authenticator.CounterDisabled = true
+	credential := register(t, rp, authenticator)
+
+	for range 2 {
+		response, challenge := assertion(t, rp, authenticator, credential)
+		result, err := rp.VerifyAuthentication(response, challenge, credential, true)
+		if err != nil {
+			t.Fatalf("authentication rejected: %v", err)
+		}
+		if result.SignCount != 0 {
+			t.Fatalf("unexpected sign count %d", result.SignCount)
+		}
+	}
+}
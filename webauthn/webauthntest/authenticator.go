@@ -0,0 +1,247 @@
+// Package webauthntest provides a software authenticator to run WebAuthn
+// ceremonies in tests, without a browser.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/nicolasbonnici/gorest-auth/webauthn"
+)
+
+// Authenticator is a platform authenticator holding ES256 discoverable
+// credentials. It verifies the user unless told otherwise.
+type Authenticator struct {
+	// Origin is the origin reported in the client data.
+	Origin string
+	// UserVerified sets the UV flag on responses.
+	UserVerified bool
+	// CounterDisabled reports a zero signature counter, like synced passkeys.
+	CounterDisabled bool
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true}
+}
+
+// Create answers navigator.credentials.create() with a new credential.
+func (a *Authenticator) Create(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	if !slices.ContainsFunc(options.PubKeyCredParams, func(param webauthn.CredentialParameter) bool {
+		return param.Alg == webauthn.AlgES256
+	}) {
+		return nil, fmt.Errorf("webauthntest: ES256 is not allowed")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(options.RP.ID, excluded.ID) != nil {
+			return nil, fmt.Errorf("webauthntest: credential already registered")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	cred := &credential{id: id, rpID: options.RP.ID, userHandle: options.User.ID, key: key}
+	a.credentials = append(a.credentials, cred)
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	publicKey := encodeMap([][2][]byte{
+		{encodeInt(1), encodeInt(2)},
+		{encodeInt(3), encodeInt(webauthn.AlgES256)},
+		{encodeInt(-1), encodeInt(1)},
+		{encodeInt(-2), encodeBytes(x)},
+		{encodeInt(-3), encodeBytes(y)},
+	})
+
+	authData := a.authData(cred, webauthn.FlagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, publicKey...)
+
+	signature, err := sign(key, authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationObject := encodeMap([][2][]byte{
+		{encodeText("fmt"), encodeText("packed")},
+		{encodeText("attStmt"), encodeMap([][2][]byte{
+			{encodeText("alg"), encodeInt(webauthn.AlgES256)},
+			{encodeText("sig"), encodeBytes(signature)},
+		})},
+		{encodeText("authData"), encodeBytes(authData)},
+	})
+
+	return &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAttestationResponse{
+			ClientDataJSON:    clientDataJSON,
+			AttestationObject: attestationObject,
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+// Get answers navigator.credentials.get() with the first matching
+// credential, or any credential of the RP when none is listed.
+func (a *Authenticator) Get(options *webauthn.RequestOptions) (*webauthn.AuthenticationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *credential
+	if len(options.AllowCredentials) == 0 {
+		for _, candidate := range a.credentials {
+			if candidate.rpID == options.RPID {
+				cred = candidate
+				break
+			}
+		}
+	}
+	for _, allowed := range options.AllowCredentials {
+		if cred = a.find(options.RPID, allowed.ID); cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return nil, fmt.Errorf("webauthntest: no credential for %s", options.RPID)
+	}
+
+	if !a.CounterDisabled {
+		cred.signCount++
+	}
+
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authData(cred, 0)
+	signature, err := sign(cred.key, authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.AuthenticationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(cred.id),
+		RawID: cred.id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authData,
+			Signature:         signature,
+			UserHandle:        cred.userHandle,
+		},
+	}, nil
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, cred := range a.credentials {
+		if cred.rpID == rpID && string(cred.id) == string(id) {
+			return cred
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) ([]byte, error) {
+	return json.Marshal(webauthn.ClientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	})
+}
+
+func (a *Authenticator) authData(cred *credential, flags byte) []byte {
+	flags |= webauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, cred.signCount)
+}
+
+func sign(key *ecdsa.PrivateKey, authData, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, key, digest[:])
+}
+
+func encodeHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+	}
+}
+
+func encodeInt(value int64) []byte {
+	if value < 0 {
+		return encodeHead(1, uint64(-1-value))
+	}
+	return encodeHead(0, uint64(value))
+}
+
+func encodeBytes(value []byte) []byte {
+	return append(encodeHead(2, uint64(len(value))), value...)
+}
+
+func encodeText(value string) []byte {
+	return append(encodeHead(3, uint64(len(value))), value...)
+}
+
+func encodeMap(entries [][2][]byte) []byte {
+	out := encodeHead(5, uint64(len(entries)))
+	for _, entry := range entries {
+		out = append(out, entry[0]...)
+		out = append(out, entry[1]...)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nicolasbonnici/gorest-auth/tokens"
+)
+
+func newTestWebAuthnApp(t *testing.T) (*fiber.App, *AuthPlugin) {
+	t.Helper()
+
+	app, plugin, _ := newTestApp(t, map[string]interface{}{
+		"webauthn_rp_id":   "example.com",
+		"webauthn_origins": []interface{}{"https://app.example.com"},
+	})
+	return app, plugin
+}
+
+func TestWebAuthnCredentialChangesRequirePassword(t *testing.T) {
+	app, plugin := newTestWebAuthnApp(t)
+	createTestUser(t, plugin.db, "jane@example.com", "user")
+	token, _ := login(t, app, "jane@example.com", testPassword)
+	credentialPath := "/auth/webauthn/credentials/" + uuid.New().String()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     map[string]string
+		expected int
+	}{
+		{"register without password", "POST", "/auth/webauthn/register/begin", nil, fiber.StatusBadRequest},
+		{"register with wrong password", "POST", "/auth/webauthn/register/begin", map[string]string{"current_password": "wrong-password"}, fiber.StatusForbidden},
+		{"register", "POST", "/auth/webauthn/register/begin", map[string]string{"current_password": testPassword}, fiber.StatusOK},
+		{"delete without password", "DELETE", credentialPath, nil, fiber.StatusBadRequest},
+		{"delete with wrong password", "DELETE", credentialPath, map[string]string{"current_password": "wrong-password"}, fiber.StatusForbidden},
+		{"delete", "DELETE", credentialPath, map[string]string{"current_password": testPassword}, fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		if status, result := request(t, app, tt.method, tt.path, token, tt.body); status != tt.expected {
+			t.Fatalf("%s: expected %d, got %d %v", tt.name, tt.expected, status, result)
+		}
+	}
+}
+
+func TestWebAuthnCredentialChangesWithoutPassword(t *testing.T) {
+	app, plugin := newTestWebAuthnApp(t)
+	user := createTestUserWithoutPassword(t, plugin.db, "jane@example.com")
+	ctx := context.Background()
+
+	stale := tokens.NewAuthentication(tokens.AMREmail)
+	stale.Time = time.Now().Add(-mfaEnrollmentMaxAge - time.Minute)
+	credentialPath := "/auth/webauthn/credentials/" + uuid.New().String()
+
+	tests := []struct {
+		name       string
+		opts       []TokenOption
+		register   int
+		deleteCred int
+	}{
+		{"unknown login", nil, fiber.StatusUnauthorized, fiber.StatusUnauthorized},
+		{"stale login", []TokenOption{WithAuthentication(stale)}, fiber.StatusUnauthorized, fiber.StatusUnauthorized},
+		{"recent login", []TokenOption{WithAuthentication(tokens.NewAuthentication(tokens.AMREmail))}, fiber.StatusOK, fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		token, err := plugin.TokenService().GenerateToken(ctx, user, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, result := request(t, app, "POST", "/auth/webauthn/register/begin", token, nil); status != tt.register {
+			t.Fatalf("%s: expected %d on register, got %d %v", tt.name, tt.register, status, result)
+		}
+		if status, result := request(t, app, "DELETE", credentialPath, token, nil); status != tt.deleteCred {
+			t.Fatalf("%s: expected %d on delete, got %d %v", tt.name, tt.deleteCred, status, result)
+		}
+	}
+}